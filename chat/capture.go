@@ -0,0 +1,184 @@
+// Package chat captures the chat and the events of a model room during
+// a recording. The chat is written as subtitles, so it replays in sync
+// with the video, the tips, topic and show state changes as a JSON lines
+// log with the offsets in the recording.
+package chat
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/fcs"
+	"gomfc/ws_client"
+)
+
+// emotePattern matches the emote codes of the messages, e.g. #~ue,smile~#.
+var emotePattern = regexp.MustCompile(`#~[^~]*~#`)
+
+// Message is a chat message at Offset in the recording.
+type Message struct {
+	Offset time.Duration
+	Name   string
+	Text   string
+}
+
+// Clock returns the position in the recording of the current moment,
+// ok is false while it is unknown, e.g. before the first video tag.
+type Clock func() (position time.Duration, ok bool)
+
+// Capture collects the chat messages and the events of a model room.
+type Capture struct {
+	clock   Clock
+	channel int64
+	conn    *ws_client.WSConnector
+	done    chan struct{}
+
+	mu       sync.Mutex
+	room     roomEvents
+	messages []Message
+	events   []Event
+	onEvent  func(Event)
+	err      error
+}
+
+// StartCapture logs in to the chat server, joins the room of the model
+// and collects the messages until Stop or the context is cancelled. Every
+// message is placed at the position returned by clock when it is
+// received, messages received while the position is unknown are dropped.
+// Events received while the position is unknown are placed at 0.
+func StartCapture(ctx context.Context, cfg ws_client.ClientConfig, modelName string, uid uint64, clock Clock) (c *Capture, err error) {
+	conn, err := ws_client.NewChatConnectionContext(ctx, cfg, modelName)
+	if err != nil {
+		return
+	}
+	if err = conn.JoinRoom(uid); err != nil {
+		conn.Close()
+		return
+	}
+	channel := int64(ws_client.RoomChannel(uid))
+	c = &Capture{
+		clock:   clock,
+		channel: channel,
+		conn:    conn,
+		done:    make(chan struct{}),
+		room:    roomEvents{uid: uid, channel: channel},
+	}
+	conn.SetMsgHdlr(c.handle)
+	go func() {
+		defer close(c.done)
+		err := conn.ReadForeverContext(ctx)
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+	}()
+	return
+}
+
+func (c *Capture) handle(msg string) error {
+	frames, _ := fcs.ParseMessage(msg)
+	for _, frame := range frames {
+		if frame.Type == fcs.FCTYPE_CMESG {
+			c.addMessage(frame)
+			continue
+		}
+		c.mu.Lock()
+		events := c.room.decode(frame)
+		c.mu.Unlock()
+		if len(events) > 0 {
+			c.addEvents(events)
+		}
+	}
+	return nil
+}
+
+func (c *Capture) addMessage(frame fcs.Frame) {
+	if frame.To != c.channel || !frame.IsJSON() {
+		return
+	}
+	position, ok := c.clock()
+	if !ok {
+		return
+	}
+	var chat fcs.ChatMessage
+	if frame.Unmarshal(&chat) != nil {
+		return
+	}
+	text := cleanText(chat.Msg)
+	if text == "" {
+		return
+	}
+	c.mu.Lock()
+	c.messages = append(c.messages, Message{Offset: position, Name: chat.Nm, Text: text})
+	c.mu.Unlock()
+}
+
+func (c *Capture) addEvents(events []Event) {
+	position, _ := c.clock()
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range events {
+		event.Offset = position
+		event.Time = now
+		c.events = append(c.events, event)
+		if c.onEvent != nil {
+			c.onEvent(event)
+		}
+	}
+}
+
+// SetEventHandler sets the function called with every event as it is
+// received, it is called with the events collected so far first. The
+// calls are in order and the last one returns before Stop returns, the
+// handler must not call the methods of the capture.
+func (c *Capture) SetEventHandler(handler func(Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvent = handler
+	for _, event := range c.events {
+		handler(event)
+	}
+}
+
+// cleanText removes the emote codes and joins the lines of the message.
+func cleanText(text string) string {
+	text = emotePattern.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Messages returns the messages collected so far.
+func (c *Capture) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
+
+// Events returns the room events collected so far.
+func (c *Capture) Events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+// Stop leaves the room and returns the collected messages.
+func (c *Capture) Stop() []Message {
+	c.conn.Close()
+	<-c.done
+	return c.Messages()
+}
+
+// Done is closed when the capture has stopped, see Err.
+func (c *Capture) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection was closed, it is set after Done
+// is closed.
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
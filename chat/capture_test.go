@@ -0,0 +1,107 @@
+package chat_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gomfc/chat"
+	"gomfc/models"
+	"gomfc/ws_client/fcstest"
+)
+
+const testTimeout = 5 * time.Second
+
+// testClock is unknown until it is set, as before the first video tag.
+type testClock struct {
+	sync.Mutex
+	position time.Duration
+	ok       bool
+}
+
+func (c *testClock) set(position time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.position, c.ok = position, true
+}
+
+func (c *testClock) now() (time.Duration, bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.position, c.ok
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	server := fcstest.NewServer()
+	defer server.Close()
+	m := fcstest.Model{Lv: fcstest.ModelLv, Nm: "TestModel", Uid: 100500, Sid: 42, Vs: models.IsOnline}
+	m.U.Camserv = 1544
+	server.SetModel(m)
+
+	clock := &testClock{}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	capture, err := chat.StartCapture(ctx, server.ClientConfig(), "TestModel", m.Uid, clock.now)
+	if err != nil {
+		t.Fatalf("StartCapture error: %s", err)
+	}
+	waitFor(t, "room join", func() bool { return server.Joined(m.Uid) == 1 })
+
+	// frames are handled in order, the tip marks the early chat as handled
+	server.Chat(m.Uid, "early", "before the video")
+	server.Chat(m.Uid+1, "other", "another room")
+	server.Tip(m.Uid+1, "other", 10, "another room")
+	server.Tip(m.Uid, "alice", 25, "nice #~ue,heart~#")
+	waitFor(t, "tip", func() bool { return len(capture.Events()) == 2 })
+	var handled []chat.EventType
+	capture.SetEventHandler(func(event chat.Event) {
+		handled = append(handled, event.Type)
+	})
+
+	clock.set(90 * time.Second)
+	m.Vs = models.IsAway
+	server.SetModel(m)
+	server.Chat(m.Uid, "bob", "hello #~ue,smile~# there")
+	waitFor(t, "message", func() bool { return len(capture.Messages()) == 1 })
+
+	messages := capture.Stop()
+	if len(messages) != 1 {
+		t.Fatalf("messages: %+v", messages)
+	}
+	want := chat.Message{Offset: 90 * time.Second, Name: "bob", Text: "hello there"}
+	if messages[0] != want {
+		t.Fatalf("message %+v, want %+v", messages[0], want)
+	}
+	events := capture.Events()
+	if len(events) != 3 {
+		t.Fatalf("events: %+v", events)
+	}
+	if len(handled) != 3 || handled[0] != chat.EventState || handled[1] != chat.EventTip || handled[2] != chat.EventState {
+		t.Errorf("handled events: %v", handled)
+	}
+	if e := events[0]; e.Type != chat.EventState || e.State != "online" || e.Offset != 0 {
+		t.Errorf("initial state: %+v", e)
+	}
+	if e := events[1]; e.Type != chat.EventTip || e.Name != "alice" || e.Tokens != 25 || e.Text != "nice" || e.Offset != 0 {
+		t.Errorf("tip: %+v", e)
+	}
+	if e := events[2]; e.Type != chat.EventState || e.State != "away" || e.Offset != 90*time.Second {
+		t.Errorf("state change: %+v", e)
+	}
+	select {
+	case <-capture.Done():
+	default:
+		t.Fatal("capture not done after Stop")
+	}
+}
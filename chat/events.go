@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gomfc/fcs"
+	"gomfc/models"
+)
+
+// EventType is the kind of a room event.
+type EventType string
+
+const (
+	EventTip   EventType = "tip"
+	EventTopic EventType = "topic"
+	EventState EventType = "state"
+)
+
+// Event is a room event at Offset in the recording. Only the fields of
+// the type are set: Name, Tokens and Text for a tip, Topic for a topic
+// change, State and Vs for a show state change. WriteEvents writes the
+// offset in seconds.
+type Event struct {
+	Offset time.Duration `json:"-"`
+	Time   time.Time     `json:"time"`
+	Type   EventType     `json:"type"`
+	Name   string        `json:"name,omitempty"`
+	Tokens int64         `json:"tokens,omitempty"`
+	Text   string        `json:"text,omitempty"`
+	Topic  string        `json:"topic,omitempty"`
+	State  string        `json:"state,omitempty"`
+	Vs     *uint64       `json:"vs,omitempty"`
+}
+
+// roomState is a SESSIONSTATE update or a lookup result, the updates
+// carry only the changed fields.
+type roomState struct {
+	Uid uint64  `json:"uid"`
+	Vs  *uint64 `json:"vs"`
+	M   *struct {
+		Topic *string `json:"topic"`
+	} `json:"m"`
+}
+
+// roomEvents turns the frames of a room into events. State and topic
+// events are sent when they differ from the last known ones, the first
+// update of each is the state at the start of the recording.
+type roomEvents struct {
+	uid     uint64
+	channel int64
+	vs      *uint64
+	topic   *string
+}
+
+func (r *roomEvents) decode(frame fcs.Frame) (events []Event) {
+	switch frame.Type {
+	case fcs.FCTYPE_TOKENINC:
+		var tip fcs.Tip
+		if frame.Unmarshal(&tip) != nil || !r.tipOfModel(tip) {
+			return
+		}
+		event := Event{Type: EventTip, Tokens: tip.Tokens, Text: cleanText(tip.Msg)}
+		if len(tip.U) > 2 {
+			event.Name, _ = tip.U[2].(string)
+		}
+		events = append(events, event)
+	case fcs.FCTYPE_SESSIONSTATE, fcs.FCTYPE_DETAILS, fcs.FCTYPE_USERNAMELOOKUP:
+		var state roomState
+		if frame.Unmarshal(&state) != nil || state.Uid != r.uid {
+			return
+		}
+		if state.Vs != nil && (r.vs == nil || *r.vs != *state.Vs) {
+			r.vs = state.Vs
+			events = append(events, Event{Type: EventState, State: models.StatusVerbose[*state.Vs], Vs: state.Vs})
+		}
+		if state.M != nil && state.M.Topic != nil && (r.topic == nil || *r.topic != *state.M.Topic) {
+			r.topic = state.M.Topic
+			events = append(events, Event{Type: EventTopic, Topic: unescapeTopic(*state.M.Topic)})
+		}
+	}
+	return
+}
+
+// tipOfModel reports whether the tip was sent in the room or to the model.
+func (r *roomEvents) tipOfModel(tip fcs.Tip) bool {
+	if tip.Ch != 0 {
+		return int64(tip.Ch) == r.channel
+	}
+	if len(tip.M) == 0 {
+		return false
+	}
+	uid, ok := tip.M[0].(float64)
+	return ok && uint64(uid) == r.uid
+}
+
+// unescapeTopic decodes the topic, it is sent escaped.
+func unescapeTopic(topic string) string {
+	if unescaped, err := url.QueryUnescape(topic); err == nil {
+		topic = unescaped
+	}
+	return strings.TrimSpace(topic)
+}
+
+// EventsPath returns the path of the room events of the recording.
+func EventsPath(recording string) string {
+	return SidecarPath(recording, "events.jsonl")
+}
+
+// eventLine is an event with the offset in seconds, so players can seek
+// to it.
+type eventLine struct {
+	Offset float64 `json:"offset"`
+	Event
+}
+
+// WriteEvents writes the events as JSON lines.
+func WriteEvents(w io.Writer, events []Event) error {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+	for _, event := range events {
+		if err := encoder.Encode(eventLine{Offset: event.Offset.Seconds(), Event: event}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// EventsFile is the events file of a recording written as the events are
+// received, every event is written by Append at once.
+type EventsFile struct {
+	f       *os.File
+	encoder *json.Encoder
+}
+
+// CreateEventsFile creates the events file next to the recording, see
+// EventsPath.
+func CreateEventsFile(recording string) (file *EventsFile, err error) {
+	f, err := os.Create(EventsPath(recording))
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetEscapeHTML(false)
+	return &EventsFile{f: f, encoder: encoder}, nil
+}
+
+// Path returns the path of the file.
+func (e *EventsFile) Path() string {
+	return e.f.Name()
+}
+
+// Append writes the event as a JSON line.
+func (e *EventsFile) Append(event Event) error {
+	return e.encoder.Encode(eventLine{Offset: event.Offset.Seconds(), Event: event})
+}
+
+func (e *EventsFile) Close() error {
+	return e.f.Close()
+}
+
+// WriteEventsFile writes the events next to the recording and returns
+// the path of the file.
+func WriteEventsFile(recording string, events []Event) (path string, err error) {
+	path = EventsPath(recording)
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	if err = WriteEvents(f, events); err != nil {
+		f.Close()
+		return
+	}
+	err = f.Close()
+	return
+}
@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gomfc/fcs"
+)
+
+func TestRoomEventsDecode(t *testing.T) {
+	room := &roomEvents{uid: 100500, channel: 100100500}
+	frames := []fcs.Frame{
+		{Type: fcs.FCTYPE_SESSIONSTATE, Payload: `{"uid":100500,"vs":0,"m":{"topic":"Hello%20there"}}`},
+		{Type: fcs.FCTYPE_SESSIONSTATE, Payload: `{"uid":100500,"vs":0,"m":{"topic":"Hello%20there"}}`},
+		{Type: fcs.FCTYPE_SESSIONSTATE, Payload: `{"uid":100501,"vs":12}`},
+		{Type: fcs.FCTYPE_SESSIONSTATE, Payload: `{"uid":100500,"m":{"camscore":10}}`},
+		{Type: fcs.FCTYPE_SESSIONSTATE, Payload: `{"uid":100500,"vs":12}`},
+		{Type: fcs.FCTYPE_TOKENINC, Payload: `{"m":[100500,42,"TestModel"],"u":[1,2,"bob"],"tokens":5}`},
+		{Type: fcs.FCTYPE_TOKENINC, Payload: `{"ch":100100501,"u":[1,2,"bob"],"tokens":5}`},
+		{Type: fcs.FCTYPE_USERNAMELOOKUP, Payload: "Unknown"},
+	}
+	var events []Event
+	for _, frame := range frames {
+		events = append(events, room.decode(frame)...)
+	}
+	want := []Event{
+		{Type: EventState, State: "online"},
+		{Type: EventTopic, Topic: "Hello there"},
+		{Type: EventState, State: "in private"},
+		{Type: EventTip, Name: "bob", Tokens: 5},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events: %+v", events)
+	}
+	for i, event := range events {
+		event.Vs = nil
+		if event != want[i] {
+			t.Errorf("event %d: %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	vs := uint64(2)
+	events := []Event{
+		{Offset: 1500 * time.Millisecond, Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Type: EventTip, Name: "bob", Tokens: 10, Text: "<3"},
+		{Offset: time.Hour, Time: time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC), Type: EventState, State: "away", Vs: &vs},
+	}
+	var buf bytes.Buffer
+	if err := WriteEvents(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"offset":1.5,"time":"2024-01-02T03:04:05Z","type":"tip","name":"bob","tokens":10,"text":"<3"}` + "\n" +
+		`{"offset":3600,"time":"2024-01-02T04:04:05Z","type":"state","state":"away","vs":2}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("events:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if path := EventsPath("streams/bob/1.flv"); path != "streams/bob/1.events.jsonl" {
+		t.Fatalf("path: %s", path)
+	}
+}
+
+func TestEventsFile(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "1.flv")
+	file, err := CreateEventsFile(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := file.Append(Event{Offset: 2 * time.Second, Time: at, Type: EventTopic, Topic: "a&b"}); err != nil {
+		t.Fatal(err)
+	}
+	// the line is in the file before the recording is finished
+	data, err := ioutil.ReadFile(EventsPath(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"offset":2,"time":"2024-01-02T03:04:05Z","type":"topic","topic":"a&b"}` + "\n"
+	if string(data) != want || file.Path() != EventsPath(recording) {
+		t.Fatalf("events file %s:\n%s\nwant:\n%s", file.Path(), data, want)
+	}
+}
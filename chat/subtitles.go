@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultDisplay is how long a message is shown.
+const DefaultDisplay = 5 * time.Second
+
+// Format is a subtitle format, it is also the extension of the file.
+type Format string
+
+const (
+	FormatSRT Format = "srt"
+	FormatVTT Format = "vtt"
+	FormatASS Format = "ass"
+)
+
+var knownFormats = map[Format]bool{
+	FormatSRT: true,
+	FormatVTT: true,
+	FormatASS: true,
+}
+
+// ParseFormats parses a comma separated list of formats, e.g. "srt,vtt".
+func ParseFormats(list string) (parsed []Format, err error) {
+	for _, name := range strings.Split(list, ",") {
+		format := Format(strings.ToLower(strings.TrimSpace(name)))
+		if format == "" {
+			continue
+		}
+		if !knownFormats[format] {
+			return nil, fmt.Errorf("unknown subtitle format %q", name)
+		}
+		parsed = append(parsed, format)
+	}
+	return
+}
+
+// Cue is a subtitle shown from Start to End.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Name  string
+	Text  string
+}
+
+// Cues returns the cues of the messages, each one is shown for display.
+func Cues(messages []Message, display time.Duration) (cues []Cue) {
+	for _, message := range messages {
+		cues = append(cues, Cue{
+			Start: message.Offset,
+			End:   message.Offset + display,
+			Name:  message.Name,
+			Text:  message.Text,
+		})
+	}
+	return
+}
+
+// SegmentMessages returns the messages in [start, end) with the offsets in
+// the segment of the recording starting at start, end 0 is the end of the
+// recording.
+func SegmentMessages(messages []Message, start, end time.Duration) (segment []Message) {
+	for _, message := range messages {
+		if message.Offset < start || end > 0 && message.Offset >= end {
+			continue
+		}
+		message.Offset -= start
+		segment = append(segment, message)
+	}
+	return
+}
+
+// Write writes the cues in the format.
+func Write(w io.Writer, format Format, cues []Cue) error {
+	switch format {
+	case FormatSRT:
+		return WriteSRT(w, cues)
+	case FormatVTT:
+		return WriteVTT(w, cues)
+	case FormatASS:
+		return WriteASS(w, cues)
+	}
+	return fmt.Errorf("unknown subtitle format %q", format)
+}
+
+// WriteSRT writes the cues as SubRip.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	for i, cue := range cues {
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", i+1,
+			clockTime(cue.Start, ",", 3), clockTime(cue.End, ",", 3), cueText(cue))
+	}
+	return bw.Flush()
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteVTT writes the cues as WebVTT, the sender is a voice span.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(bw, "%s --> %s\n", clockTime(cue.Start, ".", 3), clockTime(cue.End, ".", 3))
+		if cue.Name != "" {
+			fmt.Fprintf(bw, "<v %s>%s: %s\n\n", vttEscaper.Replace(cue.Name),
+				vttEscaper.Replace(cue.Name), vttEscaper.Replace(cue.Text))
+		} else {
+			fmt.Fprintf(bw, "%s\n\n", vttEscaper.Replace(cue.Text))
+		}
+	}
+	return bw.Flush()
+}
+
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 640
+PlayResY: 360
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,16,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,1,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// assEscaper removes the override blocks and the escapes of the text.
+var assEscaper = strings.NewReplacer("{", "(", "}", ")", `\`, "/")
+
+// WriteASS writes the cues as Advanced SubStation Alpha, the messages are
+// shown at the bottom left.
+func WriteASS(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(assHeader)
+	for _, cue := range cues {
+		fmt.Fprintf(bw, "Dialogue: 0,%s,%s,Default,%s,0,0,0,,%s\n",
+			assTime(cue.Start), assTime(cue.End),
+			strings.Replace(assEscaper.Replace(cue.Name), ",", " ", -1), assEscaper.Replace(cueText(cue)))
+	}
+	return bw.Flush()
+}
+
+func cueText(cue Cue) string {
+	if cue.Name == "" {
+		return cue.Text
+	}
+	return cue.Name + ": " + cue.Text
+}
+
+// clockTime formats the duration as hh:mm:ss with the fraction of
+// the second after the separator.
+func clockTime(d time.Duration, separator string, digits int) string {
+	if d < 0 {
+		d = 0
+	}
+	unit := time.Second
+	for i := 0; i < digits; i++ {
+		unit /= 10
+	}
+	fraction := d % time.Second / unit
+	seconds := int64(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d%s%0*d", seconds/3600, seconds/60%60, seconds%60, separator, digits, fraction)
+}
+
+// assTime formats the duration as h:mm:ss.cc.
+func assTime(d time.Duration) string {
+	return strings.TrimPrefix(clockTime(d, ".", 2), "0")
+}
+
+// SidecarPath returns the path of the subtitles of the recording.
+func SidecarPath(recording string, format Format) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + "." + string(format)
+}
+
+// WriteFiles writes the messages in every format next to the recording
+// and returns the paths of the files.
+func WriteFiles(recording string, formats []Format, messages []Message) (paths []string, err error) {
+	cues := Cues(messages, DefaultDisplay)
+	for _, format := range formats {
+		path := SidecarPath(recording, format)
+		if err = writeFile(path, format, cues); err != nil {
+			return
+		}
+		paths = append(paths, path)
+	}
+	return
+}
+
+func writeFile(path string, format Format, cues []Cue) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = Write(f, format, cues); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCues = []Cue{
+	{Start: 1500 * time.Millisecond, End: 6500 * time.Millisecond, Name: "bob", Text: "hi <3"},
+	{Start: 36*time.Hour + 2*time.Minute, End: 36*time.Hour + 2*time.Minute + 5*time.Second, Name: "a,b", Text: "{\\b1}bold"},
+}
+
+func TestWriteSRT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSRT(&buf, testCues); err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:01,500 --> 00:00:06,500\nbob: hi <3\n\n" +
+		"2\n36:02:00,000 --> 36:02:05,000\na,b: {\\b1}bold\n\n"
+	if buf.String() != want {
+		t.Fatalf("srt:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteVTT(&buf, testCues[:1]); err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n00:00:01.500 --> 00:00:06.500\n<v bob>bob: hi &lt;3\n\n"
+	if buf.String() != want {
+		t.Fatalf("vtt:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteASS(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteASS(&buf, testCues); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "[Script Info]\n") {
+		t.Fatalf("ass header:\n%s", out)
+	}
+	for _, want := range []string{
+		"Dialogue: 0,0:00:01.50,0:00:06.50,Default,bob,0,0,0,,bob: hi <3\n",
+		"Dialogue: 0,36:02:00.00,36:02:05.00,Default,a b,0,0,0,,a,b: (/b1)bold\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("ass without %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	formats, err := ParseFormats(" SRT, vtt,,ass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(formats) != 3 || formats[0] != FormatSRT || formats[1] != FormatVTT || formats[2] != FormatASS {
+		t.Fatalf("formats: %v", formats)
+	}
+	if _, err = ParseFormats("srt,sub"); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
+
+func TestSidecarPath(t *testing.T) {
+	if path := SidecarPath("streams/bob/2024-01-02_001.flv", FormatVTT); path != "streams/bob/2024-01-02_001.vtt" {
+		t.Fatalf("path: %s", path)
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	if text := cleanText("hello #~ue,smile~#  there\nyou"); text != "hello there you" {
+		t.Fatalf("text: %q", text)
+	}
+}
+
+func TestSegmentMessages(t *testing.T) {
+	messages := []Message{
+		{Offset: 0, Name: "a", Text: "1"},
+		{Offset: 10 * time.Second, Name: "b", Text: "2"},
+		{Offset: 25 * time.Second, Name: "c", Text: "3"},
+	}
+	segment := SegmentMessages(messages, 10*time.Second, 20*time.Second)
+	if len(segment) != 1 || segment[0].Name != "b" || segment[0].Offset != 0 {
+		t.Errorf("segment [10s, 20s): %+v", segment)
+	}
+	segment = SegmentMessages(messages, 20*time.Second, 0)
+	if len(segment) != 1 || segment[0].Name != "c" || segment[0].Offset != 5*time.Second {
+		t.Errorf("last segment from 20s: %+v", segment)
+	}
+	if messages[1].Offset != 10*time.Second {
+		t.Error("the messages are modified")
+	}
+}
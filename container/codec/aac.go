@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"errors"
+)
+
+var ErrBadAudioConfig = errors.New("codec: malformed AudioSpecificConfig")
+var ErrBadADTS = errors.New("codec: malformed ADTS header")
+
+var aacSampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050,
+	16000, 12000, 11025, 8000, 7350,
+}
+
+// AudioSpecificConfig is the payload of an AAC sequence header.
+// Raw is the config as is, it is the decoder specific info of esds.
+type AudioSpecificConfig struct {
+	ObjectType int
+	FreqIndex  int
+	SampleRate int
+	Channels   int
+	Raw        []byte
+}
+
+func ParseAudioSpecificConfig(data []byte) (config *AudioSpecificConfig, err error) {
+	r := &bitReader{data: data}
+	config = &AudioSpecificConfig{Raw: append([]byte(nil), data...)}
+	config.ObjectType = int(r.bits(5))
+	if config.ObjectType == 31 {
+		config.ObjectType = 32 + int(r.bits(6))
+	}
+	config.FreqIndex = int(r.bits(4))
+	if config.FreqIndex == 15 {
+		config.SampleRate = int(r.bits(24))
+	} else if config.FreqIndex < len(aacSampleRates) {
+		config.SampleRate = aacSampleRates[config.FreqIndex]
+	}
+	config.Channels = int(r.bits(4))
+	if r.failed || config.SampleRate == 0 {
+		return nil, ErrBadAudioConfig
+	}
+	return
+}
+
+// NewAudioSpecificConfig builds the two byte config of the stream.
+func NewAudioSpecificConfig(objectType, freqIndex, channels int) *AudioSpecificConfig {
+	config := &AudioSpecificConfig{
+		ObjectType: objectType,
+		FreqIndex:  freqIndex,
+		Channels:   channels,
+		Raw:        []byte{byte(objectType<<3 | freqIndex>>1), byte(freqIndex<<7 | channels<<3)},
+	}
+	if freqIndex < len(aacSampleRates) {
+		config.SampleRate = aacSampleRates[freqIndex]
+	}
+	return config
+}
+
+// ParseADTS parses the ADTS header at the start of data. It returns the
+// config of the stream, the size of the header and the size of the frame
+// with the header.
+func ParseADTS(data []byte) (config *AudioSpecificConfig, headerLen, frameLen int, err error) {
+	if len(data) < 7 || data[0] != 0xff || data[1]&0xf0 != 0xf0 {
+		err = ErrBadADTS
+		return
+	}
+	headerLen = 7
+	if data[1]&0x01 == 0 {
+		// followed by the CRC
+		headerLen = 9
+	}
+	objectType := int(data[2]>>6) + 1
+	freqIndex := int(data[2]>>2) & 0x0f
+	channels := int(data[2]&0x01)<<2 | int(data[3]>>6)
+	frameLen = int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5]>>5)
+	if freqIndex >= len(aacSampleRates) || frameLen < headerLen {
+		err = ErrBadADTS
+		return
+	}
+	config = NewAudioSpecificConfig(objectType, freqIndex, channels)
+	return
+}
+
+// ADTSHeader returns the 7 byte ADTS header of a raw AAC frame
+// of frameLen bytes.
+func (c *AudioSpecificConfig) ADTSHeader(frameLen int) []byte {
+	size := frameLen + 7
+	profile := c.ObjectType - 1
+	if profile < 0 || profile > 3 {
+		profile = 1
+	}
+	freqIndex := c.FreqIndex
+	if freqIndex >= len(aacSampleRates) {
+		freqIndex = 4
+	}
+	return []byte{
+		0xff,
+		0xf1,
+		byte(profile<<6 | freqIndex<<2 | (c.Channels>>2)&0x01),
+		byte((c.Channels&0x03)<<6 | (size>>11)&0x03),
+		byte(size >> 3),
+		byte((size&0x07)<<5 | 0x1f),
+		0xfc,
+	}
+}
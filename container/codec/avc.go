@@ -0,0 +1,322 @@
+package codec
+
+import (
+	"errors"
+)
+
+var ErrBadAVCConfig = errors.New("codec: malformed AVC decoder configuration")
+var ErrBadSPS = errors.New("codec: malformed SPS")
+
+// AVCConfig is an AVCDecoderConfigurationRecord, the payload of an AVC
+// sequence header. Raw is the record as is, it is the avcC box content.
+type AVCConfig struct {
+	Profile       byte
+	Compatibility byte
+	Level         byte
+	LengthSize    int
+	SPS           [][]byte
+	PPS           [][]byte
+	Raw           []byte
+}
+
+func ParseAVCConfig(data []byte) (config *AVCConfig, err error) {
+	if len(data) < 7 || data[0] != 1 {
+		return nil, ErrBadAVCConfig
+	}
+	config = &AVCConfig{
+		Profile:       data[1],
+		Compatibility: data[2],
+		Level:         data[3],
+		LengthSize:    int(data[4]&0x03) + 1,
+		Raw:           append([]byte(nil), data...),
+	}
+	pos := 5
+	readSets := func(count int) (sets [][]byte, ok bool) {
+		for i := 0; i < count; i++ {
+			if pos+2 > len(data) {
+				return
+			}
+			size := int(data[pos])<<8 | int(data[pos+1])
+			pos += 2
+			if pos+size > len(data) {
+				return
+			}
+			sets = append(sets, append([]byte(nil), data[pos:pos+size]...))
+			pos += size
+		}
+		ok = true
+		return
+	}
+	var ok bool
+	count := int(data[pos] & 0x1f)
+	pos++
+	if config.SPS, ok = readSets(count); !ok {
+		return nil, ErrBadAVCConfig
+	}
+	if pos >= len(data) {
+		return nil, ErrBadAVCConfig
+	}
+	count = int(data[pos])
+	pos++
+	if config.PPS, ok = readSets(count); !ok {
+		return nil, ErrBadAVCConfig
+	}
+	return
+}
+
+// SPSInfo holds the fields of a sequence parameter set needed by muxers.
+type SPSInfo struct {
+	Profile byte
+	Level   byte
+	Width   int
+	Height  int
+}
+
+// ParseSPS parses a SPS NAL unit including its header byte.
+func ParseSPS(nalu []byte) (info SPSInfo, err error) {
+	if len(nalu) < 4 || nalu[0]&0x1f != 7 {
+		err = ErrBadSPS
+		return
+	}
+	r := &bitReader{data: removeEmulationPrevention(nalu[1:])}
+	info.Profile = byte(r.bits(8))
+	r.bits(8) // constraint flags
+	info.Level = byte(r.bits(8))
+	r.ue() // seq_parameter_set_id
+	chromaFormat := uint(1)
+	separateColourPlane := false
+	switch info.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			separateColourPlane = r.bits(1) == 1
+		}
+		r.ue() // bit_depth_luma_minus8
+		r.ue() // bit_depth_chroma_minus8
+		r.bits(1)
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				r.skipScalingList(size)
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1)
+		r.se()
+		r.se()
+		cycle := r.ue()
+		for i := uint(0); i < cycle && !r.failed; i++ {
+			r.se()
+		}
+	}
+	r.ue() // max_num_ref_frames
+	r.bits(1)
+	widthInMbs := r.ue() + 1
+	heightInMapUnits := r.ue() + 1
+	frameMbsOnly := r.bits(1)
+	if frameMbsOnly == 0 {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.bits(1) == 1 {
+		cropLeft = r.ue()
+		cropRight = r.ue()
+		cropTop = r.ue()
+		cropBottom = r.ue()
+	}
+	if r.failed {
+		err = ErrBadSPS
+		return
+	}
+	cropUnitX, cropUnitY := uint(1), 2-frameMbsOnly
+	if chromaFormat != 0 && !separateColourPlane {
+		subWidth, subHeight := uint(2), uint(2)
+		switch chromaFormat {
+		case 2:
+			subHeight = 1
+		case 3:
+			subWidth, subHeight = 1, 1
+		}
+		cropUnitX = subWidth
+		cropUnitY = subHeight * (2 - frameMbsOnly)
+	}
+	info.Width = int(widthInMbs*16 - cropUnitX*(cropLeft+cropRight))
+	info.Height = int((2-frameMbsOnly)*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom))
+	return
+}
+
+func removeEmulationPrevention(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+type bitReader struct {
+	data   []byte
+	pos    uint
+	failed bool
+}
+
+func (r *bitReader) bits(n uint) (value uint) {
+	for i := uint(0); i < n; i++ {
+		if r.pos >= uint(len(r.data))*8 {
+			r.failed = true
+			return
+		}
+		bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+		value = value<<1 | uint(bit)
+		r.pos++
+	}
+	return
+}
+
+func (r *bitReader) ue() uint {
+	zeros := uint(0)
+	for r.bits(1) == 0 {
+		if r.failed || zeros > 31 {
+			r.failed = true
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := 8, 8
+	for i := 0; i < size && !r.failed; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+var startCode = []byte{0, 0, 0, 1}
+
+// AnnexB converts length prefixed NAL units to the Annex B byte stream
+// format with start codes.
+func AnnexB(data []byte, lengthSize int) (out []byte, err error) {
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, ErrShortTag
+		}
+		size := 0
+		for i := 0; i < lengthSize; i++ {
+			size = size<<8 | int(data[i])
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return nil, ErrShortTag
+		}
+		out = append(out, startCode...)
+		out = append(out, data[:size]...)
+		data = data[size:]
+	}
+	return
+}
+
+// AnnexBParameterSets returns the SPS and PPS in the Annex B format.
+func (c *AVCConfig) AnnexBParameterSets() (out []byte) {
+	for _, sets := range [][][]byte{c.SPS, c.PPS} {
+		for _, set := range sets {
+			out = append(out, startCode...)
+			out = append(out, set...)
+		}
+	}
+	return
+}
+
+// NAL unit types handled by the muxers.
+const (
+	NALUTypeIDR = 5
+	NALUTypeSPS = 7
+	NALUTypePPS = 8
+	NALUTypeAUD = 9
+)
+
+// NewAVCConfig builds the decoder configuration of the parameter sets,
+// the NAL units are prefixed with 4 byte lengths.
+func NewAVCConfig(sps, pps [][]byte) (config *AVCConfig, err error) {
+	if len(sps) == 0 || len(sps) > 31 || len(pps) == 0 || len(pps) > 255 || len(sps[0]) < 4 {
+		return nil, ErrBadAVCConfig
+	}
+	raw := []byte{1, sps[0][1], sps[0][2], sps[0][3], 0xff, 0xe0 | byte(len(sps))}
+	for _, set := range sps {
+		raw = append(raw, byte(len(set)>>8), byte(len(set)))
+		raw = append(raw, set...)
+	}
+	raw = append(raw, byte(len(pps)))
+	for _, set := range pps {
+		raw = append(raw, byte(len(set)>>8), byte(len(set)))
+		raw = append(raw, set...)
+	}
+	return ParseAVCConfig(raw)
+}
+
+// SplitAnnexB returns the NAL units of an Annex B byte stream without
+// the start codes.
+func SplitAnnexB(data []byte) (nalus [][]byte) {
+	start := -1
+	add := func(nalu []byte) {
+		for len(nalu) > 0 && nalu[len(nalu)-1] == 0 {
+			nalu = nalu[:len(nalu)-1]
+		}
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+	}
+	for i := 0; i+2 < len(data); {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				add(data[start:i])
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		add(data[start:])
+	}
+	return
+}
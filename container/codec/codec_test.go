@@ -0,0 +1,198 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+type bitWriter struct {
+	data []byte
+	n    uint
+}
+
+func (w *bitWriter) bits(value uint, n uint) {
+	for i := n; i > 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>(i-1)&1 == 1 {
+			w.data[len(w.data)-1] |= 1 << (7 - w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(value uint) {
+	value++
+	size := uint(0)
+	for v := value; v > 1; v >>= 1 {
+		size++
+	}
+	w.bits(0, size)
+	w.bits(value, size+1)
+}
+
+// testSPS builds a progressive 4:2:0 SPS of the given size in macroblocks
+// with an optional bottom crop.
+func testSPS(profile byte, widthMbs, heightMbs, cropBottom uint) []byte {
+	w := &bitWriter{}
+	w.bits(0x67, 8)
+	w.bits(uint(profile), 8)
+	w.bits(0, 8)
+	w.bits(31, 8)
+	w.ue(0)
+	if profile == 100 {
+		w.ue(1) // chroma_format_idc
+		w.ue(0)
+		w.ue(0)
+		w.bits(0, 1)
+		w.bits(0, 1) // no scaling matrix
+	}
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.bits(0, 1)
+	w.ue(widthMbs - 1)
+	w.ue(heightMbs - 1)
+	w.bits(1, 1) // frame_mbs_only_flag
+	w.bits(1, 1)
+	if cropBottom > 0 {
+		w.bits(1, 1)
+		w.ue(0)
+		w.ue(0)
+		w.ue(0)
+		w.ue(cropBottom)
+	} else {
+		w.bits(0, 1)
+	}
+	w.bits(0, 1) // vui_parameters_present_flag
+	w.bits(1, 1) // stop bit
+	return w.data
+}
+
+func TestParseSPS(t *testing.T) {
+	cases := []struct {
+		name          string
+		sps           []byte
+		width, height int
+	}{
+		{"baseline 720p", testSPS(66, 80, 45, 0), 1280, 720},
+		{"high 1080p cropped", testSPS(100, 120, 68, 4), 1920, 1080},
+		{"high 360p", testSPS(100, 40, 23, 4), 640, 360},
+	}
+	for _, c := range cases {
+		info, err := ParseSPS(c.sps)
+		if err != nil {
+			t.Errorf("ParseSPS(%s) error: %s", c.name, err)
+			continue
+		}
+		if info.Width != c.width || info.Height != c.height {
+			t.Errorf("ParseSPS(%s) got: %dx%d, expect: %dx%d", c.name, info.Width, info.Height, c.width, c.height)
+		}
+	}
+	if _, err := ParseSPS([]byte{0x68, 0, 0, 0}); err != ErrBadSPS {
+		t.Errorf("ParseSPS(pps) got: %v, expect: %v", err, ErrBadSPS)
+	}
+}
+
+func TestEmulationPrevention(t *testing.T) {
+	got := removeEmulationPrevention([]byte{1, 0, 0, 3, 1, 0, 0, 3, 0, 3})
+	expect := []byte{1, 0, 0, 1, 0, 0, 0, 3}
+	if !bytes.Equal(got, expect) {
+		t.Errorf("removeEmulationPrevention got: %v, expect: %v", got, expect)
+	}
+}
+
+func TestParseAVCConfig(t *testing.T) {
+	sps := testSPS(66, 80, 45, 0)
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	data := []byte{1, 66, 0, 31, 0xff, 0xe1, 0, byte(len(sps))}
+	data = append(data, sps...)
+	data = append(data, 1, 0, byte(len(pps)))
+	data = append(data, pps...)
+	config, err := ParseAVCConfig(data)
+	if err != nil {
+		t.Fatalf("ParseAVCConfig error: %s", err)
+	}
+	if config.LengthSize != 4 || len(config.SPS) != 1 || len(config.PPS) != 1 ||
+		!bytes.Equal(config.SPS[0], sps) || !bytes.Equal(config.PPS[0], pps) {
+		t.Errorf("ParseAVCConfig got: %+v", config)
+	}
+	if _, err = ParseAVCConfig(data[:len(data)-1]); err != ErrBadAVCConfig {
+		t.Errorf("ParseAVCConfig(truncated) got: %v, expect: %v", err, ErrBadAVCConfig)
+	}
+}
+
+func TestNewAVCConfig(t *testing.T) {
+	sps := testSPS(66, 80, 45, 0)
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	config, err := NewAVCConfig([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatalf("NewAVCConfig error: %s", err)
+	}
+	if config.Profile != 66 || config.LengthSize != 4 ||
+		!bytes.Equal(config.SPS[0], sps) || !bytes.Equal(config.PPS[0], pps) {
+		t.Errorf("NewAVCConfig got: %+v", config)
+	}
+	if _, err = NewAVCConfig(nil, [][]byte{pps}); err != ErrBadAVCConfig {
+		t.Errorf("NewAVCConfig(no SPS) got: %v, expect: %v", err, ErrBadAVCConfig)
+	}
+}
+
+func TestSplitAnnexB(t *testing.T) {
+	data := []byte{0, 0, 0, 1, 9, 0xf0, 0, 0, 1, 0x67, 1, 2, 0, 0, 0, 1, 0x65, 0, 3, 1}
+	got := SplitAnnexB(data)
+	expect := [][]byte{{9, 0xf0}, {0x67, 1, 2}, {0x65, 0, 3, 1}}
+	if len(got) != len(expect) {
+		t.Fatalf("SplitAnnexB got: %v, expect: %v", got, expect)
+	}
+	for i := range expect {
+		if !bytes.Equal(got[i], expect[i]) {
+			t.Errorf("SplitAnnexB unit %d got: %v, expect: %v", i, got[i], expect[i])
+		}
+	}
+}
+
+func TestParseADTS(t *testing.T) {
+	config := NewAudioSpecificConfig(2, 4, 2)
+	if !bytes.Equal(config.Raw, []byte{0x12, 0x10}) || config.SampleRate != 44100 {
+		t.Fatalf("NewAudioSpecificConfig got: %+v", config)
+	}
+	frame := append(config.ADTSHeader(3), 1, 2, 3)
+	parsed, headerLen, frameLen, err := ParseADTS(frame)
+	if err != nil {
+		t.Fatalf("ParseADTS error: %s", err)
+	}
+	if headerLen != 7 || frameLen != len(frame) || !bytes.Equal(parsed.Raw, config.Raw) {
+		t.Errorf("ParseADTS got: %+v, %d, %d", parsed, headerLen, frameLen)
+	}
+	if _, _, _, err = ParseADTS([]byte{0x47, 0, 0, 0, 0, 0, 0}); err != ErrBadADTS {
+		t.Errorf("ParseADTS(no sync) got: %v, expect: %v", err, ErrBadADTS)
+	}
+}
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	// AAC LC, 44100 Hz, stereo
+	config, err := ParseAudioSpecificConfig([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatalf("ParseAudioSpecificConfig error: %s", err)
+	}
+	if config.ObjectType != 2 || config.SampleRate != 44100 || config.Channels != 2 {
+		t.Errorf("ParseAudioSpecificConfig got: %+v", config)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	video, err := ParseVideoTag([]byte{0x17, 1, 0xff, 0xff, 0xfe, 9})
+	if err != nil || !video.IsKeyFrame() || !video.IsAVCFrame() || video.CompositionTime != -2 {
+		t.Errorf("ParseVideoTag got: %+v, %v", video, err)
+	}
+	if !IsVideoSequenceHeader([]byte{0x17, 0, 0, 0, 0, 1}) || IsKeyFrame([]byte{0x17, 0, 0, 0, 0, 1}) {
+		t.Error("sequence header is detected as key frame")
+	}
+	audio, err := ParseAudioTag([]byte{0xaf, 0, 0x12, 0x10})
+	if err != nil || !audio.IsAACSequenceHeader() || !bytes.Equal(audio.Data, []byte{0x12, 0x10}) {
+		t.Errorf("ParseAudioTag got: %+v, %v", audio, err)
+	}
+}
@@ -0,0 +1,155 @@
+// Package codec parses the payloads of flv (rtmp) audio and video tags:
+// the tag headers, the AVC decoder configuration with its SPS and the AAC
+// AudioSpecificConfig.
+package codec
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	VideoCodecAVC  = 7
+	AudioFormatAAC = 10
+)
+
+const (
+	FrameTypeKey   = 1
+	FrameTypeInter = 2
+)
+
+const (
+	AVCPacketSequenceHeader = 0
+	AVCPacketNALU           = 1
+	AVCPacketEndOfSequence  = 2
+)
+
+const (
+	AACPacketSequenceHeader = 0
+	AACPacketRaw            = 1
+)
+
+var ErrShortTag = errors.New("codec: tag payload is too short")
+
+// VideoTag is a parsed video tag payload. PacketType and CompositionTime
+// are only set for AVC, Data is the payload after the headers.
+type VideoTag struct {
+	FrameType       byte
+	CodecID         byte
+	PacketType      byte
+	CompositionTime int32
+	Data            []byte
+}
+
+func ParseVideoTag(payload []byte) (tag VideoTag, err error) {
+	if len(payload) < 1 {
+		err = ErrShortTag
+		return
+	}
+	tag.FrameType = payload[0] >> 4
+	tag.CodecID = payload[0] & 0x0f
+	if tag.CodecID != VideoCodecAVC {
+		tag.Data = payload[1:]
+		return
+	}
+	if len(payload) < 5 {
+		err = ErrShortTag
+		return
+	}
+	tag.PacketType = payload[1]
+	cts := int32(payload[2])<<16 | int32(payload[3])<<8 | int32(payload[4])
+	if cts&0x800000 != 0 {
+		cts -= 1 << 24
+	}
+	tag.CompositionTime = cts
+	tag.Data = payload[5:]
+	return
+}
+
+func (t VideoTag) IsKeyFrame() bool {
+	return t.FrameType == FrameTypeKey
+}
+
+func (t VideoTag) IsAVCSequenceHeader() bool {
+	return t.CodecID == VideoCodecAVC && t.PacketType == AVCPacketSequenceHeader
+}
+
+// IsAVCFrame reports whether the tag carries coded pictures.
+func (t VideoTag) IsAVCFrame() bool {
+	return t.CodecID == VideoCodecAVC && t.PacketType == AVCPacketNALU && len(t.Data) > 0
+}
+
+// AudioTag is a parsed audio tag payload, PacketType is only set for AAC.
+type AudioTag struct {
+	Format     byte
+	Rate       byte
+	Size       byte
+	Channels   byte
+	PacketType byte
+	Data       []byte
+}
+
+func ParseAudioTag(payload []byte) (tag AudioTag, err error) {
+	if len(payload) < 1 {
+		err = ErrShortTag
+		return
+	}
+	tag.Format = payload[0] >> 4
+	tag.Rate = (payload[0] >> 2) & 0x03
+	tag.Size = (payload[0] >> 1) & 0x01
+	tag.Channels = payload[0] & 0x01
+	if tag.Format != AudioFormatAAC {
+		tag.Data = payload[1:]
+		return
+	}
+	if len(payload) < 2 {
+		err = ErrShortTag
+		return
+	}
+	tag.PacketType = payload[1]
+	tag.Data = payload[2:]
+	return
+}
+
+func (t AudioTag) IsAACSequenceHeader() bool {
+	return t.Format == AudioFormatAAC && t.PacketType == AACPacketSequenceHeader
+}
+
+func (t AudioTag) IsAACFrame() bool {
+	return t.Format == AudioFormatAAC && t.PacketType == AACPacketRaw && len(t.Data) > 0
+}
+
+// IsVideoSequenceHeader reports whether the video tag payload is an AVC
+// decoder configuration.
+func IsVideoSequenceHeader(payload []byte) bool {
+	tag, err := ParseVideoTag(payload)
+	return err == nil && tag.IsAVCSequenceHeader()
+}
+
+// IsAudioSequenceHeader reports whether the audio tag payload is an AAC
+// AudioSpecificConfig.
+func IsAudioSequenceHeader(payload []byte) bool {
+	tag, err := ParseAudioTag(payload)
+	return err == nil && tag.IsAACSequenceHeader()
+}
+
+// IsKeyFrame reports whether the video tag payload is a key frame with
+// coded pictures, sequence headers are not key frames.
+func IsKeyFrame(payload []byte) bool {
+	tag, err := ParseVideoTag(payload)
+	if err != nil || !tag.IsKeyFrame() {
+		return false
+	}
+	if tag.CodecID == VideoCodecAVC {
+		return tag.PacketType == AVCPacketNALU
+	}
+	return true
+}
+
+var onMetaData = []byte{0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a'}
+
+// IsMetaData reports whether the script data payload is an onMetaData
+// call encoded in AMF0.
+func IsMetaData(payload []byte) bool {
+	return bytes.HasPrefix(payload, onMetaData)
+}
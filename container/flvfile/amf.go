@@ -0,0 +1,120 @@
+package flvfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfLongString  = 0x0c
+)
+
+// Property is a named value of an AMF0 object, properties are encoded
+// in order unlike amf.Object.
+type Property struct {
+	Name  string
+	Value interface{}
+}
+
+// Properties is encoded as an AMF0 object.
+type Properties []Property
+
+// longString is encoded as an AMF0 long string whatever the length.
+type longString string
+
+// encodeValue writes the AMF0 value, values of unknown types are
+// written as null.
+func encodeValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		buf.WriteByte(amfNumber)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case int:
+		encodeValue(buf, float64(v))
+	case int64:
+		encodeValue(buf, float64(v))
+	case uint32:
+		encodeValue(buf, float64(v))
+	case bool:
+		buf.WriteByte(amfBoolean)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf.WriteByte(amfLongString)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		} else {
+			buf.WriteByte(amfString)
+			binary.Write(buf, binary.BigEndian, uint16(len(v)))
+		}
+		buf.WriteString(v)
+	case longString:
+		buf.WriteByte(amfLongString)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		buf.WriteString(string(v))
+	case Properties:
+		buf.WriteByte(amfObject)
+		encodeProperties(buf, v)
+	case amf.Object:
+		encodeValue(buf, sortedProperties(v))
+	case []float64:
+		buf.WriteByte(amfStrictArray)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+	case []interface{}:
+		buf.WriteByte(amfStrictArray)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+	default:
+		buf.WriteByte(amfNull)
+	}
+}
+
+func encodeProperties(buf *bytes.Buffer, properties Properties) {
+	for _, p := range properties {
+		binary.Write(buf, binary.BigEndian, uint16(len(p.Name)))
+		buf.WriteString(p.Name)
+		encodeValue(buf, p.Value)
+	}
+	buf.Write([]byte{0, 0, amfObjectEnd})
+}
+
+// encodeECMAArray writes the properties as an AMF0 ECMA array,
+// onMetaData is sent as one.
+func encodeECMAArray(buf *bytes.Buffer, properties Properties) {
+	buf.WriteByte(amfECMAArray)
+	binary.Write(buf, binary.BigEndian, uint32(len(properties)))
+	encodeProperties(buf, properties)
+}
+
+func sortedProperties(object amf.Object) Properties {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	properties := make(Properties, 0, len(names))
+	for _, name := range names {
+		properties = append(properties, Property{name, object[name]})
+	}
+	return properties
+}
@@ -0,0 +1,138 @@
+package flvfile
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/zhangpeihao/goamf"
+
+	"gomfc/container/codec"
+)
+
+const onMetaData = "onMetaData"
+
+// paddingProperty fills the reserved metadata tag, it is a long string
+// so a single property covers any size.
+const paddingProperty = "_padding"
+
+// paddingOverhead is the size of the padding property without the value.
+const paddingOverhead = 2 + len(paddingProperty) + 1 + 4
+
+var ErrNotMetaData = errors.New("flvfile: not an onMetaData payload")
+
+// Keyframe is an entry of the keyframes index: the time in seconds and
+// the file position of the video tag.
+type Keyframe struct {
+	Time     float64
+	Position int64
+}
+
+// Metadata is the onMetaData of a file. The properties of the stream
+// metadata are kept in Extra, the computed ones replace them.
+type Metadata struct {
+	Duration  float64
+	FileSize  int64
+	Width     int
+	Height    int
+	HasVideo  bool
+	HasAudio  bool
+	Keyframes []Keyframe
+	Extra     amf.Object
+}
+
+// computed are the properties set from the file, the stream values of
+// them are dropped.
+var computed = map[string]bool{
+	"duration":      true,
+	"filesize":      true,
+	"width":         true,
+	"height":        true,
+	"hasVideo":      true,
+	"hasAudio":      true,
+	"hasKeyframes":  true,
+	"hasMetadata":   true,
+	"keyframes":     true,
+	paddingProperty: true,
+}
+
+// DecodeMetaData reads the properties of an onMetaData script data payload.
+func DecodeMetaData(payload []byte) (properties amf.Object, err error) {
+	if !codec.IsMetaData(payload) {
+		return nil, ErrNotMetaData
+	}
+	r := bytes.NewReader(payload)
+	if _, err = amf.ReadString(r); err != nil {
+		return
+	}
+	value, err := amf.ReadValue(r)
+	if err != nil {
+		return
+	}
+	properties, ok := value.(amf.Object)
+	if !ok {
+		return nil, ErrNotMetaData
+	}
+	return
+}
+
+func (m *Metadata) properties() Properties {
+	properties := Properties{
+		{"duration", m.Duration},
+		{"filesize", float64(m.FileSize)},
+	}
+	if m.Width > 0 && m.Height > 0 {
+		properties = append(properties,
+			Property{"width", float64(m.Width)},
+			Property{"height", float64(m.Height)})
+	}
+	properties = append(properties,
+		Property{"hasVideo", m.HasVideo},
+		Property{"hasAudio", m.HasAudio},
+		Property{"hasMetadata", true},
+		Property{"hasKeyframes", len(m.Keyframes) > 0})
+	for _, p := range sortedProperties(m.Extra) {
+		if !computed[p.Name] {
+			properties = append(properties, p)
+		}
+	}
+	times := make([]float64, len(m.Keyframes))
+	positions := make([]float64, len(m.Keyframes))
+	for i, k := range m.Keyframes {
+		times[i] = k.Time
+		positions[i] = float64(k.Position)
+	}
+	return append(properties, Property{"keyframes", Properties{
+		{"times", times},
+		{"filepositions", positions},
+	}})
+}
+
+// Encode returns the onMetaData script data payload.
+func (m *Metadata) Encode() []byte {
+	return m.encode(m.properties())
+}
+
+// EncodeSize returns the payload of exactly size bytes, the rest is
+// filled with a padding property. It fails when the metadata is longer
+// or the space left is too small for the padding.
+func (m *Metadata) EncodeSize(size int) (payload []byte, ok bool) {
+	properties := m.properties()
+	payload = m.encode(properties)
+	left := size - len(payload)
+	if left == 0 {
+		return payload, true
+	}
+	if left < paddingOverhead {
+		return nil, false
+	}
+	padding := longString(make([]byte, left-paddingOverhead))
+	payload = m.encode(append(properties, Property{paddingProperty, padding}))
+	return payload, len(payload) == size
+}
+
+func (m *Metadata) encode(properties Properties) []byte {
+	buf := new(bytes.Buffer)
+	encodeValue(buf, onMetaData)
+	encodeECMAArray(buf, properties)
+	return buf.Bytes()
+}
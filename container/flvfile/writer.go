@@ -0,0 +1,253 @@
+// Package flvfile writes flv files with an onMetaData tag which is
+// updated when the file is closed: duration, file size, video size and
+// the keyframes index, so players can seek in the recording.
+package flvfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/zhangpeihao/goamf"
+
+	"gomfc/container/codec"
+)
+
+const (
+	TagAudio      = 0x08
+	TagVideo      = 0x09
+	TagScriptData = 0x12
+)
+
+const HeaderSize = 9
+const TagHeaderSize = 11
+
+// DefaultKeyframeCapacity is the number of keyframes the metadata tag
+// reserves room for. A longer recording is rewritten on close to make
+// room for the index.
+const DefaultKeyframeCapacity = 3000
+
+// extraReserve is the room for the properties of the stream metadata.
+const extraReserve = 2048
+
+var ErrClosed = errors.New("flvfile: writer is closed")
+
+// Header returns the flv file header with the first PreviousTagSize.
+func Header(hasAudio, hasVideo bool) []byte {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+	return []byte{'F', 'L', 'V', 1, flags, 0, 0, 0, HeaderSize, 0, 0, 0, 0}
+}
+
+// Tag returns the tag with the header and the PreviousTagSize after it.
+func Tag(tagType byte, payload []byte, timestamp uint32) []byte {
+	size := len(payload)
+	tag := make([]byte, 0, TagHeaderSize+size+4)
+	tag = append(tag,
+		tagType,
+		byte(size>>16), byte(size>>8), byte(size),
+		byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24),
+		0, 0, 0)
+	tag = append(tag, payload...)
+	return binary.BigEndian.AppendUint32(tag, uint32(TagHeaderSize+size))
+}
+
+// Writer writes a flv file. The stream metadata is merged into the
+// metadata tag at the start of the file instead of being written inline.
+type Writer struct {
+	path       string
+	file       *os.File
+	offset     int64
+	metaOffset int64
+	metaSize   int
+
+	meta          Metadata
+	lastTimestamp uint32
+	closed        bool
+}
+
+func Create(path string) (w *Writer, err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	w = &Writer{path: path, file: file}
+	reserve := Metadata{Keyframes: make([]Keyframe, DefaultKeyframeCapacity)}
+	w.metaSize = len(reserve.Encode()) + extraReserve
+	payload, _ := w.meta.EncodeSize(w.metaSize)
+	if err = w.write(Header(true, true)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.metaOffset = w.offset
+	if err = w.write(Tag(TagScriptData, payload, 0)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// Path returns the path of the file.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) WriteVideo(payload []byte, timestamp uint32) (err error) {
+	if w.closed {
+		return ErrClosed
+	}
+	w.meta.HasVideo = true
+	if tag, parseErr := codec.ParseVideoTag(payload); parseErr == nil {
+		switch {
+		case tag.IsAVCSequenceHeader():
+			w.videoSize(tag.Data)
+		case tag.IsKeyFrame() && tag.IsAVCFrame():
+			w.meta.Keyframes = append(w.meta.Keyframes, Keyframe{
+				Time:     float64(timestamp) / 1000,
+				Position: w.offset,
+			})
+		}
+	}
+	return w.writeTag(TagVideo, payload, timestamp)
+}
+
+func (w *Writer) WriteAudio(payload []byte, timestamp uint32) error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.meta.HasAudio = true
+	return w.writeTag(TagAudio, payload, timestamp)
+}
+
+// WriteMeta keeps the properties of onMetaData for the metadata tag,
+// other script data is written as is.
+func (w *Writer) WriteMeta(payload []byte, timestamp uint32) error {
+	if w.closed {
+		return ErrClosed
+	}
+	properties, err := DecodeMetaData(payload)
+	if err != nil {
+		return w.writeTag(TagScriptData, payload, timestamp)
+	}
+	if w.meta.Extra == nil {
+		w.meta.Extra = make(amf.Object)
+	}
+	for name, value := range properties {
+		w.meta.Extra[name] = value
+	}
+	// the metadata tag of an interrupted recording has the stream
+	// properties at least
+	return w.updateMeta()
+}
+
+// Close writes the final metadata and closes the file.
+func (w *Writer) Close() (err error) {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	w.meta.Duration = float64(w.lastTimestamp) / 1000
+	w.meta.FileSize = w.offset
+	payload, ok := w.meta.EncodeSize(w.metaSize)
+	if ok {
+		_, err = w.file.WriteAt(Tag(TagScriptData, payload, 0), w.metaOffset)
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+		return
+	}
+	if err = w.file.Close(); err != nil {
+		return
+	}
+	return w.rewrite()
+}
+
+func (w *Writer) videoSize(config []byte) {
+	avc, err := codec.ParseAVCConfig(config)
+	if err != nil || len(avc.SPS) == 0 {
+		return
+	}
+	sps, err := codec.ParseSPS(avc.SPS[0])
+	if err != nil {
+		return
+	}
+	w.meta.Width = sps.Width
+	w.meta.Height = sps.Height
+}
+
+func (w *Writer) updateMeta() (err error) {
+	payload, ok := w.meta.EncodeSize(w.metaSize)
+	if !ok {
+		return
+	}
+	_, err = w.file.WriteAt(Tag(TagScriptData, payload, 0), w.metaOffset)
+	return
+}
+
+func (w *Writer) writeTag(tagType byte, payload []byte, timestamp uint32) error {
+	if timestamp > w.lastTimestamp {
+		w.lastTimestamp = timestamp
+	}
+	return w.write(Tag(tagType, payload, timestamp))
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.file.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// rewrite copies the file with a metadata tag large enough for the
+// index, the keyframe positions are moved by the size difference.
+func (w *Writer) rewrite() (err error) {
+	oldTagSize := int64(TagHeaderSize + w.metaSize + 4)
+	newTagSize := int64(TagHeaderSize + len(w.meta.Encode()) + 4)
+	shift := newTagSize - oldTagSize
+	for i := range w.meta.Keyframes {
+		w.meta.Keyframes[i].Position += shift
+	}
+	w.meta.FileSize += shift
+
+	src, err := os.Open(w.path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	tmp := w.path + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if _, err = dst.Write(Header(true, true)); err != nil {
+		return
+	}
+	if _, err = dst.Write(Tag(TagScriptData, w.meta.Encode(), 0)); err != nil {
+		return
+	}
+	if _, err = src.Seek(w.metaOffset+oldTagSize, io.SeekStart); err != nil {
+		return
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		return
+	}
+	if err = dst.Close(); err != nil {
+		return
+	}
+	// the open file can't be replaced on Windows
+	if err = src.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp, w.path)
+}
@@ -0,0 +1,160 @@
+package flvfile
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// baseline profile SPS of 1280x720
+var testSPS = []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+var testPPS = []byte{0x68, 0xce, 0x38, 0x80}
+
+func videoConfigTag() []byte {
+	tag := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(testSPS))}
+	tag = append(tag, testSPS...)
+	tag = append(tag, 1, 0, byte(len(testPPS)))
+	return append(tag, testPPS...)
+}
+
+func videoFrameTag(key bool) []byte {
+	tag := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 1, 0x41}
+	if key {
+		tag[0] = 0x17
+		tag[9] = 0x65
+	}
+	return tag
+}
+
+type testTag struct {
+	tagType   byte
+	offset    int64
+	timestamp uint32
+	payload   []byte
+}
+
+// readTags parses the file and checks the PreviousTagSize fields.
+func readTags(t *testing.T, data []byte) (tags []testTag) {
+	if len(data) < HeaderSize+4 || string(data[:3]) != "FLV" {
+		t.Fatal("no flv header")
+	}
+	pos := HeaderSize + 4
+	for pos < len(data) {
+		if pos+TagHeaderSize > len(data) {
+			t.Fatalf("truncated tag header at %d", pos)
+		}
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		timestamp := uint32(data[pos+4])<<16 | uint32(data[pos+5])<<8 | uint32(data[pos+6]) | uint32(data[pos+7])<<24
+		end := pos + TagHeaderSize + size
+		if end+4 > len(data) {
+			t.Fatalf("truncated tag at %d", pos)
+		}
+		if prev := binary.BigEndian.Uint32(data[end:]); prev != uint32(TagHeaderSize+size) {
+			t.Fatalf("PreviousTagSize at %d got: %d, expect: %d", end, prev, TagHeaderSize+size)
+		}
+		tags = append(tags, testTag{data[pos], int64(pos), timestamp, data[pos+TagHeaderSize : end]})
+		pos = end + 4
+	}
+	return
+}
+
+func writeRecording(t *testing.T, path string, keyframes int) {
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := Metadata{Extra: amf.Object{"framerate": float64(25), "duration": float64(0)}}
+	if err = w.WriteMeta(stream.Encode(), 0); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteVideo(videoConfigTag(), 0)
+	w.WriteAudio([]byte{0xaf, 0, 0x12, 0x10}, 0)
+	ts := uint32(0)
+	for i := 0; i < keyframes; i++ {
+		for j := 0; j < 3; j++ {
+			w.WriteVideo(videoFrameTag(j == 0), ts)
+			w.WriteAudio([]byte{0xaf, 1, 0x21}, ts+5)
+			ts += 40
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Close() != ErrClosed {
+		t.Error("second Close does not fail")
+	}
+}
+
+func checkRecording(t *testing.T, path string, keyframes int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := readTags(t, data)
+	if tags[0].tagType != TagScriptData {
+		t.Fatalf("first tag type got: %d, expect: %d", tags[0].tagType, TagScriptData)
+	}
+	for _, tag := range tags[1:] {
+		if tag.tagType == TagScriptData {
+			t.Error("stream metadata is written inline")
+		}
+	}
+	meta, err := DecodeMetaData(tags[0].payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastTimestamp := uint32(keyframes*3-1) * 40
+	checks := map[string]interface{}{
+		"duration":  float64(lastTimestamp+5) / 1000,
+		"filesize":  float64(len(data)),
+		"width":     float64(1280),
+		"height":    float64(720),
+		"framerate": float64(25),
+		"hasVideo":  true,
+	}
+	for name, expect := range checks {
+		if meta[name] != expect {
+			t.Errorf("%s got: %v, expect: %v", name, meta[name], expect)
+		}
+	}
+	index, ok := meta["keyframes"].(amf.Object)
+	if !ok {
+		t.Fatalf("no keyframes index: %v", meta["keyframes"])
+	}
+	times, _ := index["times"].([]interface{})
+	positions, _ := index["filepositions"].([]interface{})
+	if len(times) != keyframes || len(positions) != keyframes {
+		t.Fatalf("index size got: %d/%d, expect: %d", len(times), len(positions), keyframes)
+	}
+	byOffset := make(map[int64]testTag)
+	for _, tag := range tags {
+		byOffset[tag.offset] = tag
+	}
+	for i := range positions {
+		tag, found := byOffset[int64(positions[i].(float64))]
+		if !found || tag.tagType != TagVideo || tag.payload[0] != 0x17 || tag.payload[1] != 1 {
+			t.Fatalf("keyframe %d position %v is not a key frame", i, positions[i])
+		}
+		if float64(tag.timestamp)/1000 != times[i] {
+			t.Errorf("keyframe %d time got: %v, expect: %v", i, times[i], float64(tag.timestamp)/1000)
+		}
+	}
+}
+
+func TestWriterMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.flv")
+	writeRecording(t, path, 10)
+	checkRecording(t, path, 10)
+}
+
+func TestWriterRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.flv")
+	writeRecording(t, path, DefaultKeyframeCapacity+100)
+	checkRecording(t, path, DefaultKeyframeCapacity+100)
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file is left")
+	}
+}
@@ -0,0 +1,182 @@
+// Package flvfix repairs flv files of interrupted recordings. The file is
+// read tag by tag: the trailing partial tag is dropped, PreviousTagSize
+// fields are written anew, timestamps which go backwards or jump are
+// rebased and the tags are written into a container.Writer, so the result
+// is a flv with a rebuilt onMetaData or any other supported format.
+package flvfix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gomfc/container"
+	"gomfc/container/codec"
+	"gomfc/container/flvfile"
+)
+
+const (
+	DefaultMaxGap  = container.DefaultMaxGap
+	DefaultMaxBack = container.DefaultMaxBack
+)
+
+// maxTagSize protects from reading a huge tag from a corrupted header.
+const maxTagSize = 16 << 20
+
+var ErrNotFLV = errors.New("flvfix: not a flv file")
+
+type Options struct {
+	MaxGap  time.Duration
+	MaxBack time.Duration
+}
+
+// Correction is a timestamp discontinuity, tags from Timestamp on are
+// moved by Shift milliseconds.
+type Correction struct {
+	Tag       int64
+	Timestamp uint32
+	Shift     int64
+}
+
+// Report describes what was repaired.
+type Report struct {
+	Tags            int64
+	VideoTags       int64
+	AudioTags       int64
+	ScriptTags      int64
+	Keyframes       int64
+	Duration        time.Duration
+	BadPrevTagSizes int64
+	TruncatedBytes  int64
+	Corrupted       bool
+	Corrections     []Correction
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d tags (video %d, audio %d, script %d), %d keyframes, duration %s, "+
+		"%d bad PreviousTagSize, %d truncated bytes, %d timestamp corrections, corrupted: %t",
+		r.Tags, r.VideoTags, r.AudioTags, r.ScriptTags, r.Keyframes, r.Duration,
+		r.BadPrevTagSizes, r.TruncatedBytes, len(r.Corrections), r.Corrupted)
+}
+
+// Repair reads the flv stream and writes the tags into w, w is not closed.
+// A truncated or corrupted end of the stream is reported, not returned
+// as an error.
+func Repair(r io.Reader, w container.Writer, opts Options) (report Report, err error) {
+	normalizer := container.NewNormalizer(w)
+	if opts.MaxGap > 0 {
+		normalizer.MaxGap = opts.MaxGap
+	}
+	if opts.MaxBack > 0 {
+		normalizer.MaxBack = opts.MaxBack
+	}
+	normalizer.OnDiscontinuity = func(d container.Discontinuity) {
+		report.Corrections = append(report.Corrections, Correction{
+			Tag:       report.Tags,
+			Timestamp: d.Timestamp,
+			Shift:     d.Shift,
+		})
+	}
+	w = normalizer
+	br := bufio.NewReader(r)
+	header := make([]byte, flvfile.HeaderSize)
+	if _, err = io.ReadFull(br, header); err != nil || string(header[:3]) != "FLV" {
+		return report, ErrNotFLV
+	}
+	dataOffset := binary.BigEndian.Uint32(header[5:])
+	if dataOffset < flvfile.HeaderSize {
+		return report, ErrNotFLV
+	}
+	if _, err = br.Discard(int(dataOffset) - flvfile.HeaderSize); err != nil {
+		return report, ErrNotFLV
+	}
+	// the first PreviousTagSize is zero, it is not checked
+	if n, _ := br.Discard(4); n < 4 {
+		return report, nil
+	}
+
+	tagHeader := make([]byte, flvfile.TagHeaderSize)
+	prevSize := make([]byte, 4)
+	for {
+		var n int
+		n, err = io.ReadFull(br, tagHeader)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			report.TruncatedBytes += int64(n)
+			err = nil
+			break
+		}
+		tagType := tagHeader[0] & 0x1f
+		size := int(tagHeader[1])<<16 | int(tagHeader[2])<<8 | int(tagHeader[3])
+		if tagType != flvfile.TagAudio && tagType != flvfile.TagVideo && tagType != flvfile.TagScriptData ||
+			size > maxTagSize {
+			report.Corrupted = true
+			rest, _ := io.Copy(io.Discard, br)
+			report.TruncatedBytes += int64(len(tagHeader)) + rest
+			break
+		}
+		timestamp := uint32(tagHeader[4])<<16 | uint32(tagHeader[5])<<8 | uint32(tagHeader[6]) | uint32(tagHeader[7])<<24
+		payload := make([]byte, size)
+		if n, err = io.ReadFull(br, payload); err != nil {
+			report.TruncatedBytes += int64(len(tagHeader) + n)
+			err = nil
+			break
+		}
+		n, _ = io.ReadFull(br, prevSize)
+		if n == 4 && binary.BigEndian.Uint32(prevSize) != uint32(flvfile.TagHeaderSize+size) ||
+			n > 0 && n < 4 {
+			report.BadPrevTagSizes++
+		}
+
+		switch tagType {
+		case flvfile.TagVideo:
+			report.VideoTags++
+			if codec.IsKeyFrame(payload) {
+				report.Keyframes++
+			}
+			err = w.WriteVideo(payload, timestamp)
+		case flvfile.TagAudio:
+			report.AudioTags++
+			err = w.WriteAudio(payload, timestamp)
+		case flvfile.TagScriptData:
+			report.ScriptTags++
+			err = w.WriteMeta(payload, timestamp)
+		}
+		if err != nil {
+			return
+		}
+		report.Tags++
+		if n < 4 {
+			break
+		}
+	}
+	report.Duration = time.Duration(normalizer.Last()) * time.Millisecond
+	return
+}
+
+// RepairFile repairs src into dst, the format of dst is given by its
+// extension, see container.Create, so "x.mp4" remuxes the recording.
+// dst must differ from src.
+func RepairFile(src, dst string, opts Options) (report Report, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := container.Create(dst)
+	if err != nil {
+		return
+	}
+	report, err = Repair(in, out, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
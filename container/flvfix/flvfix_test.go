@@ -0,0 +1,129 @@
+package flvfix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gomfc/container/flvfile"
+)
+
+type testTag struct {
+	kind      byte
+	timestamp uint32
+}
+
+type testWriter struct {
+	tags []testTag
+}
+
+func (w *testWriter) WriteVideo(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'v', timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteAudio(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'a', timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteMeta(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'m', timestamp})
+	return nil
+}
+
+func (w *testWriter) Close() error {
+	return nil
+}
+
+var (
+	keyFrame   = []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	interFrame = []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 1, 0x41}
+	audioFrame = []byte{0xaf, 1, 0x21}
+)
+
+// brokenFile has a bad PreviousTagSize, a reset of the timestamps,
+// a jump forward and a truncated last tag.
+func brokenFile() []byte {
+	buf := bytes.NewBuffer(flvfile.Header(true, true))
+	buf.Write(flvfile.Tag(flvfile.TagVideo, keyFrame, 1000))
+	buf.Write(flvfile.Tag(flvfile.TagAudio, audioFrame, 1010))
+	bad := flvfile.Tag(flvfile.TagVideo, interFrame, 1040)
+	binary.BigEndian.PutUint32(bad[len(bad)-4:], 7)
+	buf.Write(bad)
+	// server reset
+	buf.Write(flvfile.Tag(flvfile.TagVideo, keyFrame, 0))
+	buf.Write(flvfile.Tag(flvfile.TagVideo, interFrame, 40))
+	// jump
+	buf.Write(flvfile.Tag(flvfile.TagVideo, keyFrame, 60000))
+	buf.Write(flvfile.Tag(flvfile.TagAudio, audioFrame, 60010))
+	last := flvfile.Tag(flvfile.TagVideo, interFrame, 60040)
+	buf.Write(last[:len(last)-6])
+	return buf.Bytes()
+}
+
+func TestRepair(t *testing.T) {
+	w := &testWriter{}
+	report, err := Repair(bytes.NewReader(brokenFile()), w, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []testTag{
+		{'v', 0}, {'a', 10}, {'v', 40},
+		{'v', 80}, {'v', 120},
+		{'v', 160}, {'a', 170},
+	}
+	if len(w.tags) != len(expect) {
+		t.Fatalf("tags got: %v, expect: %v", w.tags, expect)
+	}
+	for i := range expect {
+		if w.tags[i] != expect[i] {
+			t.Errorf("tag %d got: %+v, expect: %+v", i, w.tags[i], expect[i])
+		}
+	}
+	if report.Tags != 7 || report.Keyframes != 3 || report.BadPrevTagSizes != 1 {
+		t.Errorf("report: %s", report)
+	}
+	if len(report.Corrections) != 2 {
+		t.Errorf("corrections got: %+v, expect: 2", report.Corrections)
+	}
+	if report.TruncatedBytes != int64(flvfile.TagHeaderSize+len(interFrame)-2) {
+		t.Errorf("truncated bytes got: %d", report.TruncatedBytes)
+	}
+}
+
+func TestRepairNotFLV(t *testing.T) {
+	if _, err := Repair(bytes.NewReader([]byte("GIF89a....")), &testWriter{}, Options{}); err != ErrNotFLV {
+		t.Errorf("error got: %v, expect: %v", err, ErrNotFLV)
+	}
+}
+
+func TestRepairFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "broken.flv")
+	if err := os.WriteFile(src, brokenFile(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "fixed.flv")
+	if _, err := RepairFile(src, dst, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	w := &testWriter{}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := Repair(f, w, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TruncatedBytes != 0 || report.BadPrevTagSizes != 0 || len(report.Corrections) != 0 {
+		t.Errorf("repaired file is not clean: %s", report)
+	}
+	if w.tags[0].kind != 'm' || report.Tags != 8 {
+		t.Errorf("repaired file tags: %v", w.tags)
+	}
+}
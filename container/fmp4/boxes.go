@@ -0,0 +1,324 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+// box builds an ISO BMFF box, the size is filled in by end.
+type box struct {
+	buf    []byte
+	starts []int
+}
+
+func (b *box) begin(kind string) {
+	b.starts = append(b.starts, len(b.buf))
+	b.u32(0)
+	b.buf = append(b.buf, kind...)
+}
+
+func (b *box) beginFull(kind string, version byte, flags uint32) {
+	b.begin(kind)
+	b.u32(uint32(version)<<24 | flags&0xffffff)
+}
+
+func (b *box) end() {
+	start := b.starts[len(b.starts)-1]
+	b.starts = b.starts[:len(b.starts)-1]
+	binary.BigEndian.PutUint32(b.buf[start:], uint32(len(b.buf)-start))
+}
+
+func (b *box) u8(v byte) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *box) u16(v uint16) {
+	b.buf = append(b.buf, byte(v>>8), byte(v))
+}
+
+func (b *box) u32(v uint32) {
+	b.buf = append(b.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *box) u64(v uint64) {
+	b.u32(uint32(v >> 32))
+	b.u32(uint32(v))
+}
+
+func (b *box) zeros(n int) {
+	for i := 0; i < n; i++ {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *box) bytes(data []byte) {
+	b.buf = append(b.buf, data...)
+}
+
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func (b *box) matrix() {
+	for _, v := range unityMatrix {
+		b.u32(v)
+	}
+}
+
+func writeFtyp(b *box) {
+	b.begin("ftyp")
+	b.bytes([]byte("iso5"))
+	b.u32(512)
+	for _, brand := range []string{"iso5", "iso6", "isom", "avc1", "mp41"} {
+		b.bytes([]byte(brand))
+	}
+	b.end()
+}
+
+func writeMoov(b *box, tracks []*track) {
+	b.begin("moov")
+	b.beginFull("mvhd", 0, 0)
+	b.u32(0) // creation_time
+	b.u32(0) // modification_time
+	b.u32(movieTimescale)
+	b.u32(0) // duration, unknown for fragmented files
+	b.u32(0x00010000)
+	b.u16(0x0100)
+	b.zeros(10)
+	b.matrix()
+	b.zeros(24)
+	b.u32(uint32(len(tracks) + 1))
+	b.end()
+	for _, t := range tracks {
+		writeTrak(b, t)
+	}
+	b.begin("mvex")
+	for _, t := range tracks {
+		b.beginFull("trex", 0, 0)
+		b.u32(t.id)
+		b.u32(1) // sample description index
+		b.u32(0)
+		b.u32(0)
+		b.u32(0)
+		b.end()
+	}
+	b.end()
+	b.end()
+}
+
+func writeTrak(b *box, t *track) {
+	b.begin("trak")
+	b.beginFull("tkhd", 0, 0x000003)
+	b.u32(0)
+	b.u32(0)
+	b.u32(t.id)
+	b.u32(0)
+	b.u32(0) // duration
+	b.zeros(8)
+	b.u16(0) // layer
+	b.u16(0) // alternate group
+	if t.video {
+		b.u16(0)
+	} else {
+		b.u16(0x0100)
+	}
+	b.u16(0)
+	b.matrix()
+	b.u32(uint32(t.width) << 16)
+	b.u32(uint32(t.height) << 16)
+	b.end()
+
+	b.begin("mdia")
+	b.beginFull("mdhd", 0, 0)
+	b.u32(0)
+	b.u32(0)
+	b.u32(t.timescale)
+	b.u32(0)
+	b.u16(0x55c4) // und
+	b.u16(0)
+	b.end()
+	b.beginFull("hdlr", 0, 0)
+	b.u32(0)
+	if t.video {
+		b.bytes([]byte("vide"))
+	} else {
+		b.bytes([]byte("soun"))
+	}
+	b.zeros(12)
+	if t.video {
+		b.bytes([]byte("VideoHandler\x00"))
+	} else {
+		b.bytes([]byte("SoundHandler\x00"))
+	}
+	b.end()
+
+	b.begin("minf")
+	if t.video {
+		b.beginFull("vmhd", 0, 1)
+		b.zeros(8)
+		b.end()
+	} else {
+		b.beginFull("smhd", 0, 0)
+		b.zeros(4)
+		b.end()
+	}
+	b.begin("dinf")
+	b.beginFull("dref", 0, 0)
+	b.u32(1)
+	b.beginFull("url ", 0, 1)
+	b.end()
+	b.end()
+	b.end()
+
+	b.begin("stbl")
+	b.beginFull("stsd", 0, 0)
+	b.u32(1)
+	if t.video {
+		writeAvc1(b, t)
+	} else {
+		writeMp4a(b, t)
+	}
+	b.end()
+	for _, kind := range []string{"stts", "stsc", "stco"} {
+		b.beginFull(kind, 0, 0)
+		b.u32(0)
+		b.end()
+	}
+	b.beginFull("stsz", 0, 0)
+	b.u32(0)
+	b.u32(0)
+	b.end()
+	b.end() // stbl
+	b.end() // minf
+	b.end() // mdia
+	b.end() // trak
+}
+
+func writeAvc1(b *box, t *track) {
+	b.begin("avc1")
+	b.zeros(6)
+	b.u16(1) // data reference index
+	b.zeros(16)
+	b.u16(uint16(t.width))
+	b.u16(uint16(t.height))
+	b.u32(0x00480000)
+	b.u32(0x00480000)
+	b.u32(0)
+	b.u16(1) // frame count
+	b.zeros(32)
+	b.u16(0x0018)
+	b.u16(0xffff)
+	b.begin("avcC")
+	b.bytes(t.config)
+	b.end()
+	b.end()
+}
+
+func writeMp4a(b *box, t *track) {
+	b.begin("mp4a")
+	b.zeros(6)
+	b.u16(1)
+	b.zeros(8)
+	b.u16(uint16(t.channels))
+	b.u16(16)
+	b.zeros(4)
+	b.u32(t.timescale << 16)
+
+	config := t.config
+	decSpecific := 2 + len(config)
+	decConfig := 2 + 13 + decSpecific
+	esDesc := 2 + 3 + decConfig + 3
+	b.beginFull("esds", 0, 0)
+	b.u8(0x03) // ES_DescrTag
+	b.u8(byte(esDesc - 2))
+	b.u16(uint16(t.id))
+	b.u8(0)
+	b.u8(0x04) // DecoderConfigDescrTag
+	b.u8(byte(decConfig - 2))
+	b.u8(0x40) // audio ISO/IEC 14496-3
+	b.u8(0x15) // audio stream
+	b.zeros(3) // buffer size
+	b.u32(0)   // max bitrate
+	b.u32(0)   // avg bitrate
+	b.u8(0x05) // DecSpecificInfoTag
+	b.u8(byte(len(config)))
+	b.bytes(config)
+	b.u8(0x06) // SLConfigDescrTag
+	b.u8(1)
+	b.u8(2)
+	b.end()
+	b.end()
+}
+
+const (
+	trunDataOffset = 0x000001
+	trunDuration   = 0x000100
+	trunSize       = 0x000200
+	trunFlags      = 0x000400
+	trunCTSOffset  = 0x000800
+	tfhdBaseIsMoof = 0x020000
+	sampleSync     = 0x02000000
+	sampleNonSync  = 0x01010000
+)
+
+// writeMoof writes the fragment header, the samples of the tracks follow
+// each other in the mdat in the order of tracks.
+func writeMoof(b *box, sequence uint32, tracks []*track) {
+	moofStart := len(b.buf)
+	b.begin("moof")
+	b.beginFull("mfhd", 0, 0)
+	b.u32(sequence)
+	b.end()
+	var offsetPositions []int
+	for _, t := range tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		b.begin("traf")
+		b.beginFull("tfhd", 0, tfhdBaseIsMoof)
+		b.u32(t.id)
+		b.end()
+		b.beginFull("tfdt", 1, 0)
+		b.u64(t.samples[0].dts)
+		b.end()
+		flags := uint32(trunDataOffset | trunDuration | trunSize | trunFlags)
+		if t.video {
+			flags |= trunCTSOffset
+		}
+		b.beginFull("trun", 1, flags)
+		b.u32(uint32(len(t.samples)))
+		offsetPositions = append(offsetPositions, len(b.buf))
+		b.u32(0)
+		for _, s := range t.samples {
+			b.u32(s.duration)
+			b.u32(uint32(len(s.data)))
+			if s.key {
+				b.u32(sampleSync)
+			} else {
+				b.u32(sampleNonSync)
+			}
+			if t.video {
+				b.u32(uint32(s.cts))
+			}
+		}
+		b.end()
+		b.end()
+	}
+	b.end()
+	dataOffset := len(b.buf) - moofStart + 8
+	i := 0
+	for _, t := range tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint32(b.buf[offsetPositions[i]:], uint32(dataOffset))
+		i++
+		for _, s := range t.samples {
+			dataOffset += len(s.data)
+		}
+	}
+	b.begin("mdat")
+	for _, t := range tracks {
+		for _, s := range t.samples {
+			b.bytes(s.data)
+		}
+	}
+	b.end()
+}
@@ -0,0 +1,278 @@
+// Package fmp4 muxes AVC and AAC from flv tag payloads into a fragmented
+// mp4 file. The moov box is written before the first fragment and every
+// fragment is self-contained, so a file cut at any point plays up to its
+// last complete fragment.
+package fmp4
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gomfc/container/codec"
+)
+
+const movieTimescale = 1000
+const videoTimescale = 1000
+
+// fragmentDuration is the minimal duration of a fragment in milliseconds,
+// fragments are cut on video key frames only.
+const fragmentDuration = 2000
+
+const defaultVideoDuration = 40
+const aacFrameSamples = 1024
+
+var ErrClosed = errors.New("fmp4: muxer is closed")
+
+type sample struct {
+	data     []byte
+	dts      uint64
+	duration uint32
+	cts      int32
+	key      bool
+}
+
+type track struct {
+	id        uint32
+	video     bool
+	timescale uint32
+	width     int
+	height    int
+	channels  int
+	config    []byte
+
+	samples      []sample
+	pending      *sample
+	lastDuration uint32
+	fragmentTime uint64
+}
+
+// push completes the pending sample with the duration up to the next one.
+func (t *track) push(next *sample) {
+	if t.pending != nil {
+		duration := t.lastDuration
+		if next != nil && next.dts > t.pending.dts {
+			duration = uint32(next.dts - t.pending.dts)
+		}
+		if duration == 0 {
+			duration = t.defaultDuration()
+		}
+		t.pending.duration = duration
+		t.lastDuration = duration
+		t.samples = append(t.samples, *t.pending)
+		t.fragmentTime += uint64(duration)
+	}
+	t.pending = next
+}
+
+func (t *track) defaultDuration() uint32 {
+	if t.video {
+		return defaultVideoDuration
+	}
+	return aacFrameSamples
+}
+
+// Muxer writes a fragmented mp4 stream. Media before the first video key
+// frame is dropped, audio is only muxed when its sequence header arrives
+// before the first key frame.
+type Muxer struct {
+	w      io.Writer
+	closer io.Closer
+
+	videoConfig *codec.AVCConfig
+	audioConfig *codec.AudioSpecificConfig
+	video       *track
+	audio       *track
+	tracks      []*track
+	started     bool
+	base        uint32
+	sequence    uint32
+	closed      bool
+	err         error
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	m := &Muxer{w: w}
+	if closer, ok := w.(io.Closer); ok {
+		m.closer = closer
+	}
+	return m
+}
+
+func Create(path string) (m *Muxer, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	m = NewMuxer(f)
+	return
+}
+
+func (m *Muxer) WriteVideo(payload []byte, timestamp uint32) error {
+	if m.closed {
+		return ErrClosed
+	}
+	if m.err != nil {
+		return m.err
+	}
+	tag, err := codec.ParseVideoTag(payload)
+	if err != nil {
+		return err
+	}
+	if tag.IsAVCSequenceHeader() {
+		if m.started {
+			return nil
+		}
+		m.videoConfig, err = codec.ParseAVCConfig(tag.Data)
+		return err
+	}
+	if !tag.IsAVCFrame() || m.videoConfig == nil {
+		return nil
+	}
+	if !m.started {
+		if !tag.IsKeyFrame() {
+			return nil
+		}
+		if err = m.start(timestamp); err != nil {
+			return err
+		}
+	}
+	if timestamp < m.base {
+		return nil
+	}
+	cts := tag.CompositionTime
+	if cts < 0 {
+		cts = 0
+	}
+	next := &sample{
+		data: append([]byte(nil), tag.Data...),
+		dts:  uint64(timestamp - m.base),
+		cts:  cts,
+		key:  tag.IsKeyFrame(),
+	}
+	m.video.push(next)
+	if next.key && m.video.fragmentTime >= fragmentDuration {
+		return m.flush()
+	}
+	return nil
+}
+
+func (m *Muxer) WriteAudio(payload []byte, timestamp uint32) error {
+	if m.closed {
+		return ErrClosed
+	}
+	if m.err != nil {
+		return m.err
+	}
+	tag, err := codec.ParseAudioTag(payload)
+	if err != nil {
+		return err
+	}
+	if tag.IsAACSequenceHeader() {
+		if m.started {
+			return nil
+		}
+		m.audioConfig, err = codec.ParseAudioSpecificConfig(tag.Data)
+		return err
+	}
+	if !m.started || m.audio == nil || !tag.IsAACFrame() || timestamp < m.base {
+		return nil
+	}
+	dts := uint64(timestamp-m.base) * uint64(m.audio.timescale) / 1000
+	if m.audio.pending != nil && dts <= m.audio.pending.dts {
+		dts = m.audio.pending.dts + aacFrameSamples
+	}
+	m.audio.push(&sample{
+		data: append([]byte(nil), tag.Data...),
+		dts:  dts,
+		key:  true,
+	})
+	return nil
+}
+
+// WriteMeta does nothing, the stream properties are taken from the
+// sequence headers.
+func (m *Muxer) WriteMeta(payload []byte, timestamp uint32) error {
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close writes the buffered samples and closes the underlying writer.
+func (m *Muxer) Close() (err error) {
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	if m.started && m.err == nil {
+		for _, t := range m.tracks {
+			t.push(nil)
+		}
+		err = m.flush()
+	}
+	if m.closer != nil {
+		if closeErr := m.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+func (m *Muxer) start(timestamp uint32) (err error) {
+	m.started = true
+	m.base = timestamp
+	m.video = &track{
+		id:        1,
+		video:     true,
+		timescale: videoTimescale,
+		config:    m.videoConfig.Raw,
+	}
+	if len(m.videoConfig.SPS) > 0 {
+		if info, spsErr := codec.ParseSPS(m.videoConfig.SPS[0]); spsErr == nil {
+			m.video.width = info.Width
+			m.video.height = info.Height
+		}
+	}
+	m.tracks = []*track{m.video}
+	if m.audioConfig != nil {
+		m.audio = &track{
+			id:        2,
+			timescale: uint32(m.audioConfig.SampleRate),
+			channels:  m.audioConfig.Channels,
+			config:    m.audioConfig.Raw,
+		}
+		m.tracks = append(m.tracks, m.audio)
+	}
+	b := &box{}
+	writeFtyp(b)
+	writeMoov(b, m.tracks)
+	return m.write(b.buf)
+}
+
+func (m *Muxer) flush() error {
+	empty := true
+	for _, t := range m.tracks {
+		if len(t.samples) > 0 {
+			empty = false
+		}
+	}
+	if empty {
+		return nil
+	}
+	m.sequence++
+	b := &box{}
+	writeMoof(b, m.sequence, m.tracks)
+	for _, t := range m.tracks {
+		t.samples = t.samples[:0]
+		t.fragmentTime = 0
+	}
+	return m.write(b.buf)
+}
+
+func (m *Muxer) write(data []byte) error {
+	if _, err := m.w.Write(data); err != nil {
+		m.err = err
+	}
+	return m.err
+}
@@ -0,0 +1,153 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// baseline profile SPS of 1280x720
+var testSPS = []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+var testPPS = []byte{0x68, 0xce, 0x38, 0x80}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+type testBox struct {
+	kind string
+	data []byte
+}
+
+func readBoxes(t *testing.T, data []byte) (boxes []testBox) {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box header: %v", data)
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("bad box size %d of %q, %d bytes left", size, data[4:8], len(data))
+		}
+		boxes = append(boxes, testBox{string(data[4:8]), data[8:size]})
+		data = data[size:]
+	}
+	return
+}
+
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	for _, kind := range path {
+		found := false
+		for _, b := range readBoxes(t, data) {
+			if b.kind == kind {
+				data = b.data
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("box %q not found in path %v", kind, path)
+		}
+	}
+	return data
+}
+
+func videoConfigTag() []byte {
+	tag := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(testSPS))}
+	tag = append(tag, testSPS...)
+	tag = append(tag, 1, 0, byte(len(testPPS)))
+	return append(tag, testPPS...)
+}
+
+func videoFrameTag(key bool, size int) []byte {
+	tag := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, byte(size - 4)}
+	if key {
+		tag[0] = 0x17
+	}
+	return append(tag, make([]byte, size-4)...)
+}
+
+func TestMuxer(t *testing.T) {
+	out := nopCloser{&bytes.Buffer{}}
+	m := NewMuxer(out)
+	writes := []error{
+		m.WriteVideo(videoFrameTag(true, 10), 0), // no config yet, dropped
+		m.WriteVideo(videoConfigTag(), 0),
+		m.WriteAudio([]byte{0xaf, 0, 0x12, 0x10}, 0),
+		m.WriteVideo(videoFrameTag(false, 10), 10), // before key frame, dropped
+	}
+	for i := 0; i < 100; i++ {
+		ts := uint32(1000 + i*40)
+		writes = append(writes, m.WriteVideo(videoFrameTag(i%50 == 0, 20), ts))
+		writes = append(writes, m.WriteAudio([]byte{0xaf, 1, 1, 2, 3}, ts))
+	}
+	writes = append(writes, m.Close())
+	for i, err := range writes {
+		if err != nil {
+			t.Fatalf("write %d error: %s", i, err)
+		}
+	}
+
+	boxes := readBoxes(t, out.Bytes())
+	var kinds []string
+	for _, b := range boxes {
+		kinds = append(kinds, b.kind)
+	}
+	expect := []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"}
+	if len(kinds) != len(expect) {
+		t.Fatalf("boxes got: %v, expect: %v", kinds, expect)
+	}
+	for i := range expect {
+		if kinds[i] != expect[i] {
+			t.Fatalf("boxes got: %v, expect: %v", kinds, expect)
+		}
+	}
+
+	moov := boxes[1].data
+	tkhd := findBox(t, moov, "trak", "tkhd")
+	width := binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16
+	height := binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16
+	if width != 1280 || height != 720 {
+		t.Errorf("tkhd size got: %dx%d, expect: 1280x720", width, height)
+	}
+	var traks int
+	for _, b := range readBoxes(t, moov) {
+		if b.kind == "trak" {
+			traks++
+		}
+	}
+	if traks != 2 {
+		t.Errorf("trak count got: %d, expect: 2", traks)
+	}
+
+	// the first fragment holds the first GOP: 50 video frames and the audio
+	moof := boxes[2].data
+	trun := findBox(t, moof, "traf", "trun")
+	count := binary.BigEndian.Uint32(trun[4:])
+	offset := binary.BigEndian.Uint32(trun[8:])
+	if count != 50 {
+		t.Errorf("first fragment video samples got: %d, expect: 50", count)
+	}
+	if int(offset) != len(moof)+8+8 {
+		t.Errorf("data offset got: %d, expect: %d", offset, len(moof)+16)
+	}
+	firstDuration := binary.BigEndian.Uint32(trun[12:])
+	if firstDuration != 40 {
+		t.Errorf("sample duration got: %d, expect: 40", firstDuration)
+	}
+	mdat := boxes[3].data
+	if !bytes.Equal(mdat[:20], videoFrameTag(true, 20)[5:]) {
+		t.Errorf("mdat starts with: %v", mdat[:20])
+	}
+}
+
+func TestMuxerClosed(t *testing.T) {
+	m := NewMuxer(nopCloser{&bytes.Buffer{}})
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close error: %s", err)
+	}
+	if err := m.WriteVideo(videoConfigTag(), 0); err != ErrClosed {
+		t.Errorf("WriteVideo after Close got: %v, expect: %v", err, ErrClosed)
+	}
+}
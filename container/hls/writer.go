@@ -0,0 +1,202 @@
+// Package hls writes a live HLS recording: MPEG-TS segments next to an
+// m3u8 playlist, which is rewritten after every segment and finalized
+// with #EXT-X-ENDLIST on close.
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gomfc/container/codec"
+	"gomfc/container/mpegts"
+)
+
+const DefaultSegmentDuration = 4 * time.Second
+
+// maxSegmentAttempts limits the names skipped because a file with the
+// name exists already.
+const maxSegmentAttempts = 1000
+
+var ErrClosed = errors.New("hls: writer is closed")
+
+type segment struct {
+	name     string
+	duration time.Duration
+}
+
+// Writer cuts segments on video key frames once SegmentDuration is reached.
+// ListSize limits the playlist to the last segments, zero keeps all of them
+// so the finished playlist covers the whole recording. Segment files are
+// named after the playlist: rec.m3u8 has rec_00001.ts, rec_00002.ts...
+// Existing files are never overwritten, their names are skipped.
+type Writer struct {
+	SegmentDuration time.Duration
+	ListSize        int
+
+	playlist string
+	base     string
+	muxer    *mpegts.Muxer
+	file     *os.File
+	index    int
+	start    uint32
+	last     uint32
+	segments []segment
+	sequence int
+	closed   bool
+}
+
+func Create(playlist string) (w *Writer, err error) {
+	if err = os.MkdirAll(filepath.Dir(playlist), os.ModePerm); err != nil {
+		return
+	}
+	w = &Writer{
+		SegmentDuration: DefaultSegmentDuration,
+		playlist:        playlist,
+		base:            strings.TrimSuffix(playlist, filepath.Ext(playlist)),
+	}
+	w.muxer = mpegts.NewMuxer(nil)
+	return
+}
+
+func (w *Writer) WriteVideo(payload []byte, timestamp uint32) (err error) {
+	if w.closed {
+		return ErrClosed
+	}
+	if codec.IsKeyFrame(payload) && w.muxer.Started() && w.file != nil &&
+		time.Duration(timestamp-w.start)*time.Millisecond >= w.SegmentDuration {
+		if err = w.finishSegment(timestamp); err != nil {
+			return
+		}
+	}
+	if codec.IsKeyFrame(payload) && w.file == nil {
+		if err = w.openSegment(timestamp); err != nil {
+			return
+		}
+	}
+	if err = w.muxer.WriteVideo(payload, timestamp); err != nil {
+		return
+	}
+	w.advance(timestamp)
+	return
+}
+
+func (w *Writer) WriteAudio(payload []byte, timestamp uint32) (err error) {
+	if w.closed {
+		return ErrClosed
+	}
+	if err = w.muxer.WriteAudio(payload, timestamp); err != nil {
+		return
+	}
+	if w.file != nil {
+		w.advance(timestamp)
+	}
+	return
+}
+
+func (w *Writer) WriteMeta(payload []byte, timestamp uint32) error {
+	if w.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close finishes the last segment and writes the final playlist.
+func (w *Writer) Close() (err error) {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if w.file != nil {
+		err = w.finishSegment(w.last)
+	}
+	if playlistErr := w.writePlaylist(true); err == nil {
+		err = playlistErr
+	}
+	return
+}
+
+func (w *Writer) advance(timestamp uint32) {
+	if timestamp > w.last {
+		w.last = timestamp
+	}
+}
+
+func (w *Writer) segmentName(index int) string {
+	return fmt.Sprintf("%s_%05d.ts", w.base, index)
+}
+
+func (w *Writer) openSegment(timestamp uint32) (err error) {
+	for i := 0; i < maxSegmentAttempts; i++ {
+		w.index++
+		w.file, err = os.OpenFile(w.segmentName(w.index), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		w.file = nil
+		return
+	}
+	w.muxer.SetWriter(w.file)
+	w.start = timestamp
+	w.last = timestamp
+	return
+}
+
+func (w *Writer) finishSegment(end uint32) (err error) {
+	name := filepath.Base(w.file.Name())
+	err = w.file.Close()
+	w.file = nil
+	if err != nil {
+		return
+	}
+	duration := time.Duration(0)
+	if end > w.start {
+		duration = time.Duration(end-w.start) * time.Millisecond
+	}
+	w.segments = append(w.segments, segment{
+		name:     name,
+		duration: duration,
+	})
+	if w.ListSize > 0 && len(w.segments) > w.ListSize {
+		w.sequence += len(w.segments) - w.ListSize
+		w.segments = w.segments[len(w.segments)-w.ListSize:]
+	}
+	return w.writePlaylist(false)
+}
+
+// writePlaylist replaces the playlist atomically, players never see
+// a partially written file.
+func (w *Writer) writePlaylist(final bool) error {
+	target := w.SegmentDuration
+	for _, s := range w.segments {
+		if s.duration > target {
+			target = s.duration
+		}
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(buf, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprintf(buf, "#EXT-X-MEDIA-SEQUENCE:%d\n", w.sequence)
+	if w.ListSize == 0 {
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	for _, s := range w.segments {
+		fmt.Fprintf(buf, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name)
+	}
+	if final {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	tmp := w.playlist + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.playlist)
+}
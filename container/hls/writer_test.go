@@ -0,0 +1,106 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := os.MkdirTemp("", "hls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	playlist := filepath.Join(dir, "rec.m3u8")
+	w, err := Create(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SegmentDuration = time.Second
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+	config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, 0, 4, 0x68, 0xce, 0x38, 0x80)
+	if err = w.WriteVideo(config, 0); err != nil {
+		t.Fatal(err)
+	}
+	for ts := uint32(0); ts < 2500; ts += 100 {
+		frame := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 1, 0x41}
+		if ts%500 == 0 {
+			frame[0] = 0x17
+			frame[9] = 0x65
+		}
+		if err = w.WriteVideo(frame, ts); err != nil {
+			t.Fatal(err)
+		}
+		if ts == 1200 {
+			live, _ := os.ReadFile(playlist)
+			if !strings.Contains(string(live), "rec_00001.ts") || strings.Contains(string(live), "ENDLIST") {
+				t.Errorf("live playlist:\n%s", live)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:EVENT\n" +
+		"#EXTINF:1.000,\nrec_00001.ts\n#EXTINF:1.000,\nrec_00002.ts\n#EXTINF:0.400,\nrec_00003.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if string(data) != expect {
+		t.Errorf("playlist got:\n%s\nexpect:\n%s", data, expect)
+	}
+	for i := 1; i <= 3; i++ {
+		info, err := os.Stat(filepath.Join(dir, w.segmentName(i)[len(dir)+1:]))
+		if err != nil || info.Size() == 0 || info.Size()%188 != 0 {
+			t.Errorf("segment %d: %v, %v", i, info, err)
+		}
+	}
+}
+
+func TestWriterKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	playlist := filepath.Join(dir, "rec.m3u8")
+	existing := filepath.Join(dir, "rec_00002.ts")
+	if err := os.WriteFile(existing, []byte("keep"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SegmentDuration = time.Second
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+	config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, 0, 4, 0x68, 0xce, 0x38, 0x80)
+	if err = w.WriteVideo(config, 0); err != nil {
+		t.Fatal(err)
+	}
+	for ts := uint32(0); ts < 2500; ts += 500 {
+		if err = w.WriteVideo([]byte{0x17, 1, 0, 0, 0, 0, 0, 0, 1, 0x65}, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "rec_00001.ts\n") || strings.Contains(string(data), "rec_00002.ts") ||
+		!strings.Contains(string(data), "rec_00003.ts\n") {
+		t.Errorf("playlist got:\n%s\nexpect rec_00002.ts skipped", data)
+	}
+	if kept, _ := os.ReadFile(existing); string(kept) != "keep" {
+		t.Errorf("existing segment is overwritten: %q", kept)
+	}
+}
@@ -0,0 +1,365 @@
+package mpegts
+
+import (
+	"bytes"
+
+	"gomfc/container/codec"
+)
+
+// timestampWrap is the period of the 33 bit PTS and DTS.
+const timestampWrap = 1 << 33
+
+// TagWriter receives the flv tag payloads of a demuxed stream, a
+// container.Writer is one.
+type TagWriter interface {
+	WriteVideo(payload []byte, timestamp uint32) error
+	WriteAudio(payload []byte, timestamp uint32) error
+}
+
+// Demuxer reads a transport stream with AVC and AAC and writes the frames
+// as flv tag payloads. The sequence headers are written before the first
+// frame and again when the parameter sets change. The timestamps are in
+// milliseconds since the first PTS or DTS of the stream, the wrap of the
+// 33 bit clock is followed, so the segments of a live playlist can be
+// written one after another.
+type Demuxer struct {
+	w        TagWriter
+	partial  []byte
+	pmtPID   int
+	videoPID int
+	audioPID int
+	video    pesStream
+	audio    pesStream
+	clock    timeline
+
+	videoConfig []byte
+	audioConfig []byte
+}
+
+// pesStream collects the packets of a PES packet. The timestamps are
+// read from the first packet, so the clock follows the order of the
+// stream and not the order the packets are finished in.
+type pesStream struct {
+	data   []byte
+	length int
+	open   bool
+	timed  bool
+	pts    int64
+	dts    int64
+}
+
+func NewDemuxer(w TagWriter) *Demuxer {
+	return &Demuxer{
+		w:        w,
+		pmtPID:   -1,
+		videoPID: -1,
+		audioPID: -1,
+	}
+}
+
+// Write implements io.Writer, the packets may be split between the calls.
+// Bytes out of sync are skipped up to the next sync byte. The errors are
+// the errors of the tag writer.
+func (d *Demuxer) Write(data []byte) (n int, err error) {
+	n = len(data)
+	if len(d.partial) > 0 {
+		data = append(d.partial, data...)
+		d.partial = nil
+	}
+	for len(data) >= packetSize {
+		if data[0] != 0x47 {
+			next := bytes.IndexByte(data[1:], 0x47)
+			if next < 0 {
+				return
+			}
+			data = data[next+1:]
+			continue
+		}
+		if err = d.packet(data[:packetSize]); err != nil {
+			return
+		}
+		data = data[packetSize:]
+	}
+	d.partial = append([]byte(nil), data...)
+	return
+}
+
+// Flush writes the frames of the PES packets which are not finished by
+// the next packet yet, it is called at the end of the stream.
+func (d *Demuxer) Flush() error {
+	if err := d.finish(&d.video, true); err != nil {
+		return err
+	}
+	return d.finish(&d.audio, false)
+}
+
+func (d *Demuxer) packet(packet []byte) error {
+	start := packet[1]&0x40 != 0
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	control := packet[3] >> 4 & 0x03
+	payload := packet[4:]
+	if control&0x02 != 0 {
+		size := int(payload[0])
+		if 1+size > len(payload) {
+			return nil
+		}
+		payload = payload[1+size:]
+	}
+	if control&0x01 == 0 || len(payload) == 0 {
+		return nil
+	}
+	switch pid {
+	case patPID:
+		if start {
+			d.parsePAT(payload)
+		}
+	case d.pmtPID:
+		if start {
+			d.parsePMT(payload)
+		}
+	case d.videoPID:
+		return d.collect(&d.video, true, start, payload)
+	case d.audioPID:
+		return d.collect(&d.audio, false, start, payload)
+	}
+	return nil
+}
+
+// section returns the table of a PSI packet payload without the header
+// and the CRC.
+func section(payload []byte, tableID byte) (table []byte, ok bool) {
+	pointer := int(payload[0])
+	if 1+pointer+8 > len(payload) {
+		return
+	}
+	payload = payload[1+pointer:]
+	length := int(payload[1]&0x0f)<<8 | int(payload[2])
+	if payload[0] != tableID || length < 9 || 3+length > len(payload) {
+		return
+	}
+	return payload[8 : 3+length-4], true
+}
+
+func (d *Demuxer) parsePAT(payload []byte) {
+	table, ok := section(payload, 0x00)
+	if !ok {
+		return
+	}
+	for ; len(table) >= 4; table = table[4:] {
+		program := int(table[0])<<8 | int(table[1])
+		if program != 0 {
+			d.pmtPID = int(table[2]&0x1f)<<8 | int(table[3])
+			return
+		}
+	}
+}
+
+func (d *Demuxer) parsePMT(payload []byte) {
+	table, ok := section(payload, 0x02)
+	if !ok || len(table) < 4 {
+		return
+	}
+	infoLength := int(table[2]&0x0f)<<8 | int(table[3])
+	if 4+infoLength > len(table) {
+		return
+	}
+	for table = table[4+infoLength:]; len(table) >= 5; {
+		streamType := table[0]
+		pid := int(table[1]&0x1f)<<8 | int(table[2])
+		switch {
+		case streamType == streamTypeAVC && d.videoPID < 0:
+			d.videoPID = pid
+		case streamType == streamTypeAAC && d.audioPID < 0:
+			d.audioPID = pid
+		}
+		infoLength = int(table[3]&0x0f)<<8 | int(table[4])
+		if 5+infoLength > len(table) {
+			return
+		}
+		table = table[5+infoLength:]
+	}
+}
+
+// collect adds the payload to the PES packet, a packet is finished by
+// the start of the next one or when its length is reached.
+func (d *Demuxer) collect(stream *pesStream, video bool, start bool, payload []byte) error {
+	if start {
+		if err := d.finish(stream, video); err != nil {
+			return err
+		}
+		stream.open = true
+		stream.length = 0
+		if len(payload) >= 6 {
+			if length := int(payload[4])<<8 | int(payload[5]); length > 0 {
+				stream.length = 6 + length
+			}
+		}
+		d.readTimestamps(stream, payload)
+	}
+	if !stream.open {
+		return nil
+	}
+	stream.data = append(stream.data, payload...)
+	if stream.length > 0 && len(stream.data) >= stream.length {
+		return d.finish(stream, video)
+	}
+	return nil
+}
+
+func (d *Demuxer) finish(stream *pesStream, video bool) error {
+	data := stream.data
+	stream.data = stream.data[:0]
+	if !stream.open {
+		return nil
+	}
+	stream.open = false
+	if stream.length > 0 && len(data) > stream.length {
+		data = data[:stream.length]
+	}
+	if !stream.timed || len(data) < 9 {
+		return nil
+	}
+	headerEnd := 9 + int(data[8])
+	if headerEnd > len(data) {
+		return nil
+	}
+	if video {
+		return d.writeVideo(data[headerEnd:], stream.pts, stream.dts)
+	}
+	return d.writeAudio(data[headerEnd:], stream.pts)
+}
+
+// readTimestamps reads the PTS and DTS of the PES header at the start of
+// the payload, the packets without a PTS are dropped.
+func (d *Demuxer) readTimestamps(stream *pesStream, header []byte) {
+	stream.timed = false
+	if len(header) < 9 || header[0] != 0 || header[1] != 0 || header[2] != 1 {
+		return
+	}
+	flags := header[7]
+	headerEnd := 9 + int(header[8])
+	if flags&0x80 == 0 || headerEnd < 14 || headerEnd > len(header) {
+		return
+	}
+	pts := readTimestamp(header[9:])
+	dts := pts
+	if flags&0xc0 == 0xc0 && headerEnd >= 19 {
+		dts = readTimestamp(header[14:])
+	}
+	stream.dts = d.clock.unwrap(dts)
+	stream.pts = stream.dts + int64((pts-dts)%timestampWrap)
+	if stream.pts-stream.dts > timestampWrap/2 {
+		stream.pts = stream.dts
+	}
+	stream.timed = true
+}
+
+func (d *Demuxer) writeVideo(data []byte, pts, dts int64) (err error) {
+	var sps, pps [][]byte
+	var frame []byte
+	key := false
+	for _, nalu := range codec.SplitAnnexB(data) {
+		switch nalu[0] & 0x1f {
+		case codec.NALUTypeAUD:
+			continue
+		case codec.NALUTypeSPS:
+			sps = append(sps, nalu)
+			continue
+		case codec.NALUTypePPS:
+			pps = append(pps, nalu)
+			continue
+		case codec.NALUTypeIDR:
+			key = true
+		}
+		size := len(nalu)
+		frame = append(frame, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+		frame = append(frame, nalu...)
+	}
+	timestamp := d.clock.milliseconds(dts)
+	if len(sps) > 0 && len(pps) > 0 {
+		config, configErr := codec.NewAVCConfig(sps, pps)
+		if configErr == nil && !bytes.Equal(config.Raw, d.videoConfig) {
+			d.videoConfig = config.Raw
+			header := append([]byte{0x17, codec.AVCPacketSequenceHeader, 0, 0, 0}, config.Raw...)
+			if err = d.w.WriteVideo(header, timestamp); err != nil {
+				return
+			}
+		}
+	}
+	if len(frame) == 0 || d.videoConfig == nil {
+		return
+	}
+	frameType := byte(codec.FrameTypeInter)
+	if key {
+		frameType = codec.FrameTypeKey
+	}
+	cts := (pts - dts) / 90
+	header := []byte{frameType<<4 | codec.VideoCodecAVC, codec.AVCPacketNALU, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	return d.w.WriteVideo(append(header, frame...), timestamp)
+}
+
+// aacFlags are the flv sound flags of AAC: 44 kHz, 16 bit, stereo.
+const aacFlags = codec.AudioFormatAAC<<4 | 0x0f
+
+func (d *Demuxer) writeAudio(data []byte, pts int64) (err error) {
+	for len(data) > 0 {
+		config, headerLen, frameLen, parseErr := codec.ParseADTS(data)
+		if parseErr != nil || frameLen > len(data) {
+			return nil
+		}
+		timestamp := d.clock.milliseconds(pts)
+		if !bytes.Equal(config.Raw, d.audioConfig) {
+			d.audioConfig = config.Raw
+			header := append([]byte{aacFlags, codec.AACPacketSequenceHeader}, config.Raw...)
+			if err = d.w.WriteAudio(header, timestamp); err != nil {
+				return
+			}
+		}
+		frame := append([]byte{aacFlags, codec.AACPacketRaw}, data[headerLen:frameLen]...)
+		if err = d.w.WriteAudio(frame, timestamp); err != nil {
+			return
+		}
+		data = data[frameLen:]
+		// a PES packet may hold several frames of 1024 samples
+		pts += 1024 * 90000 / int64(config.SampleRate)
+	}
+	return
+}
+
+func readTimestamp(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 |
+		uint64(b[3])<<7 | uint64(b[4]>>1)
+}
+
+// timeline follows the wraps of the 90 kHz clock and converts the
+// timestamps to milliseconds since the first one.
+type timeline struct {
+	started bool
+	base    int64
+	last    int64
+}
+
+// unwrap returns the timestamp on a clock which does not wrap.
+func (t *timeline) unwrap(ts uint64) int64 {
+	value := int64(ts % timestampWrap)
+	if !t.started {
+		t.started = true
+		t.base = value
+		t.last = value
+	}
+	for value-t.last > timestampWrap/2 {
+		value -= timestampWrap
+	}
+	for t.last-value > timestampWrap/2 {
+		value += timestampWrap
+	}
+	t.last = value
+	return value
+}
+
+func (t *timeline) milliseconds(value int64) uint32 {
+	if value < t.base {
+		return 0
+	}
+	return uint32((value - t.base) / 90)
+}
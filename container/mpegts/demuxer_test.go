@@ -0,0 +1,114 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+type tag struct {
+	video     bool
+	payload   []byte
+	timestamp uint32
+}
+
+type tagRecorder struct {
+	tags []tag
+}
+
+func (r *tagRecorder) WriteVideo(payload []byte, timestamp uint32) error {
+	r.tags = append(r.tags, tag{true, append([]byte(nil), payload...), timestamp})
+	return nil
+}
+
+func (r *tagRecorder) WriteAudio(payload []byte, timestamp uint32) error {
+	r.tags = append(r.tags, tag{false, append([]byte(nil), payload...), timestamp})
+	return nil
+}
+
+func TestDemuxer(t *testing.T) {
+	out := &bytes.Buffer{}
+	m := NewMuxer(out)
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, 0, byte(len(pps)))
+	config = append(config, pps...)
+	keyFrame := append([]byte{0x17, 1, 0, 0, 0, 0, 0, 1, 0x2c, 0x65}, bytes.Repeat([]byte{0x11}, 299)...)
+	interFrame := []byte{0x27, 1, 0, 0, 40, 0, 0, 0, 3, 0x41, 1, 2}
+	steps := []error{
+		m.WriteVideo(config, 1000),
+		m.WriteAudio([]byte{0xaf, 0, 0x12, 0x10}, 1000),
+		m.WriteVideo(keyFrame, 1000),
+		m.WriteAudio([]byte{0xaf, 1, 1, 2, 3}, 1010),
+		m.WriteVideo(interFrame, 1040),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error: %s", i, err)
+		}
+	}
+
+	r := &tagRecorder{}
+	d := NewDemuxer(r)
+	data := out.Bytes()
+	// garbage before the stream and packets split between the writes
+	for _, chunk := range [][]byte{{1, 2, 3}, data[:100], data[100:400], data[400:]} {
+		if _, err := d.Write(chunk); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatalf("Flush error: %s", err)
+	}
+
+	// audio is finished by its length, video by the next packet
+	var video, audio []tag
+	for _, tag := range r.tags {
+		if tag.video {
+			video = append(video, tag)
+		} else {
+			audio = append(audio, tag)
+		}
+	}
+	if len(video) != 3 || len(audio) != 2 {
+		t.Fatalf("tags got: %d video, %d audio, expect: 3 video, 2 audio", len(video), len(audio))
+	}
+	tags := append(video[:2:2], audio...)
+	tags = append(tags, video[2])
+	expect := []struct {
+		video     bool
+		prefix    []byte
+		timestamp uint32
+	}{
+		{true, []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f}, 0},
+		{true, []byte{0x17, 1, 0, 0, 0, 0, 0, 1, 0x2c, 0x65}, 0},
+		{false, []byte{0xaf, 0, 0x12, 0x10}, 10},
+		{false, []byte{0xaf, 1, 1, 2, 3}, 10},
+		{true, []byte{0x27, 1, 0, 0, 40, 0, 0, 0, 3, 0x41, 1, 2}, 40},
+	}
+	for i, e := range expect {
+		got := tags[i]
+		if got.video != e.video || !bytes.HasPrefix(got.payload, e.prefix) || got.timestamp != e.timestamp {
+			t.Errorf("tag %d got: video %t %x at %d, expect: video %t %x at %d",
+				i, got.video, got.payload, got.timestamp, e.video, e.prefix, e.timestamp)
+		}
+	}
+	if !bytes.Contains(video[0].payload, pps) {
+		t.Error("PPS is not in the sequence header")
+	}
+	if !bytes.Equal(video[1].payload, keyFrame) {
+		t.Errorf("key frame got: %x, expect: %x", video[1].payload, keyFrame)
+	}
+}
+
+func TestTimelineWrap(t *testing.T) {
+	var clock timeline
+	start := uint64(timestampWrap - 90*1000)
+	if got := clock.milliseconds(clock.unwrap(start)); got != 0 {
+		t.Errorf("first timestamp got: %d, expect: 0", got)
+	}
+	if got := clock.milliseconds(clock.unwrap(90 * 500)); got != 1500 {
+		t.Errorf("wrapped timestamp got: %d, expect: 1500", got)
+	}
+}
@@ -0,0 +1,324 @@
+// Package mpegts muxes AVC and AAC from flv tag payloads into an MPEG
+// transport stream, as used by HLS segments.
+package mpegts
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"gomfc/container/codec"
+)
+
+const packetSize = 188
+
+const (
+	patPID   = 0x0000
+	pmtPID   = 0x1000
+	videoPID = 0x0100
+	audioPID = 0x0101
+)
+
+const (
+	streamTypeAVC = 0x1b
+	streamTypeAAC = 0x0f
+)
+
+// ptsOffset keeps the first timestamps above zero for players which
+// do not like small PTS values.
+const ptsOffset = 90000
+
+var ErrClosed = errors.New("mpegts: muxer is closed")
+
+var audNALU = []byte{0, 0, 0, 1, 0x09, 0xf0}
+
+// Muxer writes a transport stream. Video is muxed from the first key frame
+// after the AVC sequence header, audio after the AAC sequence header.
+type Muxer struct {
+	w           io.Writer
+	videoConfig *codec.AVCConfig
+	audioConfig *codec.AudioSpecificConfig
+	started     bool
+	tablesDue   bool
+	continuity  map[uint16]byte
+	closed      bool
+	err         error
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		w:          w,
+		tablesDue:  true,
+		continuity: make(map[uint16]byte),
+	}
+}
+
+// Create creates the file and a muxer writing into it, Close closes the
+// file.
+func Create(path string) (m *Muxer, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	m = NewMuxer(f)
+	return
+}
+
+// SetWriter switches the output, the stream tables are repeated at the
+// start of the new output. The previous writer is not closed.
+func (m *Muxer) SetWriter(w io.Writer) {
+	m.w = w
+	m.tablesDue = true
+	m.err = nil
+}
+
+// Started reports whether the first key frame was written.
+func (m *Muxer) Started() bool {
+	return m.started
+}
+
+func (m *Muxer) WriteVideo(payload []byte, timestamp uint32) (err error) {
+	if m.closed {
+		return ErrClosed
+	}
+	tag, err := codec.ParseVideoTag(payload)
+	if err != nil {
+		return
+	}
+	if tag.IsAVCSequenceHeader() {
+		m.videoConfig, err = codec.ParseAVCConfig(tag.Data)
+		return
+	}
+	if !tag.IsAVCFrame() || m.videoConfig == nil {
+		return
+	}
+	key := tag.IsKeyFrame()
+	if !m.started {
+		if !key {
+			return
+		}
+		m.started = true
+	}
+	nalus, err := codec.AnnexB(tag.Data, m.videoConfig.LengthSize)
+	if err != nil {
+		return
+	}
+	data := append([]byte(nil), audNALU...)
+	if key {
+		data = append(data, m.videoConfig.AnnexBParameterSets()...)
+	}
+	data = append(data, nalus...)
+	dts := uint64(timestamp)*90 + ptsOffset
+	pts := dts
+	if tag.CompositionTime > 0 {
+		pts += uint64(tag.CompositionTime) * 90
+	}
+	return m.writePES(videoPID, 0xe0, data, pts, dts, key)
+}
+
+func (m *Muxer) WriteAudio(payload []byte, timestamp uint32) (err error) {
+	if m.closed {
+		return ErrClosed
+	}
+	tag, err := codec.ParseAudioTag(payload)
+	if err != nil {
+		return
+	}
+	if tag.IsAACSequenceHeader() {
+		m.audioConfig, err = codec.ParseAudioSpecificConfig(tag.Data)
+		return
+	}
+	if !m.started || m.audioConfig == nil || !tag.IsAACFrame() {
+		return
+	}
+	data := append(m.audioConfig.ADTSHeader(len(tag.Data)), tag.Data...)
+	pts := uint64(timestamp)*90 + ptsOffset
+	return m.writePES(audioPID, 0xc0, data, pts, pts, false)
+}
+
+// WriteMeta does nothing, transport streams have no script data.
+func (m *Muxer) WriteMeta(payload []byte, timestamp uint32) error {
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close closes the output when it is an io.Closer.
+func (m *Muxer) Close() error {
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	if closer, ok := m.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (m *Muxer) writeTables() error {
+	pat := []byte{
+		0x00, 0x01, // program number
+		0xe0 | pmtPID>>8, pmtPID & 0xff,
+	}
+	if err := m.writePSI(patPID, 0x00, 0x0001, pat); err != nil {
+		return err
+	}
+	pmt := []byte{
+		0xe0 | videoPID>>8, videoPID & 0xff, // PCR PID
+		0xf0, 0x00, // program info length
+		streamTypeAVC, 0xe0 | videoPID>>8, videoPID & 0xff, 0xf0, 0x00,
+	}
+	if m.audioConfig != nil {
+		pmt = append(pmt, streamTypeAAC, 0xe0|audioPID>>8, audioPID&0xff, 0xf0, 0x00)
+	}
+	return m.writePSI(pmtPID, 0x02, 0x0001, pmt)
+}
+
+// writePSI writes a single section table.
+func (m *Muxer) writePSI(pid uint16, tableID byte, tableIDExt uint16, data []byte) error {
+	length := 5 + len(data) + 4
+	section := []byte{
+		tableID,
+		0xb0 | byte(length>>8), byte(length),
+		byte(tableIDExt >> 8), byte(tableIDExt),
+		0xc1, // version 0, current
+		0x00, 0x00,
+	}
+	section = append(section, data...)
+	crc := crc32(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	packet := make([]byte, packetSize)
+	packet[0] = 0x47
+	packet[1] = 0x40 | byte(pid>>8)
+	packet[2] = byte(pid)
+	packet[3] = 0x10 | m.nextContinuity(pid)
+	packet[4] = 0 // pointer field
+	n := copy(packet[5:], section)
+	for i := 5 + n; i < packetSize; i++ {
+		packet[i] = 0xff
+	}
+	return m.write(packet)
+}
+
+func (m *Muxer) writePES(pid uint16, streamID byte, data []byte, pts, dts uint64, key bool) error {
+	if m.tablesDue {
+		if err := m.writeTables(); err != nil {
+			return err
+		}
+		m.tablesDue = false
+	}
+	header := []byte{0, 0, 1, streamID, 0, 0, 0x80}
+	if pts != dts {
+		header = append(header, 0xc0, 10)
+		header = append(header, timestampBytes(0x30, pts)...)
+		header = append(header, timestampBytes(0x10, dts)...)
+	} else {
+		header = append(header, 0x80, 5)
+		header = append(header, timestampBytes(0x20, pts)...)
+	}
+	pesLength := len(header) - 6 + len(data)
+	if pesLength <= 0xffff && streamID != 0xe0 {
+		header[4] = byte(pesLength >> 8)
+		header[5] = byte(pesLength)
+	}
+	pes := append(header, data...)
+
+	first := true
+	for len(pes) > 0 {
+		packet := make([]byte, 0, packetSize)
+		packet = append(packet, 0x47, byte(pid>>8), byte(pid), 0)
+		if first {
+			packet[1] |= 0x40
+		}
+		var adaptation []byte
+		if first && pid == videoPID {
+			flags := byte(0x10) // PCR
+			if key {
+				flags |= 0x40 // random access
+			}
+			pcr := dts - 63000
+			adaptation = []byte{
+				flags,
+				byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1),
+				byte(pcr<<7) | 0x7e, 0x00,
+			}
+		}
+		space := packetSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+		if len(pes) < space {
+			// stuffing in the adaptation field
+			if adaptation == nil {
+				if space-len(pes) == 1 {
+					adaptation = []byte{}
+				} else {
+					adaptation = []byte{0x00}
+				}
+				space -= 1 + len(adaptation)
+			}
+			for len(pes) < space {
+				adaptation = append(adaptation, 0xff)
+				space--
+			}
+		}
+		if adaptation != nil {
+			packet[3] = 0x30 | m.nextContinuity(pid)
+			packet = append(packet, byte(len(adaptation)))
+			packet = append(packet, adaptation...)
+		} else {
+			packet[3] = 0x10 | m.nextContinuity(pid)
+		}
+		n := packetSize - len(packet)
+		packet = append(packet, pes[:n]...)
+		pes = pes[n:]
+		if err := m.write(packet); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+func (m *Muxer) nextContinuity(pid uint16) byte {
+	c := m.continuity[pid]
+	m.continuity[pid] = (c + 1) & 0x0f
+	return c
+}
+
+func (m *Muxer) write(packet []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	_, m.err = m.w.Write(packet)
+	return m.err
+}
+
+func timestampBytes(prefix byte, ts uint64) []byte {
+	ts &= 0x1ffffffff
+	return []byte{
+		prefix | byte(ts>>29)&0x0e | 1,
+		byte(ts >> 22),
+		byte(ts>>14) | 1,
+		byte(ts >> 7),
+		byte(ts<<1) | 1,
+	}
+}
+
+// crc32 is the CRC of MPEG-2 sections.
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
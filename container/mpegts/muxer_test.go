@@ -0,0 +1,58 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC32(t *testing.T) {
+	pat := []byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00}
+	if got := crc32(pat); got != 0x2ab104b2 {
+		t.Errorf("crc32 got: %08x, expect: 2ab104b2", got)
+	}
+}
+
+func TestMuxer(t *testing.T) {
+	out := &bytes.Buffer{}
+	m := NewMuxer(out)
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+	config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, 0, 4, 0x68, 0xce, 0x38, 0x80)
+	frame := append([]byte{0x17, 1, 0, 0, 0, 0, 0, 1, 0x2c}, make([]byte, 300)...)
+	steps := []error{
+		m.WriteVideo(config, 0),
+		m.WriteAudio([]byte{0xaf, 0, 0x12, 0x10}, 0),
+		m.WriteVideo(frame, 0),
+		m.WriteAudio([]byte{0xaf, 1, 1, 2, 3}, 10),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error: %s", i, err)
+		}
+	}
+	data := out.Bytes()
+	if len(data) == 0 || len(data)%packetSize != 0 {
+		t.Fatalf("stream size %d is not a multiple of %d", len(data), packetSize)
+	}
+	pids := make(map[int]int)
+	for i := 0; i < len(data); i += packetSize {
+		if data[i] != 0x47 {
+			t.Fatalf("packet %d has no sync byte", i/packetSize)
+		}
+		pids[int(data[i+1]&0x1f)<<8|int(data[i+2])]++
+	}
+	// PAT, PMT, 2 video packets, 1 audio packet
+	expect := map[int]int{patPID: 1, pmtPID: 1, videoPID: 2, audioPID: 1}
+	for pid, count := range expect {
+		if pids[pid] != count {
+			t.Errorf("pid %#x packets got: %d, expect: %d", pid, pids[pid], count)
+		}
+	}
+	if !bytes.Contains(data, []byte{0, 0, 0, 1, 0x67, 0x42}) {
+		t.Error("SPS is not written before the key frame")
+	}
+	if !bytes.Contains(data, []byte{0xff, 0xf1, 0x50, 0x80}) {
+		t.Error("ADTS header is not found")
+	}
+}
@@ -0,0 +1,127 @@
+package container
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultMaxGap is the largest forward timestamp jump kept as is.
+const DefaultMaxGap = 5 * time.Second
+
+// DefaultMaxBack is the largest backward step kept as is, audio and video
+// tags are often slightly out of order.
+const DefaultMaxBack = 500 * time.Millisecond
+
+// maxFrameDuration limits the frame duration learned from the stream.
+const maxFrameDuration = 1000
+
+const (
+	defaultVideoFrame = 40
+	defaultAudioFrame = 23
+)
+
+// Discontinuity is a timestamp jump found by the normalizer, tags from
+// Timestamp on are moved by Shift milliseconds.
+type Discontinuity struct {
+	Video     bool
+	Timestamp uint32
+	Shift     int64
+}
+
+type normalizerTrack struct {
+	started bool
+	last    int64
+	frame   int64
+}
+
+// Normalizer passes the tags to the writer with timestamps starting from
+// zero. A jump forward above MaxGap or backward above MaxBack, e.g. after
+// NetStream.Play.Reset, is removed: the timeline continues one frame
+// after the last tag. Audio and video timestamps never go backwards.
+// Every discontinuity is passed to OnDiscontinuity, it is logged by
+// default.
+type Normalizer struct {
+	Writer
+	MaxGap          time.Duration
+	MaxBack         time.Duration
+	OnDiscontinuity func(Discontinuity)
+
+	started bool
+	shift   int64
+	last    int64
+	video   normalizerTrack
+	audio   normalizerTrack
+}
+
+func NewNormalizer(w Writer) *Normalizer {
+	return &Normalizer{
+		Writer:  w,
+		MaxGap:  DefaultMaxGap,
+		MaxBack: DefaultMaxBack,
+		OnDiscontinuity: func(d Discontinuity) {
+			track := "audio"
+			if d.Video {
+				track = "video"
+			}
+			log.Printf("%s timestamp %d: discontinuity, moved by %d ms", track, d.Timestamp, d.Shift)
+		},
+	}
+}
+
+// Last returns the largest timestamp written.
+func (n *Normalizer) Last() uint32 {
+	return uint32(n.last)
+}
+
+func (n *Normalizer) WriteVideo(payload []byte, timestamp uint32) error {
+	return n.Writer.WriteVideo(payload, n.timestamp(&n.video, true, timestamp))
+}
+
+func (n *Normalizer) WriteAudio(payload []byte, timestamp uint32) error {
+	return n.Writer.WriteAudio(payload, n.timestamp(&n.audio, false, timestamp))
+}
+
+// WriteMeta writes the script data at the timestamp of the last tag.
+func (n *Normalizer) WriteMeta(payload []byte, timestamp uint32) error {
+	return n.Writer.WriteMeta(payload, uint32(n.last))
+}
+
+func (n *Normalizer) timestamp(track *normalizerTrack, video bool, timestamp uint32) uint32 {
+	if !n.started {
+		n.started = true
+		n.shift = -int64(timestamp)
+	}
+	out := int64(timestamp) + n.shift
+	if track.started {
+		delta := out - track.last
+		if delta > n.MaxGap.Milliseconds() || delta < -n.MaxBack.Milliseconds() {
+			frame := track.frame
+			if frame == 0 {
+				frame = defaultAudioFrame
+				if video {
+					frame = defaultVideoFrame
+				}
+			}
+			shift := n.last + frame - out
+			n.shift += shift
+			out += shift
+			if n.OnDiscontinuity != nil {
+				n.OnDiscontinuity(Discontinuity{Video: video, Timestamp: timestamp, Shift: shift})
+			}
+		} else if delta > 0 && delta <= maxFrameDuration {
+			track.frame = delta
+		}
+		if out < track.last {
+			out = track.last
+		}
+	}
+	if out < 0 {
+		out = 0
+	}
+	track.started = true
+	track.last = out
+	if out > n.last {
+		n.last = out
+	}
+	return uint32(out)
+}
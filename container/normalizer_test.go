@@ -0,0 +1,48 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestNormalizer(t *testing.T) {
+	w := &testWriter{}
+	var discontinuities []Discontinuity
+	n := NewNormalizer(w)
+	n.OnDiscontinuity = func(d Discontinuity) {
+		discontinuities = append(discontinuities, d)
+	}
+	n.WriteMeta([]byte(testMeta), 3000)
+	n.WriteVideo([]byte(testKeyFrame), 3000)
+	n.WriteAudio([]byte(testAudioFrame), 3010)
+	n.WriteVideo([]byte(testInterFrame), 3040)
+	n.WriteAudio([]byte(testAudioFrame), 3005) // out of order, kept monotonic
+	// NetStream.Play.Reset
+	n.WriteVideo([]byte(testKeyFrame), 0)
+	n.WriteAudio([]byte(testAudioFrame), 10)
+	n.WriteVideo([]byte(testInterFrame), 40)
+	// jump forward
+	n.WriteVideo([]byte(testKeyFrame), 90000)
+	n.WriteAudio([]byte(testAudioFrame), 90010)
+
+	expect := []uint32{0, 0, 10, 40, 10, 80, 90, 120, 160, 170}
+	if len(w.tags) != len(expect) {
+		t.Fatalf("tags got: %d, expect: %d", len(w.tags), len(expect))
+	}
+	for i, ts := range expect {
+		if w.tags[i].timestamp != ts {
+			t.Errorf("tag %d timestamp got: %d, expect: %d", i, w.tags[i].timestamp, ts)
+		}
+	}
+	if len(discontinuities) != 2 {
+		t.Fatalf("discontinuities got: %+v, expect: 2", discontinuities)
+	}
+	if d := discontinuities[0]; !d.Video || d.Timestamp != 0 || d.Shift != 3080 {
+		t.Errorf("reset got: %+v", d)
+	}
+	if d := discontinuities[1]; !d.Video || d.Timestamp != 90000 || d.Shift != 160-(90000+80) {
+		t.Errorf("jump got: %+v", d)
+	}
+	if n.Last() != 170 {
+		t.Errorf("last got: %d, expect: 170", n.Last())
+	}
+}
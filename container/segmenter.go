@@ -0,0 +1,196 @@
+package container
+
+import (
+	"errors"
+	"time"
+
+	"gomfc/container/codec"
+)
+
+var ErrSegmenterClosed = errors.New("segmenter is closed")
+
+// SegmentLimits tells when the segmenter starts a new segment,
+// zero values are not limited.
+type SegmentLimits struct {
+	MaxDuration time.Duration
+	MaxBytes    int64
+}
+
+func (l SegmentLimits) Enabled() bool {
+	return l.MaxDuration > 0 || l.MaxBytes > 0
+}
+
+// SegmentFunc creates the writer of the segment with the index,
+// indexes start from 1.
+type SegmentFunc func(index int) (Writer, error)
+
+// Segmenter splits the stream into segments written by separate writers.
+// A new segment starts on a video key frame once a limit is reached and
+// begins with the last onMetaData and the AVC/AAC sequence headers,
+// timestamps of every segment start from zero.
+type Segmenter struct {
+	// OnSegment is called with the input timestamp the segment of the
+	// index starts at, when its first tag is written.
+	OnSegment func(index int, start uint32)
+
+	limits  SegmentLimits
+	create  SegmentFunc
+	current Writer
+	index   int
+	closed  bool
+
+	meta        []byte
+	videoHeader []byte
+	audioHeader []byte
+
+	started bool
+	base    uint32
+	bytes   int64
+}
+
+func NewSegmenter(limits SegmentLimits, create SegmentFunc) *Segmenter {
+	return &Segmenter{
+		limits: limits,
+		create: create,
+	}
+}
+
+// Index returns the index of the current segment, it is 0 before the
+// first segment is created.
+func (s *Segmenter) Index() int {
+	return s.index
+}
+
+func (s *Segmenter) WriteVideo(payload []byte, timestamp uint32) (err error) {
+	if s.closed {
+		return ErrSegmenterClosed
+	}
+	if codec.IsVideoSequenceHeader(payload) {
+		s.videoHeader = append(s.videoHeader[:0], payload...)
+	} else if codec.IsKeyFrame(payload) && s.needRotate(timestamp) {
+		if err = s.rotate(timestamp); err != nil {
+			return
+		}
+	}
+	if err = s.open(); err != nil {
+		return
+	}
+	s.bytes += int64(len(payload))
+	return s.current.WriteVideo(payload, s.timestamp(timestamp))
+}
+
+func (s *Segmenter) WriteAudio(payload []byte, timestamp uint32) (err error) {
+	if s.closed {
+		return ErrSegmenterClosed
+	}
+	if codec.IsAudioSequenceHeader(payload) {
+		s.audioHeader = append(s.audioHeader[:0], payload...)
+	}
+	if err = s.open(); err != nil {
+		return
+	}
+	s.bytes += int64(len(payload))
+	return s.current.WriteAudio(payload, s.timestamp(timestamp))
+}
+
+func (s *Segmenter) WriteMeta(payload []byte, timestamp uint32) (err error) {
+	if s.closed {
+		return ErrSegmenterClosed
+	}
+	s.meta = append(s.meta[:0], payload...)
+	if err = s.open(); err != nil {
+		return
+	}
+	s.bytes += int64(len(payload))
+	return s.current.WriteMeta(payload, s.timestamp(timestamp))
+}
+
+func (s *Segmenter) Close() (err error) {
+	if s.closed {
+		return ErrSegmenterClosed
+	}
+	s.closed = true
+	if s.current != nil {
+		err = s.current.Close()
+		s.current = nil
+	}
+	return
+}
+
+// timestamp returns the timestamp relative to the segment start, the
+// first media tag of the segment sets the start.
+func (s *Segmenter) timestamp(timestamp uint32) uint32 {
+	if !s.started {
+		s.begin(timestamp)
+	}
+	if timestamp < s.base {
+		return 0
+	}
+	return timestamp - s.base
+}
+
+func (s *Segmenter) needRotate(timestamp uint32) bool {
+	if s.current == nil || !s.started {
+		return false
+	}
+	if s.limits.MaxBytes > 0 && s.bytes >= s.limits.MaxBytes {
+		return true
+	}
+	if s.limits.MaxDuration > 0 && timestamp >= s.base &&
+		time.Duration(timestamp-s.base)*time.Millisecond >= s.limits.MaxDuration {
+		return true
+	}
+	return false
+}
+
+func (s *Segmenter) open() (err error) {
+	if s.current != nil {
+		return
+	}
+	s.index++
+	s.current, err = s.create(s.index)
+	s.started = false
+	s.bytes = 0
+	return
+}
+
+func (s *Segmenter) begin(timestamp uint32) {
+	s.started = true
+	s.base = timestamp
+	if s.OnSegment != nil {
+		s.OnSegment(s.index, timestamp)
+	}
+}
+
+// rotate closes the current segment and starts the next one at the
+// timestamp with the saved headers.
+func (s *Segmenter) rotate(timestamp uint32) (err error) {
+	err = s.current.Close()
+	s.current = nil
+	if err != nil {
+		return
+	}
+	if err = s.open(); err != nil {
+		return
+	}
+	s.begin(timestamp)
+	if s.meta != nil {
+		if err = s.current.WriteMeta(s.meta, 0); err != nil {
+			return
+		}
+		s.bytes += int64(len(s.meta))
+	}
+	if s.videoHeader != nil {
+		if err = s.current.WriteVideo(s.videoHeader, 0); err != nil {
+			return
+		}
+		s.bytes += int64(len(s.videoHeader))
+	}
+	if s.audioHeader != nil {
+		if err = s.current.WriteAudio(s.audioHeader, 0); err != nil {
+			return
+		}
+		s.bytes += int64(len(s.audioHeader))
+	}
+	return
+}
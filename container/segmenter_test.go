@@ -0,0 +1,132 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+type testTag struct {
+	kind      byte
+	payload   string
+	timestamp uint32
+}
+
+type testWriter struct {
+	tags   []testTag
+	closed bool
+}
+
+func (w *testWriter) WriteVideo(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'v', string(payload), timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteAudio(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'a', string(payload), timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteMeta(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{'m', string(payload), timestamp})
+	return nil
+}
+
+func (w *testWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+var (
+	testVideoHeader = "\x17\x00\x00\x00\x00avc"
+	testAudioHeader = "\xaf\x00asc"
+	testMeta        = "\x02\x00\x0aonMetaData"
+	testKeyFrame    = "\x17\x01\x00\x00\x00key"
+	testInterFrame  = "\x27\x01\x00\x00\x00inter"
+	testAudioFrame  = "\xaf\x01aac"
+)
+
+func TestSegmenterDuration(t *testing.T) {
+	var segments []*testWriter
+	s := NewSegmenter(SegmentLimits{MaxDuration: time.Second}, func(index int) (Writer, error) {
+		if index != len(segments)+1 {
+			t.Errorf("segment index got: %d, expect: %d", index, len(segments)+1)
+		}
+		w := &testWriter{}
+		segments = append(segments, w)
+		return w, nil
+	})
+	var starts []uint32
+	s.OnSegment = func(index int, start uint32) {
+		if index != len(starts)+1 {
+			t.Errorf("started segment index got: %d, expect: %d", index, len(starts)+1)
+		}
+		starts = append(starts, start)
+	}
+	s.WriteMeta([]byte(testMeta), 5000)
+	s.WriteVideo([]byte(testVideoHeader), 5000)
+	s.WriteAudio([]byte(testAudioHeader), 5000)
+	for ts := uint32(5000); ts < 7600; ts += 100 {
+		frame := testInterFrame
+		if ts%1000 == 0 || ts == 5700 {
+			frame = testKeyFrame
+		}
+		s.WriteVideo([]byte(frame), ts)
+		s.WriteAudio([]byte(testAudioFrame), ts+10)
+	}
+	s.Close()
+
+	// key frames at 5000, 5700, 6000, 7000: rotation on 6000 and 7000
+	if len(segments) != 3 {
+		t.Fatalf("segments got: %d, expect: 3", len(segments))
+	}
+	if len(starts) != 3 || starts[0] != 5000 || starts[1] != 6000 || starts[2] != 7000 {
+		t.Errorf("segment starts got: %v, expect: [5000 6000 7000]", starts)
+	}
+	for i, segment := range segments {
+		if !segment.closed {
+			t.Errorf("segment %d is not closed", i+1)
+		}
+		expectHead := []testTag{
+			{'m', testMeta, 0},
+			{'v', testVideoHeader, 0},
+			{'a', testAudioHeader, 0},
+			{'v', testKeyFrame, 0},
+		}
+		if len(segment.tags) < len(expectHead) {
+			t.Fatalf("segment %d tags: %v", i+1, segment.tags)
+		}
+		for j, expect := range expectHead {
+			if segment.tags[j] != expect {
+				t.Errorf("segment %d tag %d got: %+v, expect: %+v", i+1, j, segment.tags[j], expect)
+			}
+		}
+	}
+	last := segments[1].tags[len(segments[1].tags)-1]
+	if last.timestamp != 910 {
+		t.Errorf("last timestamp of segment 2 got: %d, expect: 910", last.timestamp)
+	}
+}
+
+func TestSegmenterBytes(t *testing.T) {
+	var segments []*testWriter
+	s := NewSegmenter(SegmentLimits{MaxBytes: 50}, func(index int) (Writer, error) {
+		w := &testWriter{}
+		segments = append(segments, w)
+		return w, nil
+	})
+	s.WriteVideo([]byte(testVideoHeader), 0)
+	for i := 0; i < 10; i++ {
+		s.WriteVideo([]byte(testInterFrame), uint32(i*40))
+	}
+	if len(segments) != 1 {
+		t.Fatalf("rotated without key frame, segments: %d", len(segments))
+	}
+	s.WriteVideo([]byte(testKeyFrame), 400)
+	s.Close()
+	if len(segments) != 2 {
+		t.Fatalf("segments got: %d, expect: 2", len(segments))
+	}
+	if s.WriteVideo([]byte(testKeyFrame), 500) != ErrSegmenterClosed {
+		t.Error("write after Close does not fail")
+	}
+}
@@ -0,0 +1,82 @@
+// Package container defines the writer the rtmp handler records into
+// and creates writers for the supported output formats.
+package container
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/zhangpeihao/goflv"
+
+	"gomfc/container/flvfile"
+	"gomfc/container/fmp4"
+	"gomfc/container/hls"
+	"gomfc/container/mpegts"
+)
+
+// Writer receives the payloads of flv audio, video and script data tags
+// with their timestamps in milliseconds. The script data is the
+// onMetaData of the stream.
+type Writer interface {
+	WriteVideo(payload []byte, timestamp uint32) error
+	WriteAudio(payload []byte, timestamp uint32) error
+	WriteMeta(payload []byte, timestamp uint32) error
+	Close() error
+}
+
+// FLVWriter writes tags into an opened goflv file as is, the metadata is
+// not updated. Create returns a flvfile.Writer for flv files instead.
+type FLVWriter struct {
+	*flv.File
+}
+
+func NewFLVWriter(file *flv.File) *FLVWriter {
+	return &FLVWriter{File: file}
+}
+
+func (w *FLVWriter) WriteVideo(payload []byte, timestamp uint32) error {
+	return w.WriteVideoTag(payload, timestamp)
+}
+
+func (w *FLVWriter) WriteAudio(payload []byte, timestamp uint32) error {
+	return w.WriteAudioTag(payload, timestamp)
+}
+
+func (w *FLVWriter) WriteMeta(payload []byte, timestamp uint32) error {
+	return w.WriteTag(payload, flv.SCRIPT_DATA_TAG, timestamp)
+}
+
+func (w *FLVWriter) Close() error {
+	w.File.Close()
+	return nil
+}
+
+// Create creates a writer for the format given by the file extension,
+// ".mp4" is a fragmented mp4 file, ".m3u8" is a live HLS playlist with
+// MPEG-TS segments, ".ts" is a single MPEG-TS file, anything else is flv
+// with the metadata updated on close.
+func Create(path string) (w Writer, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v":
+		var muxer *fmp4.Muxer
+		if muxer, err = fmp4.Create(path); err == nil {
+			w = muxer
+		}
+	case ".m3u8":
+		var hlsWriter *hls.Writer
+		if hlsWriter, err = hls.Create(path); err == nil {
+			w = hlsWriter
+		}
+	case ".ts":
+		var tsMuxer *mpegts.Muxer
+		if tsMuxer, err = mpegts.Create(path); err == nil {
+			w = tsMuxer
+		}
+	default:
+		var flvWriter *flvfile.Writer
+		if flvWriter, err = flvfile.Create(path); err == nil {
+			w = flvWriter
+		}
+	}
+	return
+}
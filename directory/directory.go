@@ -0,0 +1,371 @@
+// Package directory keeps the set of the online models streamed by the
+// room data subscription of the websocket. It answers filtered snapshots,
+// the models which came online or went offline since a moment and sends
+// the changes to the subscribers.
+package directory
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/fcs"
+	"gomfc/models"
+)
+
+// DefaultMaxChanges is the number of changes kept for Since.
+const DefaultMaxChanges = 100000
+
+var ErrTruncated = errors.New("directory: changes before the moment are not kept anymore")
+
+// Entry is an online model.
+type Entry struct {
+	models.MFCModel
+	// Online is when the model came online, Updated when its state was
+	// received last.
+	Online  time.Time
+	Updated time.Time
+}
+
+type ChangeType int
+
+const (
+	ChangeOnline ChangeType = iota
+	ChangeOffline
+	ChangeState
+)
+
+var changeTypeVerbose = map[ChangeType]string{
+	ChangeOnline:  "online",
+	ChangeOffline: "offline",
+	ChangeState:   "state",
+}
+
+func (t ChangeType) String() string {
+	return changeTypeVerbose[t]
+}
+
+// Change is a model which came online, went offline or changed its
+// state, camserv or flags while online. Entry of ChangeOffline is the
+// last state of the model with the offline vs.
+type Change struct {
+	Type  ChangeType
+	Time  time.Time
+	Entry Entry
+}
+
+// Directory is the set of the online models, it is safe for concurrent
+// use. A model is online in any state except models.IsOff and
+// models.Except.
+type Directory struct {
+	mu          sync.RWMutex
+	entries     map[uint64]Entry
+	changes     []Change
+	maxChanges  int
+	truncated   time.Time
+	subscribers map[*Subscription]struct{}
+}
+
+func New() *Directory {
+	return NewWithLimit(DefaultMaxChanges)
+}
+
+// NewWithLimit creates a directory keeping up to maxChanges changes for
+// Since.
+func NewWithLimit(maxChanges int) *Directory {
+	return &Directory{
+		entries:     make(map[uint64]Entry),
+		maxChanges:  maxChanges,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// IsOnline reports whether the vs is an online state.
+func IsOnline(vs uint64) bool {
+	return vs != models.IsOff && vs != models.Except
+}
+
+// HandleMessage updates the directory with the models of the websocket
+// message, it can be used as a ws_client.WSMsgHandler. The updates carry
+// only the changed fields, they are merged into the known state.
+func (d *Directory) HandleMessage(msg string) error {
+	frames, _ := fcs.ParseMessage(msg)
+	now := time.Now()
+	for _, frame := range frames {
+		if _, err := models.FromFrame(frame); err != nil {
+			continue
+		}
+		var state partialState
+		if frame.Unmarshal(&state) == nil && state.Uid != 0 {
+			d.merge(state, now)
+		}
+	}
+	return nil
+}
+
+// partialState is a state update of a user, the missing fields are nil.
+type partialState struct {
+	Lv  *int
+	Nm  *string
+	Pid *int64
+	Sid *uint64
+	Uid uint64
+	Vs  *uint64
+	U   *struct {
+		Camserv *int32
+	}
+	M *struct {
+		Flags *int32
+	}
+}
+
+// apply sets the fields of the update in the model.
+func (p partialState) apply(model *models.MFCModel) {
+	model.Uid = p.Uid
+	if p.Lv != nil {
+		model.Lv = *p.Lv
+	}
+	if p.Nm != nil {
+		model.Nm = *p.Nm
+	}
+	if p.Pid != nil {
+		model.Pid = *p.Pid
+	}
+	if p.Sid != nil {
+		model.Sid = *p.Sid
+	}
+	if p.Vs != nil {
+		model.Vs = *p.Vs
+	}
+	if p.U != nil && p.U.Camserv != nil {
+		model.U.Camserv = *p.U.Camserv
+	}
+	if p.M != nil && p.M.Flags != nil {
+		model.M.Flags = *p.M.Flags
+	}
+	model.Exists = model.Nm != ""
+	model.SetStatus()
+}
+
+// merge applies the update to the known state of the model, an update
+// of an unknown user is applied to an empty state.
+func (d *Directory) merge(state partialState, at time.Time) (change Change, changed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	model := d.entries[state.Uid].MFCModel
+	state.apply(&model)
+	return d.update(model, at)
+}
+
+// Update applies the full state of the model received at the moment,
+// states of other users than models are ignored.
+func (d *Directory) Update(model models.MFCModel, at time.Time) (change Change, changed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.update(model, at)
+}
+
+// update is Update with d.mu held.
+func (d *Directory) update(model models.MFCModel, at time.Time) (change Change, changed bool) {
+	if model.Lv != models.ModelLv {
+		return
+	}
+	old, known := d.entries[model.Uid]
+	online := IsOnline(model.Vs)
+	entry := Entry{MFCModel: model, Online: at, Updated: at}
+	switch {
+	case !known && online:
+		change = Change{Type: ChangeOnline, Time: at, Entry: entry}
+	case known && !online:
+		entry.Online = old.Online
+		change = Change{Type: ChangeOffline, Time: at, Entry: entry}
+	case known && online:
+		entry.Online = old.Online
+		d.entries[model.Uid] = entry
+		if old.Vs == model.Vs && old.U.Camserv == model.U.Camserv && old.M.Flags == model.M.Flags {
+			return
+		}
+		change = Change{Type: ChangeState, Time: at, Entry: entry}
+	default:
+		return
+	}
+	if online {
+		d.entries[model.Uid] = entry
+	} else {
+		delete(d.entries, model.Uid)
+	}
+	d.record(change)
+	return change, true
+}
+
+// Reconcile sends the models which were not updated since the moment
+// offline at the time at. It is called a while after the websocket
+// session is lost and logged in again: the new session sends the state
+// of every online model, the ones which went offline meanwhile are not
+// sent at all.
+func (d *Directory) Reconcile(since, at time.Time) (changes []Change) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var stale []Entry
+	for _, entry := range d.entries {
+		if entry.Updated.Before(since) {
+			stale = append(stale, entry)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Uid < stale[j].Uid
+	})
+	for _, entry := range stale {
+		delete(d.entries, entry.Uid)
+		entry.Vs = models.IsOff
+		entry.SetStatus()
+		change := Change{Type: ChangeOffline, Time: at, Entry: entry}
+		d.record(change)
+		changes = append(changes, change)
+	}
+	return
+}
+
+// record keeps the change and sends it to the subscribers, d.mu must
+// be held.
+func (d *Directory) record(change Change) {
+	d.changes = append(d.changes, change)
+	if over := len(d.changes) - d.maxChanges; over > 0 {
+		d.truncated = d.changes[over-1].Time
+		d.changes = append(d.changes[:0], d.changes[over:]...)
+	}
+	for s := range d.subscribers {
+		s.send(change)
+	}
+}
+
+// Len returns the number of the online models.
+func (d *Directory) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.entries)
+}
+
+// Get returns the online model with the uid.
+func (d *Directory) Get(uid uint64) (entry Entry, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	entry, ok = d.entries[uid]
+	return
+}
+
+// Snapshot returns the online models sorted by name.
+func (d *Directory) Snapshot() Snapshot {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	snapshot := Snapshot{
+		Time:   time.Now(),
+		Models: make([]Entry, 0, len(d.entries)),
+	}
+	for _, entry := range d.entries {
+		snapshot.Models = append(snapshot.Models, entry)
+	}
+	sort.Slice(snapshot.Models, func(i, j int) bool {
+		return strings.ToLower(snapshot.Models[i].Nm) < strings.ToLower(snapshot.Models[j].Nm)
+	})
+	return snapshot
+}
+
+// Find returns the online models matching the filter sorted by name.
+func (d *Directory) Find(filter Filter) []Entry {
+	return d.Snapshot().Filter(filter).Models
+}
+
+// Since returns the models which came online or went offline after the
+// moment. A model which went offline and came back is not in the diff.
+// ErrTruncated is returned when the changes after the moment are not
+// kept anymore.
+func (d *Directory) Since(t time.Time) (diff Diff, err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if t.Before(d.truncated) {
+		err = ErrTruncated
+		return
+	}
+	first := sort.Search(len(d.changes), func(i int) bool {
+		return d.changes[i].Time.After(t)
+	})
+	// the first change of a model tells whether it was online at t
+	wasOnline := make(map[uint64]bool)
+	last := make(map[uint64]Change)
+	var order []uint64
+	for _, change := range d.changes[first:] {
+		uid := change.Entry.Uid
+		if _, seen := wasOnline[uid]; !seen {
+			wasOnline[uid] = change.Type != ChangeOnline
+			order = append(order, uid)
+		}
+		last[uid] = change
+	}
+	for _, uid := range order {
+		current, online := d.entries[uid]
+		switch {
+		case online && !wasOnline[uid]:
+			diff.Online = append(diff.Online, current)
+		case !online && wasOnline[uid]:
+			diff.Offline = append(diff.Offline, last[uid].Entry)
+		}
+	}
+	return
+}
+
+// Subscribe returns a subscription receiving the changes. The changes
+// are dropped while the buffer of the subscription is full.
+func (d *Directory) Subscribe(buffer int) *Subscription {
+	s := &Subscription{
+		directory: d,
+		changes:   make(chan Change, buffer),
+	}
+	d.mu.Lock()
+	d.subscribers[s] = struct{}{}
+	d.mu.Unlock()
+	return s
+}
+
+// Subscription receives the changes of a directory until it is closed.
+type Subscription struct {
+	directory *Directory
+	changes   chan Change
+	dropped   int
+	closed    bool
+}
+
+// Changes returns the channel of the changes, it is closed by Close.
+func (s *Subscription) Changes() <-chan Change {
+	return s.changes
+}
+
+// Dropped returns the number of the changes dropped because the buffer
+// was full.
+func (s *Subscription) Dropped() int {
+	s.directory.mu.RLock()
+	defer s.directory.mu.RUnlock()
+	return s.dropped
+}
+
+func (s *Subscription) Close() {
+	d := s.directory
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(d.subscribers, s)
+	close(s.changes)
+}
+
+func (s *Subscription) send(change Change) {
+	select {
+	case s.changes <- change:
+	default:
+		s.dropped++
+	}
+}
@@ -0,0 +1,213 @@
+package directory
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"gomfc/models"
+)
+
+var testStart = time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC)
+
+func testModel(uid uint64, name string, vs uint64, camserv int32, hd bool) models.MFCModel {
+	m := models.MFCModel{Lv: models.ModelLv, Nm: name, Uid: uid, Vs: vs, Exists: true}
+	m.U.Camserv = camserv
+	if hd {
+		m.M.Flags = models.HDFlag
+	}
+	return m
+}
+
+func at(minutes int) time.Time {
+	return testStart.Add(time.Duration(minutes) * time.Minute)
+}
+
+func names(entries []Entry) (list []string) {
+	for _, entry := range entries {
+		list = append(list, entry.Nm)
+	}
+	return
+}
+
+func TestUpdate(t *testing.T) {
+	d := New()
+	cases := []struct {
+		model   models.MFCModel
+		changed bool
+		change  ChangeType
+	}{
+		{testModel(1, "Anna", models.IsOnline, 1544, true), true, ChangeOnline},
+		{testModel(1, "Anna", models.IsOnline, 1544, true), false, 0},
+		{testModel(1, "Anna", models.IsPrivate, 1544, true), true, ChangeState},
+		{testModel(2, "Bella", models.IsOff, 1545, false), false, 0},
+		{models.MFCModel{Lv: 1, Nm: "guest", Uid: 3}, false, 0},
+		{testModel(1, "Anna", models.Except, 1544, true), true, ChangeOffline},
+	}
+	for i, c := range cases {
+		change, changed := d.Update(c.model, at(i))
+		if changed != c.changed || changed && change.Type != c.change {
+			t.Errorf("update %d: %v %v, want %v %v", i, change.Type, changed, c.change, c.changed)
+		}
+	}
+	if d.Len() != 0 {
+		t.Errorf("online after the models went off: %v", names(d.Snapshot().Models))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	d := New()
+	d.Update(testModel(1, "Anna", models.IsOnline, 1544, true), at(0))
+	d.Update(testModel(2, "annabelle", models.IsAway, 1545, false), at(0))
+	d.Update(testModel(3, "Bella", models.IsPrivate, 1544, false), at(0))
+	hd, sd := true, false
+	cases := []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, "[Anna annabelle Bella]"},
+		{Filter{Vs: []uint64{models.IsAway, models.IsPrivate}}, "[annabelle Bella]"},
+		{Filter{HD: &hd}, "[Anna]"},
+		{Filter{HD: &sd, Camserv: []int32{1544}}, "[Bella]"},
+		{Filter{Name: "ANNA*"}, "[Anna annabelle]"},
+		{Filter{Name: "?ella"}, "[Bella]"},
+		{Filter{Name: "[anna"}, "[]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(names(d.Find(c.filter))); got != c.want {
+			t.Errorf("filter %+v: %s, want %s", c.filter, got, c.want)
+		}
+	}
+	if (Filter{Name: "[anna"}).Validate() == nil {
+		t.Error("malformed pattern is valid")
+	}
+	if vs, ok := ParseStatus("Private"); !ok || vs != models.IsPrivate {
+		t.Errorf("ParseStatus(Private) = %d, %v", vs, ok)
+	}
+	if _, ok := ParseStatus("busy"); ok {
+		t.Error("ParseStatus(busy) ok")
+	}
+}
+
+func TestSince(t *testing.T) {
+	d := New()
+	d.Update(testModel(1, "Anna", models.IsOnline, 1544, false), at(0))
+	d.Update(testModel(2, "Bella", models.IsOnline, 1544, false), at(0))
+	d.Update(testModel(3, "Carla", models.IsOnline, 1544, false), at(0))
+	before := d.Snapshot()
+
+	d.Update(testModel(4, "Dana", models.IsOnline, 1544, false), at(10))
+	d.Update(testModel(2, "Bella", models.IsOff, 1544, false), at(11))
+	d.Update(testModel(3, "Carla", models.IsOff, 1544, false), at(12))
+	d.Update(testModel(3, "Carla", models.IsAway, 1544, false), at(13))
+	d.Update(testModel(1, "Anna", models.IsAway, 1544, false), at(14))
+
+	diff, err := d.Since(at(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names(diff.Online)) != "[Dana]" || fmt.Sprint(names(diff.Offline)) != "[Bella]" {
+		t.Errorf("since: online %v, offline %v", names(diff.Online), names(diff.Offline))
+	}
+	diff, _ = d.Since(at(12))
+	if fmt.Sprint(names(diff.Online)) != "[Carla]" || len(diff.Offline) != 0 {
+		t.Errorf("since 12: online %v, offline %v", names(diff.Online), names(diff.Offline))
+	}
+
+	diff = before.Diff(d.Snapshot())
+	if fmt.Sprint(names(diff.Online)) != "[Dana]" || fmt.Sprint(names(diff.Offline)) != "[Bella]" {
+		t.Errorf("snapshot diff: online %v, offline %v", names(diff.Online), names(diff.Offline))
+	}
+}
+
+func TestSinceTruncated(t *testing.T) {
+	d := NewWithLimit(2)
+	for i := 0; i < 4; i++ {
+		d.Update(testModel(uint64(i), fmt.Sprintf("Model%d", i), models.IsOnline, 1544, false), at(i))
+	}
+	if _, err := d.Since(at(0)); err != ErrTruncated {
+		t.Errorf("since a dropped change: %v", err)
+	}
+	diff, err := d.Since(at(1))
+	if err != nil || fmt.Sprint(names(diff.Online)) != "[Model2 Model3]" {
+		t.Errorf("since a kept change: %v, %v", names(diff.Online), err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	d := New()
+	s := d.Subscribe(1)
+	d.Update(testModel(1, "Anna", models.IsOnline, 1544, false), at(0))
+	d.Update(testModel(2, "Bella", models.IsOnline, 1544, false), at(0))
+	change := <-s.Changes()
+	if change.Type != ChangeOnline || change.Entry.Nm != "Anna" {
+		t.Errorf("change: %+v", change)
+	}
+	if s.Dropped() != 1 {
+		t.Errorf("dropped %d", s.Dropped())
+	}
+	s.Close()
+	s.Close()
+	if _, ok := <-s.Changes(); ok {
+		t.Error("changes after Close")
+	}
+	d.Update(testModel(1, "Anna", models.IsOff, 1544, false), at(1))
+}
+
+func TestHandleMessage(t *testing.T) {
+	d := New()
+	frame := func(name string, uid uint64, lv int) string {
+		return url.QueryEscape(fmt.Sprintf(`20 0 1 0 0 {"lv":%d,"nm":"%s","uid":%d,"vs":0}`, lv, name, uid))
+	}
+	d.HandleMessage(frame("Anna", 1, models.ModelLv) + frame("guest", 2, 0) + frame("Bella", 3, models.ModelLv))
+	if got := fmt.Sprint(names(d.Snapshot().Models)); got != "[Anna Bella]" {
+		t.Errorf("models: %s", got)
+	}
+}
+
+func TestHandlePartialUpdate(t *testing.T) {
+	d := New()
+	frame := func(payload string) string {
+		return url.QueryEscape("20 0 1 0 0 " + payload)
+	}
+	s := d.Subscribe(10)
+	d.HandleMessage(frame(`{"lv":4,"nm":"Anna","uid":1,"vs":0,"u":{"camserv":1544},"m":{"flags":1024}}`))
+	d.HandleMessage(frame(`{"uid":1,"sid":42}`))
+	d.HandleMessage(frame(`{"uid":1,"vs":2}`))
+	d.HandleMessage(frame(`{"uid":2,"vs":0}`))
+	s.Close()
+	var changes []ChangeType
+	for change := range s.Changes() {
+		changes = append(changes, change.Type)
+	}
+	if fmt.Sprint(changes) != "[online state]" {
+		t.Errorf("changes got: %v, expect: [online state]", changes)
+	}
+	entry, ok := d.Get(1)
+	if !ok || entry.Nm != "Anna" || entry.Sid != 42 || entry.Vs != models.IsAway || entry.U.Camserv != 1544 || entry.M.Flags != 1024 {
+		t.Errorf("merged entry: %+v, %v", entry, ok)
+	}
+	if d.Len() != 1 {
+		t.Errorf("models got: %d, expect: 1", d.Len())
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	d := New()
+	d.Update(testModel(1, "Anna", models.IsOnline, 1544, true), at(0))
+	d.Update(testModel(2, "Bella", models.IsOnline, 1545, false), at(0))
+	// reconnected at 10, only Bella is sent again
+	d.Update(testModel(2, "Bella", models.IsOnline, 1545, false), at(11))
+	changes := d.Reconcile(at(10), at(12))
+	if len(changes) != 1 || changes[0].Type != ChangeOffline || changes[0].Entry.Nm != "Anna" || changes[0].Entry.Vs != models.IsOff {
+		t.Fatalf("changes: %+v", changes)
+	}
+	if got := fmt.Sprint(names(d.Snapshot().Models)); got != "[Bella]" {
+		t.Errorf("models: %s", got)
+	}
+	diff, err := d.Since(at(5))
+	if err != nil || len(diff.Offline) != 1 || diff.Offline[0].Nm != "Anna" {
+		t.Errorf("diff: %+v, %v", diff, err)
+	}
+}
@@ -0,0 +1,130 @@
+package directory
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomfc/models"
+)
+
+// Snapshot is the set of the online models at Time sorted by name.
+type Snapshot struct {
+	Time   time.Time
+	Models []Entry
+}
+
+// Filter returns the models matching the filter.
+func (s Snapshot) Filter(filter Filter) Snapshot {
+	filtered := Snapshot{Time: s.Time, Models: make([]Entry, 0)}
+	for _, entry := range s.Models {
+		if filter.Match(entry) {
+			filtered.Models = append(filtered.Models, entry)
+		}
+	}
+	return filtered
+}
+
+// Diff returns the models online in the newer snapshot only and the
+// models online in s only.
+func (s Snapshot) Diff(newer Snapshot) (diff Diff) {
+	old := make(map[uint64]bool, len(s.Models))
+	for _, entry := range s.Models {
+		old[entry.Uid] = true
+	}
+	current := make(map[uint64]bool, len(newer.Models))
+	for _, entry := range newer.Models {
+		current[entry.Uid] = true
+		if !old[entry.Uid] {
+			diff.Online = append(diff.Online, entry)
+		}
+	}
+	for _, entry := range s.Models {
+		if !current[entry.Uid] {
+			diff.Offline = append(diff.Offline, entry)
+		}
+	}
+	return
+}
+
+// Diff holds the models which came online and went offline.
+type Diff struct {
+	Online  []Entry
+	Offline []Entry
+}
+
+// Filter selects the models, the empty fields match every model.
+type Filter struct {
+	// Vs is the list of the accepted states
+	Vs []uint64
+	// HD selects the models with or without the HD flag
+	HD *bool
+	// Camserv is the list of the accepted video servers
+	Camserv []int32
+	// Name is a pattern of the name with the syntax of path.Match,
+	// e.g. "anna*", the case is ignored
+	Name string
+}
+
+// Match reports whether the model matches the filter. A malformed
+// name pattern matches nothing, see Validate.
+func (f Filter) Match(entry Entry) bool {
+	if len(f.Vs) > 0 && !containsVs(f.Vs, entry.Vs) {
+		return false
+	}
+	if f.HD != nil && entry.IsHD() != *f.HD {
+		return false
+	}
+	if len(f.Camserv) > 0 && !containsCamserv(f.Camserv, entry.U.Camserv) {
+		return false
+	}
+	if f.Name != "" {
+		matched, err := path.Match(strings.ToLower(f.Name), strings.ToLower(entry.Nm))
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the name pattern.
+func (f Filter) Validate() error {
+	_, err := path.Match(f.Name, "")
+	return err
+}
+
+func containsVs(list []uint64, vs uint64) bool {
+	for _, item := range list {
+		if item == vs {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCamserv(list []int32, camserv int32) bool {
+	for _, item := range list {
+		if item == camserv {
+			return true
+		}
+	}
+	return false
+}
+
+var statusNames = map[string]uint64{
+	"online":  models.IsOnline,
+	"away":    models.IsAway,
+	"private": models.IsPrivate,
+	"group":   models.IsGroup,
+}
+
+// ParseStatus returns the vs of a status name: online, away, private or
+// group, or of a number.
+func ParseStatus(name string) (vs uint64, ok bool) {
+	if vs, ok = statusNames[strings.ToLower(name)]; ok {
+		return
+	}
+	vs, err := strconv.ParseUint(name, 10, 64)
+	return vs, err == nil
+}
@@ -0,0 +1,214 @@
+// Package fcs decodes the messages of the FCS chat protocol used over
+// the fcsl websocket.
+//
+// A websocket message carries one or more frames:
+//
+//	[len] type from to arg1 arg2 [payload]
+//
+// Frames are either prefixed by their length or simply concatenated,
+// in the latter case the payload is expected to be a JSON document.
+package fcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const headerFields = 5
+
+var ErrBadFrame = errors.New("fcs: malformed frame")
+
+// Frame is a single protocol message, the payload is already unescaped.
+type Frame struct {
+	Type    FCType
+	From    int64
+	To      int64
+	Arg1    int64
+	Arg2    int64
+	Payload string
+}
+
+// ParseMessage splits a websocket message into frames. Frames of unknown
+// types are returned as is. On error the frames parsed so far are returned.
+func ParseMessage(raw string) (frames []Frame, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	if prefixed, ok := splitLengthPrefixed(raw); ok {
+		frames = prefixed
+		return
+	}
+	text, err := url.QueryUnescape(raw)
+	if err != nil {
+		return
+	}
+	return splitFrames(text)
+}
+
+// Encode returns the frame in the wire format with an escaped payload.
+func (f Frame) Encode() string {
+	header := fmt.Sprintf("%d %d %d %d %d", f.Type, f.From, f.To, f.Arg1, f.Arg2)
+	if f.Payload == "" {
+		return header
+	}
+	return header + " " + url.QueryEscape(f.Payload)
+}
+
+// IsJSON reports whether the payload is a JSON object or array.
+func (f Frame) IsJSON() bool {
+	return strings.HasPrefix(f.Payload, "{") || strings.HasPrefix(f.Payload, "[")
+}
+
+// Unmarshal decodes the JSON payload into v.
+func (f Frame) Unmarshal(v interface{}) error {
+	if !f.IsJSON() {
+		return ErrNoJSONPayload
+	}
+	return json.Unmarshal([]byte(f.Payload), v)
+}
+
+func splitLengthPrefixed(raw string) (frames []Frame, ok bool) {
+	rest := raw
+	for rest != "" {
+		length, n, found := readInt(rest)
+		if !found || n >= len(rest) || rest[n] != ' ' {
+			return nil, false
+		}
+		rest = rest[n+1:]
+		if length <= 0 || int(length) > len(rest) {
+			return nil, false
+		}
+		body := rest[:length]
+		frame, payload, found := parseHeader(body)
+		if !found {
+			return nil, false
+		}
+		if payload != "" {
+			unescaped, err := url.QueryUnescape(payload)
+			if err != nil {
+				unescaped = payload
+			}
+			frame.Payload = strings.TrimRight(unescaped, "\r\n")
+		}
+		frames = append(frames, frame)
+		rest = strings.TrimLeft(rest[length:], " \r\n\t")
+	}
+	return frames, len(frames) > 0
+}
+
+func splitFrames(text string) (frames []Frame, err error) {
+	for {
+		text = strings.TrimLeft(text, " \r\n\t")
+		if text == "" {
+			return
+		}
+		frame, rest, found := parseHeader(text)
+		if !found {
+			err = ErrBadFrame
+			return
+		}
+		if rest != "" {
+			end := -1
+			if rest[0] == '{' || rest[0] == '[' {
+				end = jsonEnd(rest)
+			}
+			if end < 0 {
+				end = strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+			}
+			frame.Payload = strings.TrimRight(rest[:end], "\r")
+			rest = rest[end:]
+		}
+		frames = append(frames, frame)
+		text = rest
+	}
+}
+
+// parseHeader reads the five numeric header fields. The rest is the
+// payload with the separator removed, the payload of a frame without one
+// is empty.
+func parseHeader(s string) (frame Frame, rest string, ok bool) {
+	var fields [headerFields]int64
+	rest = s
+	for i := 0; i < headerFields; i++ {
+		if i > 0 {
+			if rest == "" || rest[0] != ' ' {
+				return
+			}
+			rest = rest[1:]
+		}
+		value, n, found := readInt(rest)
+		if !found {
+			return
+		}
+		fields[i] = value
+		rest = rest[n:]
+	}
+	switch {
+	case rest == "":
+	case rest[0] == ' ':
+		rest = rest[1:]
+	case rest[0] == '\n' || rest[0] == '\r':
+		// the next frame follows
+	default:
+		return
+	}
+	frame = Frame{
+		Type: FCType(fields[0]),
+		From: fields[1],
+		To:   fields[2],
+		Arg1: fields[3],
+		Arg2: fields[4],
+	}
+	ok = true
+	return
+}
+
+func readInt(s string) (value int64, n int, ok bool) {
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		value = value*10 + int64(s[n]-'0')
+		n++
+	}
+	ok = n > 0
+	return
+}
+
+// jsonEnd returns the length of the JSON document at the start of s,
+// or -1 when the document is not terminated.
+func jsonEnd(s string) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package fcs
+
+import (
+	"net/url"
+	"testing"
+)
+
+type TestParseMessageCase struct {
+	name   string
+	raw    string
+	expect []Frame
+}
+
+var testModelJSON = `{"lv":4,"nm":"TestModel","sid":42,"uid":100500,"vs":0,"u":{"camserv":1544},"m":{"flags":1024}}`
+var testChatJSON = `{"lv":0,"nm":"Guest1","uid":0,"msg":"hi {there}"}`
+
+var testParseMessageCases = []TestParseMessageCase{
+	{"no payload", "0 0 0 0 0", []Frame{{Type: FCTYPE_NULL}}},
+	{"word payload", "10 0 5 77 0 Nobody", []Frame{
+		{Type: FCTYPE_USERNAMELOOKUP, To: 5, Arg1: 77, Payload: "Nobody"},
+	}},
+	{"escaped json", "20 0 5 0 0 " + url.QueryEscape(testModelJSON), []Frame{
+		{Type: FCTYPE_SESSIONSTATE, To: 5, Payload: testModelJSON},
+	}},
+	{"concatenated", "20 0 5 0 0 " + testModelJSON + "50 1 5 0 0 " + url.QueryEscape(testChatJSON), []Frame{
+		{Type: FCTYPE_SESSIONSTATE, To: 5, Payload: testModelJSON},
+		{Type: FCTYPE_CMESG, From: 1, To: 5, Payload: testChatJSON},
+	}},
+	{"new line separated", "46 0 0 120 0\n20 0 5 0 0 " + testModelJSON, []Frame{
+		{Type: FCTYPE_GUESTCOUNT, Arg1: 120},
+		{Type: FCTYPE_SESSIONSTATE, To: 5, Payload: testModelJSON},
+	}},
+	{"length prefixed", "18 10 0 5 77 0 Nobody16 1 0 5 0 0 Guest1", []Frame{
+		{Type: FCTYPE_USERNAMELOOKUP, To: 5, Arg1: 77, Payload: "Nobody"},
+		{Type: FCTYPE_LOGIN, To: 5, Payload: "Guest1"},
+	}},
+	{"unknown type", "123 1 2 3 4 whatever", []Frame{
+		{Type: FCType(123), From: 1, To: 2, Arg1: 3, Arg2: 4, Payload: "whatever"},
+	}},
+}
+
+func TestParseMessage(t *testing.T) {
+	for _, c := range testParseMessageCases {
+		got, err := ParseMessage(c.raw)
+		if err != nil {
+			t.Errorf("TestParseMessage(%s) error: %s", c.name, err)
+			continue
+		}
+		if len(got) != len(c.expect) {
+			t.Errorf("TestParseMessage(%s)\ngot:    %+v\nexpect: %+v", c.name, got, c.expect)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.expect[i] {
+				t.Errorf("TestParseMessage(%s) frame %d\ngot:    %+v\nexpect: %+v", c.name, i, got[i], c.expect[i])
+			}
+		}
+	}
+}
+
+func TestParseMessageError(t *testing.T) {
+	for _, raw := range []string{"hello", "20 0 5 {}", "20 0 5 0 0{}"} {
+		if _, err := ParseMessage(raw); err == nil {
+			t.Errorf("TestParseMessageError(%q) expect error", raw)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	frame := Frame{Type: FCTYPE_CMESG, From: 1, To: 5, Payload: testChatJSON}
+	got, err := ParseMessage(frame.Encode())
+	if err != nil || len(got) != 1 || got[0] != frame {
+		t.Errorf("TestEncode got: %+v, %v, expect: %+v", got, err, frame)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	frames, err := ParseMessage("20 0 5 0 0 " + testModelJSON + "10 0 5 77 0 Nobody")
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("TestDecode parse got: %+v, %v", frames, err)
+	}
+	payload, err := frames[0].Decode()
+	if err != nil {
+		t.Fatalf("TestDecode error: %s", err)
+	}
+	state, ok := payload.(*SessionState)
+	if !ok || state.Uid != 100500 || state.U.Camserv != 1544 || state.M.Flags != 1024 {
+		t.Errorf("TestDecode SESSIONSTATE got: %#v", payload)
+	}
+	payload, err = frames[1].Decode()
+	if notFound, ok := payload.(*UserNotFound); err != nil || !ok || notFound.Name != "Nobody" {
+		t.Errorf("TestDecode USERNAMELOOKUP got: %#v, %v", payload, err)
+	}
+	raw := Frame{Type: FCType(123), Payload: "whatever"}
+	if payload, _ = raw.Decode(); payload != RawPayload("whatever") {
+		t.Errorf("TestDecode unknown got: %#v", payload)
+	}
+}
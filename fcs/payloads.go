@@ -0,0 +1,156 @@
+package fcs
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var ErrNoJSONPayload = errors.New("fcs: payload is not JSON")
+
+// SessionState is the state of a user or a model, it is sent with
+// SESSIONSTATE, USERNAMELOOKUP and DETAILS frames.
+type SessionState struct {
+	Lv  int    `json:"lv"`
+	Nm  string `json:"nm"`
+	Pid int64  `json:"pid"`
+	Sid uint64 `json:"sid"`
+	Uid uint64 `json:"uid"`
+	Vs  uint64 `json:"vs"`
+	U   struct {
+		Age     int    `json:"age"`
+		Camserv int32  `json:"camserv"`
+		Country string `json:"country"`
+		Topic   string `json:"topic"`
+	} `json:"u"`
+	M struct {
+		Camscore float64 `json:"camscore"`
+		Flags    int32   `json:"flags"`
+		Rc       int64   `json:"rc"`
+		Topic    string  `json:"topic"`
+	} `json:"m"`
+}
+
+// UserNotFound is the answer to a USERNAMELOOKUP of an unknown name.
+type UserNotFound struct {
+	Name string
+}
+
+// LoginResult is the answer to a LOGIN, Name is the assigned user name.
+type LoginResult struct {
+	Result int64
+	Name   string
+}
+
+// ChatMessage is a room (CMESG) or a private (PMESG) message.
+type ChatMessage struct {
+	Lv  int    `json:"lv"`
+	Nm  string `json:"nm"`
+	Sid uint64 `json:"sid"`
+	Uid uint64 `json:"uid"`
+	Vs  uint64 `json:"vs"`
+	Msg string `json:"msg"`
+}
+
+// Tip is a TOKENINC message. U and M hold [uid, sid, name] of the
+// sender and of the model.
+type Tip struct {
+	Ch       uint64        `json:"ch"`
+	Flags    int64         `json:"flags"`
+	M        []interface{} `json:"m"`
+	U        []interface{} `json:"u"`
+	Msg      string        `json:"msg"`
+	Sesstype int           `json:"sesstype"`
+	Stamp    int64         `json:"stamp"`
+	Tokens   int64         `json:"tokens"`
+}
+
+// Tags maps an uid to the tags of the model.
+type Tags map[uint64][]string
+
+// ExtData points to a payload which has to be downloaded separately.
+type ExtData struct {
+	Msg struct {
+		Arg1 int64  `json:"arg1"`
+		Arg2 int64  `json:"arg2"`
+		From int64  `json:"from"`
+		Len  int64  `json:"len"`
+		To   int64  `json:"to"`
+		Type FCType `json:"type"`
+	} `json:"msg"`
+	Respkey int64  `json:"respkey"`
+	Opts    int64  `json:"opts"`
+	Serv    int64  `json:"serv"`
+	Type    FCType `json:"type"`
+}
+
+// RawPayload is the payload of a frame without a typed decoder.
+type RawPayload string
+
+// Decode returns the typed payload of the frame:
+//
+//	SESSIONSTATE, DETAILS   *SessionState
+//	USERNAMELOOKUP          *SessionState or *UserNotFound
+//	LOGIN                   *LoginResult
+//	CMESG, PMESG            *ChatMessage
+//	TOKENINC                *Tip
+//	TAGS                    Tags
+//	EXTDATA                 *ExtData
+//
+// Any other frame is returned as RawPayload.
+func (f Frame) Decode() (payload interface{}, err error) {
+	switch f.Type {
+	case FCTYPE_SESSIONSTATE, FCTYPE_DETAILS:
+		state := &SessionState{}
+		err = f.Unmarshal(state)
+		payload = state
+	case FCTYPE_USERNAMELOOKUP:
+		if !f.IsJSON() {
+			payload = &UserNotFound{Name: f.Payload}
+			return
+		}
+		state := &SessionState{}
+		err = f.Unmarshal(state)
+		payload = state
+	case FCTYPE_LOGIN:
+		payload = &LoginResult{Result: f.Arg1, Name: f.Payload}
+	case FCTYPE_CMESG, FCTYPE_PMESG:
+		msg := &ChatMessage{}
+		err = f.Unmarshal(msg)
+		payload = msg
+	case FCTYPE_TOKENINC:
+		tip := &Tip{}
+		err = f.Unmarshal(tip)
+		payload = tip
+	case FCTYPE_TAGS:
+		payload, err = decodeTags(f)
+	case FCTYPE_EXTDATA:
+		ext := &ExtData{}
+		err = f.Unmarshal(ext)
+		payload = ext
+	default:
+		payload = RawPayload(f.Payload)
+	}
+	return
+}
+
+func decodeTags(f Frame) (tags Tags, err error) {
+	raw := make(map[string][]string)
+	if err = f.Unmarshal(&raw); err != nil {
+		return
+	}
+	tags = make(Tags, len(raw))
+	for key, values := range raw {
+		uid, parseErr := strconv.ParseUint(key, 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		tags[uid] = values
+	}
+	return
+}
+
+// Decode is a shortcut for json.Unmarshal of a raw payload.
+func (p RawPayload) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(p), v)
+}
@@ -0,0 +1,199 @@
+package fcs
+
+import "strconv"
+
+// FCType is the type of a FCS protocol message.
+type FCType int
+
+const (
+	FCTYPE_NULL           FCType = 0
+	FCTYPE_LOGIN          FCType = 1
+	FCTYPE_ADDFRIEND      FCType = 2
+	FCTYPE_PMESG          FCType = 3
+	FCTYPE_STATUS         FCType = 4
+	FCTYPE_DETAILS        FCType = 5
+	FCTYPE_TOKENINC       FCType = 6
+	FCTYPE_ADDIGNORE      FCType = 7
+	FCTYPE_PRIVACY        FCType = 8
+	FCTYPE_ADDFRIENDREQ   FCType = 9
+	FCTYPE_USERNAMELOOKUP FCType = 10
+	FCTYPE_ZBAN           FCType = 11
+	FCTYPE_BROADCASTNEWS  FCType = 12
+	FCTYPE_ANNOUNCE       FCType = 13
+	FCTYPE_MANAGELIST     FCType = 14
+	FCTYPE_INBOX          FCType = 15
+	FCTYPE_GWCONNECT      FCType = 16
+	FCTYPE_RELOADSETTINGS FCType = 17
+	FCTYPE_HIDEUSERS      FCType = 18
+	FCTYPE_RULEVIOLATION  FCType = 19
+	FCTYPE_SESSIONSTATE   FCType = 20
+	FCTYPE_REQUESTPVT     FCType = 21
+	FCTYPE_ACCEPTPVT      FCType = 22
+	FCTYPE_REJECTPVT      FCType = 23
+	FCTYPE_ENDSESSION     FCType = 24
+	FCTYPE_TXPROFILE      FCType = 25
+	FCTYPE_STARTVOYEUR    FCType = 26
+	FCTYPE_SERVERREFRESH  FCType = 27
+	FCTYPE_SETTING        FCType = 28
+	FCTYPE_BWSTATS        FCType = 29
+	FCTYPE_TKX            FCType = 30
+	FCTYPE_SETTEXTOPT     FCType = 31
+	FCTYPE_SERVERCONFIG   FCType = 32
+	FCTYPE_MODELGROUP     FCType = 33
+	FCTYPE_REQUESTGRP     FCType = 34
+	FCTYPE_STATUSGRP      FCType = 35
+	FCTYPE_GROUPCHAT      FCType = 36
+	FCTYPE_CLOSEGRP       FCType = 37
+	FCTYPE_UCR            FCType = 38
+	FCTYPE_MYUCR          FCType = 39
+	FCTYPE_SLAVECON       FCType = 40
+	FCTYPE_SLAVECMD       FCType = 41
+	FCTYPE_SLAVEFRIEND    FCType = 42
+	FCTYPE_SLAVEVSHARE    FCType = 43
+	FCTYPE_ROOMDATA       FCType = 44
+	FCTYPE_NEWSITEM       FCType = 45
+	FCTYPE_GUESTCOUNT     FCType = 46
+	FCTYPE_PRELOGINQ      FCType = 47
+	FCTYPE_MODELGROUPSZ   FCType = 48
+	FCTYPE_ROOMHELPER     FCType = 49
+	FCTYPE_CMESG          FCType = 50
+	FCTYPE_JOINCHAN       FCType = 51
+	FCTYPE_CREATECHAN     FCType = 52
+	FCTYPE_INVITECHAN     FCType = 53
+	FCTYPE_KICKCHAN       FCType = 54
+	FCTYPE_QUIETCHAN      FCType = 55
+	FCTYPE_BANCHAN        FCType = 56
+	FCTYPE_PREVIEWCHAN    FCType = 57
+	FCTYPE_SHUTDOWN       FCType = 58
+	FCTYPE_LISTBANS       FCType = 59
+	FCTYPE_UNBAN          FCType = 60
+	FCTYPE_SETWELCOME     FCType = 61
+	FCTYPE_CHANOP         FCType = 62
+	FCTYPE_LISTCHAN       FCType = 63
+	FCTYPE_TAGS           FCType = 64
+	FCTYPE_SETPCODE       FCType = 65
+	FCTYPE_SETMINTIP      FCType = 66
+	FCTYPE_UEOPT          FCType = 67
+	FCTYPE_HDVIDEO        FCType = 68
+	FCTYPE_METRICS        FCType = 69
+	FCTYPE_OFFERCAM       FCType = 70
+	FCTYPE_REQUESTCAM     FCType = 71
+	FCTYPE_MYWEBCAM       FCType = 72
+	FCTYPE_MYCAMSTATE     FCType = 73
+	FCTYPE_PMHISTORY      FCType = 74
+	FCTYPE_CHATFLASH      FCType = 75
+	FCTYPE_TRUEPVT        FCType = 76
+	FCTYPE_BOOKMARKS      FCType = 77
+	FCTYPE_EVENT          FCType = 78
+	FCTYPE_STATEDUMP      FCType = 79
+	FCTYPE_RECOMMEND      FCType = 80
+	FCTYPE_EXTDATA        FCType = 81
+	FCTYPE_ZGWINVALID     FCType = 95
+	FCTYPE_CONNECTING     FCType = 96
+	FCTYPE_CONNECTED      FCType = 97
+	FCTYPE_DISCONNECTED   FCType = 98
+	FCTYPE_LOGOUT         FCType = 99
+)
+
+var typeNames = map[FCType]string{
+	FCTYPE_NULL:           "NULL",
+	FCTYPE_LOGIN:          "LOGIN",
+	FCTYPE_ADDFRIEND:      "ADDFRIEND",
+	FCTYPE_PMESG:          "PMESG",
+	FCTYPE_STATUS:         "STATUS",
+	FCTYPE_DETAILS:        "DETAILS",
+	FCTYPE_TOKENINC:       "TOKENINC",
+	FCTYPE_ADDIGNORE:      "ADDIGNORE",
+	FCTYPE_PRIVACY:        "PRIVACY",
+	FCTYPE_ADDFRIENDREQ:   "ADDFRIENDREQ",
+	FCTYPE_USERNAMELOOKUP: "USERNAMELOOKUP",
+	FCTYPE_ZBAN:           "ZBAN",
+	FCTYPE_BROADCASTNEWS:  "BROADCASTNEWS",
+	FCTYPE_ANNOUNCE:       "ANNOUNCE",
+	FCTYPE_MANAGELIST:     "MANAGELIST",
+	FCTYPE_INBOX:          "INBOX",
+	FCTYPE_GWCONNECT:      "GWCONNECT",
+	FCTYPE_RELOADSETTINGS: "RELOADSETTINGS",
+	FCTYPE_HIDEUSERS:      "HIDEUSERS",
+	FCTYPE_RULEVIOLATION:  "RULEVIOLATION",
+	FCTYPE_SESSIONSTATE:   "SESSIONSTATE",
+	FCTYPE_REQUESTPVT:     "REQUESTPVT",
+	FCTYPE_ACCEPTPVT:      "ACCEPTPVT",
+	FCTYPE_REJECTPVT:      "REJECTPVT",
+	FCTYPE_ENDSESSION:     "ENDSESSION",
+	FCTYPE_TXPROFILE:      "TXPROFILE",
+	FCTYPE_STARTVOYEUR:    "STARTVOYEUR",
+	FCTYPE_SERVERREFRESH:  "SERVERREFRESH",
+	FCTYPE_SETTING:        "SETTING",
+	FCTYPE_BWSTATS:        "BWSTATS",
+	FCTYPE_TKX:            "TKX",
+	FCTYPE_SETTEXTOPT:     "SETTEXTOPT",
+	FCTYPE_SERVERCONFIG:   "SERVERCONFIG",
+	FCTYPE_MODELGROUP:     "MODELGROUP",
+	FCTYPE_REQUESTGRP:     "REQUESTGRP",
+	FCTYPE_STATUSGRP:      "STATUSGRP",
+	FCTYPE_GROUPCHAT:      "GROUPCHAT",
+	FCTYPE_CLOSEGRP:       "CLOSEGRP",
+	FCTYPE_UCR:            "UCR",
+	FCTYPE_MYUCR:          "MYUCR",
+	FCTYPE_SLAVECON:       "SLAVECON",
+	FCTYPE_SLAVECMD:       "SLAVECMD",
+	FCTYPE_SLAVEFRIEND:    "SLAVEFRIEND",
+	FCTYPE_SLAVEVSHARE:    "SLAVEVSHARE",
+	FCTYPE_ROOMDATA:       "ROOMDATA",
+	FCTYPE_NEWSITEM:       "NEWSITEM",
+	FCTYPE_GUESTCOUNT:     "GUESTCOUNT",
+	FCTYPE_PRELOGINQ:      "PRELOGINQ",
+	FCTYPE_MODELGROUPSZ:   "MODELGROUPSZ",
+	FCTYPE_ROOMHELPER:     "ROOMHELPER",
+	FCTYPE_CMESG:          "CMESG",
+	FCTYPE_JOINCHAN:       "JOINCHAN",
+	FCTYPE_CREATECHAN:     "CREATECHAN",
+	FCTYPE_INVITECHAN:     "INVITECHAN",
+	FCTYPE_KICKCHAN:       "KICKCHAN",
+	FCTYPE_QUIETCHAN:      "QUIETCHAN",
+	FCTYPE_BANCHAN:        "BANCHAN",
+	FCTYPE_PREVIEWCHAN:    "PREVIEWCHAN",
+	FCTYPE_SHUTDOWN:       "SHUTDOWN",
+	FCTYPE_LISTBANS:       "LISTBANS",
+	FCTYPE_UNBAN:          "UNBAN",
+	FCTYPE_SETWELCOME:     "SETWELCOME",
+	FCTYPE_CHANOP:         "CHANOP",
+	FCTYPE_LISTCHAN:       "LISTCHAN",
+	FCTYPE_TAGS:           "TAGS",
+	FCTYPE_SETPCODE:       "SETPCODE",
+	FCTYPE_SETMINTIP:      "SETMINTIP",
+	FCTYPE_UEOPT:          "UEOPT",
+	FCTYPE_HDVIDEO:        "HDVIDEO",
+	FCTYPE_METRICS:        "METRICS",
+	FCTYPE_OFFERCAM:       "OFFERCAM",
+	FCTYPE_REQUESTCAM:     "REQUESTCAM",
+	FCTYPE_MYWEBCAM:       "MYWEBCAM",
+	FCTYPE_MYCAMSTATE:     "MYCAMSTATE",
+	FCTYPE_PMHISTORY:      "PMHISTORY",
+	FCTYPE_CHATFLASH:      "CHATFLASH",
+	FCTYPE_TRUEPVT:        "TRUEPVT",
+	FCTYPE_BOOKMARKS:      "BOOKMARKS",
+	FCTYPE_EVENT:          "EVENT",
+	FCTYPE_STATEDUMP:      "STATEDUMP",
+	FCTYPE_RECOMMEND:      "RECOMMEND",
+	FCTYPE_EXTDATA:        "EXTDATA",
+	FCTYPE_ZGWINVALID:     "ZGWINVALID",
+	FCTYPE_CONNECTING:     "CONNECTING",
+	FCTYPE_CONNECTED:      "CONNECTED",
+	FCTYPE_DISCONNECTED:   "DISCONNECTED",
+	FCTYPE_LOGOUT:         "LOGOUT",
+}
+
+func (t FCType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Known reports whether the type is one of the named protocol types.
+func (t FCType) Known() bool {
+	_, ok := typeNames[t]
+	return ok
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gomfc/container/flvfix"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] input.flv [output]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(flag.CommandLine.Output(), "The output is input_fixed.flv by default, an .mp4 output remuxes the recording.")
+	flag.PrintDefaults()
+}
+
+// outputPath returns the default output path near the input.
+func outputPath(input string, mp4 bool) string {
+	ext := ".flv"
+	if mp4 {
+		ext = ".mp4"
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + "_fixed" + ext
+}
+
+func main() {
+	maxGap := flag.Duration("max-gap", flvfix.DefaultMaxGap, "forward timestamp jumps above it are rebased")
+	maxBack := flag.Duration("max-back", flvfix.DefaultMaxBack, "backward timestamp steps above it are rebased")
+	mp4 := flag.Bool("mp4", false, "remux to mp4 when the output is not given")
+	verbose := flag.Bool("v", false, "print every timestamp correction")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || len(args) > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := args[0]
+	output := outputPath(input, *mp4)
+	if len(args) == 2 {
+		output = args[1]
+	}
+	if absIn, err := filepath.Abs(input); err == nil {
+		if absOut, err := filepath.Abs(output); err == nil && absIn == absOut {
+			fmt.Println("Error: the output is the input file")
+			os.Exit(-1)
+		}
+	}
+	report, err := flvfix.RepairFile(input, output, flvfix.Options{
+		MaxGap:  *maxGap,
+		MaxBack: *maxBack,
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(-1)
+	}
+	if *verbose {
+		for _, c := range report.Corrections {
+			fmt.Printf("tag %d: timestamp %d moved by %d ms\n", c.Tag, c.Timestamp, c.Shift)
+		}
+	}
+	fmt.Printf("%s -> %s\n%s\n", input, output, report)
+}
@@ -3,6 +3,8 @@
 package gortmp
 
 import (
+	"bytes"
+	"errors"
 	"github.com/zhangpeihao/goamf"
 	"github.com/zhangpeihao/log"
 )
@@ -20,6 +22,48 @@ type Command struct {
 	Objects       []interface{}
 }
 
+var ErrNotCommand = errors.New("Not a command or data message")
+
+// Decode a command (COMMAND_AMF0, COMMAND_AMF3) or data (DATA_AMF0,
+// DATA_AMF3) message. Data messages have no transaction ID, it is zero.
+// The message buffer is not consumed, so the message may be passed on.
+func ReadCommand(message *Message) (cmd *Command, err error) {
+	buf := bytes.NewReader(message.Buf.Bytes())
+	cmd = &Command{}
+	switch message.Type {
+	case COMMAND_AMF3, DATA_AMF3:
+		// AMF3 messages start with a format byte, the values are AMF0
+		cmd.IsFlex = true
+		if _, err = buf.ReadByte(); err != nil {
+			return nil, err
+		}
+	case COMMAND_AMF0, DATA_AMF0:
+	default:
+		return nil, ErrNotCommand
+	}
+	cmd.Name, err = amf.ReadString(buf)
+	if err != nil {
+		return nil, err
+	}
+	if message.Type == COMMAND_AMF0 || message.Type == COMMAND_AMF3 {
+		var transactionID float64
+		transactionID, err = amf.ReadDouble(buf)
+		if err != nil {
+			return nil, err
+		}
+		cmd.TransactionID = uint32(transactionID)
+	}
+	var object interface{}
+	for buf.Len() > 0 {
+		object, err = amf.ReadValue(buf)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Objects = append(cmd.Objects, object)
+	}
+	return
+}
+
 func (cmd *Command) Write(w Writer) (err error) {
 	if cmd.IsFlex {
 		err = w.WriteByte(0x00)
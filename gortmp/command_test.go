@@ -0,0 +1,64 @@
+package gortmp
+
+import (
+	"bytes"
+	"github.com/zhangpeihao/goamf"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	buf := new(bytes.Buffer)
+	cmd := &Command{
+		Name:          "loginResult",
+		TransactionID: 3,
+		Objects:       []interface{}{nil, "(function(){return 1})()"},
+	}
+	if err := cmd.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, msgType := range []uint8{COMMAND_AMF0, COMMAND_AMF3} {
+		data := buf.Bytes()
+		if msgType == COMMAND_AMF3 {
+			data = append([]byte{0}, data...)
+		}
+		message := NewMessage(CS_ID_COMMAND, msgType, 0, 0, data)
+		got, err := ReadCommand(message)
+		if err != nil {
+			t.Fatalf("type %d: %s", msgType, err)
+		}
+		if got.Name != cmd.Name || got.TransactionID != cmd.TransactionID || len(got.Objects) != 2 {
+			t.Errorf("type %d got: %+v, expect: %+v", msgType, got, cmd)
+		}
+		if got.IsFlex != (msgType == COMMAND_AMF3) {
+			t.Errorf("type %d IsFlex: %t", msgType, got.IsFlex)
+		}
+		if message.Buf.Len() != len(data) {
+			t.Errorf("type %d message buffer is consumed", msgType)
+		}
+	}
+}
+
+func TestReadCommandData(t *testing.T) {
+	buf := new(bytes.Buffer)
+	amf.WriteString(buf, "onMetaData")
+	amf.WriteValue(buf, amf.Object{"width": float64(1280)})
+	got, err := ReadCommand(NewMessage(CS_ID_COMMAND, DATA_AMF0, 1, 0, buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "onMetaData" || got.TransactionID != 0 || len(got.Objects) != 1 {
+		t.Fatalf("got: %+v", got)
+	}
+	meta, ok := got.Objects[0].(amf.Object)
+	if !ok || meta["width"] != float64(1280) {
+		t.Errorf("metadata got: %+v", got.Objects[0])
+	}
+}
+
+func TestReadCommandMedia(t *testing.T) {
+	// media payloads are never decoded, whatever they contain
+	data := append([]byte{0x17, 0x01, 0, 0, 0, 0x02, 0, 11}, "loginResult"...)
+	if _, err := ReadCommand(NewMessage(6, VIDEO_TYPE, 1, 0, data)); err != ErrNotCommand {
+		t.Errorf("error got: %v, expect: %v", err, ErrNotCommand)
+	}
+}
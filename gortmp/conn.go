@@ -7,14 +7,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"github.com/zhangpeihao/goamf"
 	"github.com/zhangpeihao/log"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
-	"strings"
 )
 
 // Conns
@@ -35,6 +33,7 @@ type Conn interface {
 	SetPeerBandwidth(peerBandwidth uint32, limitType byte)
 	SetChunkSize(chunkSize uint32)
 	SendUserControlMessage(eventId uint16)
+	Ping()
 }
 
 // Connection handler
@@ -81,6 +80,9 @@ type conn struct {
 	inBytes  uint32
 	outBytes uint32
 
+	// Creation time, the timestamps of the ping requests are relative to it
+	created time.Time
+
 	// Previous window acknowledgement inbytes
 	inBytesPreWindow uint32
 
@@ -139,6 +141,7 @@ func NewConn(c net.Conn, br *bufio.Reader, bw *bufio.Writer, handler ConnHandler
 		outBandwidthLimit:           BINDWIDTH_LIMIT_DYNAMIC,
 		handler:                     handler,
 		mediaChunkStreamIDAllocator: make([]bool, maxChannelNumber),
+		created:                     time.Now(),
 	}
 	// Create "Protocol control chunk stream"
 	conn.outChunkStreams[CS_ID_PROTOCOL_CONTROL] = NewOutboundChunkStream(CS_ID_PROTOCOL_CONTROL)
@@ -163,11 +166,12 @@ func (conn *conn) sendMessage(message *Message) {
 
 	//	message.Dump(">>>")
 	header := chunkStream.NewOutboundHeader(message)
-	_, err := header.Write(conn.bw)
+	n, err := header.Write(conn.bw)
 	if err != nil {
 		conn.error(err, "sendMessage write header")
 		return
 	}
+	sent := n + int(header.MessageLength)
 	//	header.Dump(">>>")
 	if header.MessageLength > conn.outChunkSize {
 		//		chunkStream.lastHeader = nil
@@ -185,6 +189,7 @@ func (conn *conn) sendMessage(message *Message) {
 				conn.error(err, "sendMessage Type 3 chunk header")
 				return
 			}
+			sent++
 			if remain > conn.outChunkSize {
 				_, err = CopyNToNetwork(conn.bw, message.Buf, int64(conn.outChunkSize))
 				if err != nil {
@@ -213,12 +218,15 @@ func (conn *conn) sendMessage(message *Message) {
 		conn.error(err, "sendMessage Flush 3")
 		return
 	}
+	conn.outBytes += uint32(sent)
+	observer.BytesSent(sent)
 	if message.ChunkStreamID == CS_ID_PROTOCOL_CONTROL &&
 		message.Type == SET_CHUNK_SIZE &&
 		conn.outChunkSizeTemp != 0 {
 		// Set chunk size
 		conn.outChunkSize = conn.outChunkSizeTemp
 		conn.outChunkSizeTemp = 0
+		observer.ChunkSizeChanged(false, conn.outChunkSize)
 	}
 }
 
@@ -282,6 +290,7 @@ func (conn *conn) readLoop() {
 		n, vfmt, csi, err := ReadBaseHeader(conn.br)
 		CheckError(err, "ReadBaseHeader")
 		conn.inBytes += uint32(n)
+		observer.BytesReceived(n)
 		// Get chunk stream
 		chunkstream, found = conn.inChunkStreams[csi]
 		if !found || chunkstream == nil {
@@ -297,6 +306,7 @@ func (conn *conn) readLoop() {
 			logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE, "New stream 2 csi: %d, fmt: %d, header: %+v\n", csi, vfmt, header)
 		}
 		conn.inBytes += uint32(n)
+		observer.BytesReceived(n)
 		var absoluteTimestamp uint32
 		var message *Message
 		switch vfmt {
@@ -368,6 +378,7 @@ func (conn *conn) readLoop() {
 				n64, err = io.CopyN(message.Buf, conn.br, int64(remain))
 				if err == nil {
 					conn.inBytes += uint32(n64)
+					observer.BytesReceived(int(n64))
 					if remain <= uint32(n64) {
 						break
 					} else {
@@ -398,6 +409,7 @@ func (conn *conn) readLoop() {
 				n64, err = io.CopyN(message.Buf, conn.br, int64(remain))
 				if err == nil {
 					conn.inBytes += uint32(n64)
+					observer.BytesReceived(int(n64))
 					if remain <= uint32(n64) {
 						break
 					} else {
@@ -426,6 +438,7 @@ func (conn *conn) readLoop() {
 			CheckError(err, "ACK Message write data")
 			conn.inBytesPreWindow = conn.inBytes
 			conn.Send(ackmessage)
+			observer.Acknowledgement(false)
 		}
 	}
 }
@@ -643,50 +656,14 @@ func (conn *conn) received(message *Message) {
 			}
 		case CS_ID_COMMAND:
 			if message.StreamID == 0 {
-				cmd := &Command{}
-				var err error
-				var transactionID float64
-				var object interface{}
-				switch message.Type {
-				case COMMAND_AMF3:
-					cmd.IsFlex = true
-					_, err = message.Buf.ReadByte()
-					if err != nil {
-						logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-							"Read first in flex commad err:", err)
-						return
-					}
-					fallthrough
-				case COMMAND_AMF0:
-					if strings.Contains(message.Buf.String(), "loginResult") {
-						conn.handler.OnReceived(conn, message)
-						return
-					}
-					cmd.Name, err = amf.ReadString(message.Buf)
-					if err != nil {
-						logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-							"AMF0 Read name err:", err)
-						return
-					}
-					transactionID, err = amf.ReadDouble(message.Buf)
-					if err != nil {
-						logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-							"AMF0 Read transactionID err:", err)
-						return
-					}
-					cmd.TransactionID = uint32(transactionID)
-					for message.Buf.Len() > 0 {
-						object, err = amf.ReadValue(message.Buf)
-						if err != nil {
-							logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-								"AMF0 Read object err:", err)
-							return
-						}
-						cmd.Objects = append(cmd.Objects, object)
+				cmd, err := ReadCommand(message)
+				if err != nil {
+					if err != ErrNotCommand {
+						logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
+							"Read command (type %d) err: %s\n", message.Type, err)
 					}
-				default:
-					logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE,
-						"Unkown message type %d in Command chunk stream!\n", message.Type)
+					conn.handler.OnReceived(conn, message)
+					return
 				}
 				conn.invokeCommand(cmd)
 			} else {
@@ -702,7 +679,9 @@ func (conn *conn) invokeSetChunkSize(message *Message) {
 	if err := binary.Read(message.Buf, binary.BigEndian, &conn.inChunkSize); err != nil {
 		logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
 			"conn::invokeSetChunkSize err:", err)
+		return
 	}
+	observer.ChunkSizeChanged(true, conn.inChunkSize)
 	logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE,
 		"conn::invokeSetChunkSize() conn.inChunkSize = %d\n", conn.inChunkSize)
 }
@@ -715,6 +694,7 @@ func (conn *conn) invokeAbortMessage(message *Message) {
 func (conn *conn) invokeAcknowledgement(message *Message) {
 	logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE,
 		"conn::invokeAcknowledgement(): % 2x\n", message.Buf.Bytes())
+	observer.Acknowledgement(true)
 }
 
 // User Control Message
@@ -837,6 +817,16 @@ func (conn *conn) invokeUserControlMessage(message *Message) {
 		conn.Send(respmessage)
 	case EVENT_PING_RESPONSE:
 		logger.ModulePrintln(logHandler, log.LOG_LEVEL_TRACE, "conn::invokeUserControlMessage() EVENT_PING_RESPONSE")
+		var timestamp uint32
+		if err = binary.Read(message.Buf, binary.BigEndian, &timestamp); err != nil {
+			logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
+				"conn::invokeUserControlMessage() read ping timestamp err: %s\n", err.Error())
+			return
+		}
+		rtt := time.Since(conn.created) - time.Duration(timestamp)*time.Millisecond
+		if rtt >= 0 {
+			observer.PingRoundTrip(rtt)
+		}
 	case EVENT_REQUEST_VERIFY:
 		logger.ModulePrintln(logHandler, log.LOG_LEVEL_TRACE, "conn::invokeUserControlMessage() EVENT_REQUEST_VERIFY")
 	case EVENT_RESPOND_VERIFY:
@@ -957,6 +947,27 @@ func (conn *conn) SetPeerBandwidth(peerBandwidth uint32, limitType byte) {
 	conn.Send(message)
 }
 
+// Send a ping request, the round trip of the response is passed to
+// the observer.
+func (conn *conn) Ping() {
+	logger.ModulePrintln(logHandler, log.LOG_LEVEL_TRACE,
+		"conn::Ping")
+	message := NewMessage(CS_ID_PROTOCOL_CONTROL, USER_CONTROL_MESSAGE, 0, 0, nil)
+	eventType := uint16(EVENT_PING_REQUEST)
+	if err := binary.Write(message.Buf, binary.BigEndian, &eventType); err != nil {
+		logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
+			"conn::Ping write event type err:", err)
+		return
+	}
+	timestamp := uint32(time.Since(conn.created) / time.Millisecond)
+	if err := binary.Write(message.Buf, binary.BigEndian, &timestamp); err != nil {
+		logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
+			"conn::Ping write timestamp err:", err)
+		return
+	}
+	conn.Send(message)
+}
+
 func (conn *conn) SendUserControlMessage(eventId uint16) {
 	logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE,
 		"conn::SendUserControlMessage")
@@ -87,42 +87,13 @@ func (stream *inboundStream) Received(message *Message) bool {
 	if message.Type == VIDEO_TYPE || message.Type == AUDIO_TYPE {
 		return false
 	}
-	var err error
 	if message.Type == COMMAND_AMF0 || message.Type == COMMAND_AMF3 {
-		cmd := &Command{}
-		if message.Type == COMMAND_AMF3 {
-			cmd.IsFlex = true
-			_, err = message.Buf.ReadByte()
-			if err != nil {
-				logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
-					"inboundStream::Received() Read first in flex commad err:", err)
-				return true
-			}
-		}
-		cmd.Name, err = amf.ReadString(message.Buf)
+		cmd, err := ReadCommand(message)
 		if err != nil {
 			logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
-				"inboundStream::Received() AMF0 Read name err:", err)
+				"inboundStream::Received() Read command err: %s\n", err)
 			return true
 		}
-		var transactionID float64
-		transactionID, err = amf.ReadDouble(message.Buf)
-		if err != nil {
-			logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
-				"inboundStream::Received() AMF0 Read transactionID err:", err)
-			return true
-		}
-		cmd.TransactionID = uint32(transactionID)
-		var object interface{}
-		for message.Buf.Len() > 0 {
-			object, err = amf.ReadValue(message.Buf)
-			if err != nil {
-				logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
-					"inboundStream::Received() AMF0 Read object err:", err)
-				return true
-			}
-			cmd.Objects = append(cmd.Objects, object)
-		}
 
 		switch cmd.Name {
 		case "play":
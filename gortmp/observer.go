@@ -0,0 +1,40 @@
+package gortmp
+
+import (
+	"time"
+)
+
+// Observer receives the protocol events of all connections, it is used
+// to collect metrics. The methods are called from the connection
+// goroutines and must not block.
+type Observer interface {
+	// Bytes read from the network
+	BytesReceived(n int)
+	// Bytes written to the network
+	BytesSent(n int)
+	// Chunk size set by the peer (inbound) or by us
+	ChunkSizeChanged(inbound bool, size uint32)
+	// Window acknowledgement received from the peer (inbound) or sent
+	Acknowledgement(inbound bool)
+	// Round trip of a ping request sent by Ping
+	PingRoundTrip(rtt time.Duration)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) BytesReceived(n int)                        {}
+func (nopObserver) BytesSent(n int)                            {}
+func (nopObserver) ChunkSizeChanged(inbound bool, size uint32) {}
+func (nopObserver) Acknowledgement(inbound bool)               {}
+func (nopObserver) PingRoundTrip(rtt time.Duration)            {}
+
+var observer Observer = nopObserver{}
+
+// SetObserver sets the observer of the connections, it should be called
+// before the first connection is created. A nil observer drops the events.
+func SetObserver(o Observer) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	observer = o
+}
@@ -5,6 +5,7 @@ package gortmp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -69,16 +70,24 @@ type outboundConn struct {
 
 // Connect to FMS server, and finish handshake process
 func Dial(url string, handler OutboundConnHandler, maxChannelNumber int) (OutboundConn, error) {
+	return DialContext(context.Background(), url, handler, maxChannelNumber)
+}
+
+// Connect to FMS server, and finish handshake process.
+// Cancelling the context aborts the dial and the handshake.
+func DialContext(ctx context.Context, url string, handler OutboundConnHandler, maxChannelNumber int) (OutboundConn, error) {
 	rtmpURL, err := ParseURL(url)
 	if err != nil {
 		return nil, err
 	}
 	var c net.Conn
+	dialer := &net.Dialer{}
 	switch rtmpURL.protocol {
 	case "rtmp":
-		c, err = net.Dial("tcp", fmt.Sprintf("%s:%d", rtmpURL.host, rtmpURL.port))
+		c, err = dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", rtmpURL.host, rtmpURL.port))
 	case "rtmps":
-		c, err = tls.Dial("tcp", fmt.Sprintf("%s:%d", rtmpURL.host, rtmpURL.port), &tls.Config{InsecureSkipVerify: true})
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{InsecureSkipVerify: true}}
+		c, err = tlsDialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", rtmpURL.host, rtmpURL.port))
 	default:
 		err = errors.New(fmt.Sprintf("Unsupport protocol %s", rtmpURL.protocol))
 	}
@@ -93,8 +102,21 @@ func Dial(url string, handler OutboundConnHandler, maxChannelNumber int) (Outbou
 	br := bufio.NewReader(c)
 	bw := bufio.NewWriter(c)
 	timeout := time.Duration(10*time.Second)
+	handshakeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-handshakeDone:
+		}
+	}()
 	err = Handshake(c, br, bw, timeout)
 	//err = HandshakeSample(c, br, bw, timeout)
+	close(handshakeDone)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		c.Close()
+		return nil, ctxErr
+	}
 	if err == nil {
 		logger.ModulePrintln(logHandler, log.LOG_LEVEL_DEBUG, "Handshake OK")
 
@@ -19,7 +19,7 @@ type OutboundStreamHandler interface {
 // messages.
 type outboundStream struct {
 	id            uint32
-	conn          OutboundConn
+	conn          *outboundConn
 	chunkStreamID uint32
 	handler       OutboundStreamHandler
 	bufferLength  uint32
@@ -235,55 +235,32 @@ func (stream *outboundStream) Received(message *Message) bool {
 	if message.Type == VIDEO_TYPE || message.Type == AUDIO_TYPE {
 		return false
 	}
-	var err error
-	if message.Type == COMMAND_AMF0 || message.Type == COMMAND_AMF3 {
-		cmd := &Command{}
-		if message.Type == COMMAND_AMF3 {
-			cmd.IsFlex = true
-			_, err = message.Buf.ReadByte()
-			if err != nil {
-				logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-					"outboundStream::Received() Read first in flex commad err:", err)
-				return true
-			}
-		}
-		cmd.Name, err = amf.ReadString(message.Buf)
-		if err != nil {
-			logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-				"outboundStream::Received() AMF0 Read name err:", err)
-			return true
-		}
-		var transactionID float64
-		transactionID, err = amf.ReadDouble(message.Buf)
-		if err != nil {
+	cmd, err := ReadCommand(message)
+	if err != nil {
+		if err != ErrNotCommand {
 			logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-				"outboundStream::Received() AMF0 Read transactionID err:", err)
-			return true
-		}
-		cmd.TransactionID = uint32(transactionID)
-		var object interface{}
-		for message.Buf.Len() > 0 {
-			object, err = amf.ReadValue(message.Buf)
-			if err != nil {
-				logger.ModulePrintln(logHandler, log.LOG_LEVEL_WARNING,
-					"outboundStream::Received() AMF0 Read object err:", err)
-				return true
-			}
-			cmd.Objects = append(cmd.Objects, object)
+				"outboundStream::Received() Read command err:", err)
 		}
+		return false
+	}
+	// Data messages, e.g. onMetaData, are passed to the handler twice:
+	// decoded and as is, so they can be recorded.
+	handled := message.Type == COMMAND_AMF0 || message.Type == COMMAND_AMF3
+	if handled {
 		switch cmd.Name {
 		case "onStatus":
-			return stream.onStatus(cmd)
-		case "onMetaData":
-			return stream.onMetaData(cmd)
+			stream.onStatus(cmd)
 		case "onTimeCoordInfo":
-			return stream.onTimeCoordInfo(cmd)
-		default:
-			logger.ModulePrintf(logHandler, log.LOG_LEVEL_WARNING,
-				"outboundStream::Received() Unknown command: %s\n", cmd.Name)
+			stream.onTimeCoordInfo(cmd)
+		}
+	} else {
+		switch cmd.Name {
+		case "onMetaData":
+			stream.onMetaData(cmd)
 		}
 	}
-	return false
+	stream.conn.handler.OnReceivedRtmpCommand(stream.conn.conn, cmd)
+	return handled
 }
 
 func (stream *outboundStream) onStatus(cmd *Command) bool {
@@ -316,10 +293,12 @@ func (stream *outboundStream) onStatus(cmd *Command) bool {
 }
 
 func (stream *outboundStream) onMetaData(cmd *Command) bool {
+	logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE, "onMetaData: %+v\n", cmd)
 	return false
 }
 
 func (stream *outboundStream) onTimeCoordInfo(cmd *Command) bool {
+	logger.ModulePrintf(logHandler, log.LOG_LEVEL_TRACE, "onTimeCoordInfo: %+v\n", cmd)
 	return false
 }
 
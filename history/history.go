@@ -0,0 +1,271 @@
+// Package history keeps the status transitions of models in a bbolt file
+// and answers questions about them: when a model was online, how long
+// the sessions are and at which hour they usually start.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"gomfc/models"
+)
+
+var (
+	transitionsBucket = []byte("transitions")
+	namesBucket       = []byte("names")
+)
+
+const (
+	openTimeout = 5 * time.Second
+	// readTimeout is short, the readers fall back to the snapshot
+	readTimeout = 500 * time.Millisecond
+)
+
+var ErrUnknownModel = errors.New("history: unknown model")
+
+// Transition is a status change of a model seen at Time.
+type Transition struct {
+	Uid     uint64    `json:"uid"`
+	Name    string    `json:"name"`
+	Vs      uint64    `json:"vs"`
+	Camserv int32     `json:"camserv"`
+	Flags   int32     `json:"flags"`
+	Time    time.Time `json:"time"`
+}
+
+// Online reports whether the model is in the public room.
+func (t Transition) Online() bool {
+	return t.Vs == models.IsOnline
+}
+
+// FromModel returns the transition to the current status of the model.
+func FromModel(m models.MFCModel, at time.Time) Transition {
+	return Transition{
+		Uid:     m.Uid,
+		Name:    m.Nm,
+		Vs:      m.Vs,
+		Camserv: m.U.Camserv,
+		Flags:   m.M.Flags,
+		Time:    at,
+	}
+}
+
+// Store is the history file, it is safe for concurrent use. The store of
+// Open keeps the file locked until Close, the history is read meanwhile
+// from its snapshot, see WriteSnapshot.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the history file. The file is locked, a second
+// process opening it waits for a few seconds and fails.
+func Open(path string) (s *Store, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(transitionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(namesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// OpenReadOnly opens an existing history file or snapshot for the
+// queries, it fails shortly while the file is open for writing.
+func OpenReadOnly(path string) (s *Store, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: readTimeout, ReadOnly: true})
+	if err != nil {
+		return
+	}
+	return &Store{db: db}, nil
+}
+
+// IsLocked reports whether the error of Open or OpenReadOnly tells the
+// file is open in another process.
+func IsLocked(err error) bool {
+	return err == bolt.ErrTimeout
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SnapshotPath returns the path of the snapshot of the history file.
+func SnapshotPath(path string) string {
+	return path + ".snapshot"
+}
+
+// WriteSnapshot replaces the file at path with a consistent copy of the
+// history, the copy can be opened by OpenReadOnly while the history is
+// recorded.
+func (s *Store) WriteSnapshot(path string) error {
+	tmp := path + ".tmp"
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmp, 0600)
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Record stores the transition unless the status is the same as the
+// last stored one, so repeated updates of a model are kept once.
+func (s *Store) Record(t Transition) (recorded bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		recorded, err = s.record(tx, t)
+		return
+	})
+	return
+}
+
+// RecordAll stores the transitions in one transaction, see Record.
+func (s *Store) RecordAll(transitions []Transition) (recorded int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		recorded = 0
+		for _, t := range transitions {
+			ok, err := s.record(tx, t)
+			if err != nil {
+				return err
+			}
+			if ok {
+				recorded++
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Store) record(tx *bolt.Tx, t Transition) (recorded bool, err error) {
+	if t.Name != "" {
+		if err = tx.Bucket(namesBucket).Put([]byte(strings.ToLower(t.Name)), key64(t.Uid)); err != nil {
+			return
+		}
+	}
+	bucket, err := tx.Bucket(transitionsBucket).CreateBucketIfNotExists(key64(t.Uid))
+	if err != nil {
+		return
+	}
+	if _, value := bucket.Cursor().Last(); value != nil {
+		var last Transition
+		if json.Unmarshal(value, &last) == nil && last.Vs == t.Vs {
+			return
+		}
+	}
+	value, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	if err = bucket.Put(s.timeKey(bucket, t.Time), value); err != nil {
+		return
+	}
+	return true, nil
+}
+
+// timeKey returns a key sorted by time, transitions of the same
+// nanosecond get the next free key.
+func (s *Store) timeKey(bucket *bolt.Bucket, at time.Time) []byte {
+	nano := uint64(at.UnixNano())
+	for bucket.Get(key64(nano)) != nil {
+		nano++
+	}
+	return key64(nano)
+}
+
+// Lookup returns the uid of the model name.
+func (s *Store) Lookup(name string) (uid uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(namesBucket).Get([]byte(strings.ToLower(name)))
+		if value == nil {
+			return ErrUnknownModel
+		}
+		uid = binary.BigEndian.Uint64(value)
+		return nil
+	})
+	return
+}
+
+// Uids returns the uids with history.
+func (s *Store) Uids() (uids []uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(transitionsBucket).ForEach(func(key, value []byte) error {
+			uids = append(uids, binary.BigEndian.Uint64(key))
+			return nil
+		})
+	})
+	return
+}
+
+// Last returns the last stored transition of the model.
+func (s *Store) Last(uid uint64) (t Transition, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transitionsBucket).Bucket(key64(uid))
+		if bucket == nil {
+			return ErrUnknownModel
+		}
+		_, value := bucket.Cursor().Last()
+		return json.Unmarshal(value, &t)
+	})
+	return
+}
+
+// Transitions returns the transitions of the model in [from, to) with the
+// last transition before from, it is the status at from. Transitions are
+// sorted by time.
+func (s *Store) Transitions(uid uint64, from, to time.Time) (transitions []Transition, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transitionsBucket).Bucket(key64(uid))
+		if bucket == nil {
+			return ErrUnknownModel
+		}
+		c := bucket.Cursor()
+		fromKey := key64(uint64(from.UnixNano()))
+		toKey := key64(uint64(to.UnixNano()))
+		// the status at from
+		var prevKey, prevValue []byte
+		if key, _ := c.Seek(fromKey); key == nil {
+			prevKey, prevValue = c.Last()
+		} else {
+			prevKey, prevValue = c.Prev()
+		}
+		if prevKey != nil {
+			var t Transition
+			if err := json.Unmarshal(prevValue, &t); err != nil {
+				return err
+			}
+			transitions = append(transitions, t)
+		}
+		key, value := c.Seek(fromKey)
+		for ; key != nil && string(key) < string(toKey); key, value = c.Next() {
+			var t Transition
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			transitions = append(transitions, t)
+		}
+		return nil
+	})
+	return
+}
+
+func key64(value uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, value)
+	return key
+}
@@ -0,0 +1,181 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gomfc/models"
+)
+
+const testUid = 42
+
+var testStart = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func testStore(t *testing.T) *Store {
+	return testStoreAt(t, filepath.Join(t.TempDir(), "history.db"))
+}
+
+func testStoreAt(t *testing.T, path string) *Store {
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func record(t *testing.T, store *Store, vs uint64, at time.Duration) bool {
+	recorded, err := store.Record(Transition{Uid: testUid, Name: "Model", Vs: vs, Time: testStart.Add(at)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorded
+}
+
+func TestRecord(t *testing.T) {
+	store := testStore(t)
+	if !record(t, store, models.IsOnline, 0) {
+		t.Fatal("first transition is not recorded")
+	}
+	if record(t, store, models.IsOnline, time.Minute) {
+		t.Fatal("same status is recorded twice")
+	}
+	if !record(t, store, models.IsOff, time.Hour) {
+		t.Fatal("status change is not recorded")
+	}
+	uid, err := store.Lookup("MODEL")
+	if err != nil || uid != testUid {
+		t.Fatalf("lookup: %d, %v", uid, err)
+	}
+	if _, err := store.Lookup("other"); err != ErrUnknownModel {
+		t.Fatalf("lookup of unknown model: %v", err)
+	}
+	last, err := store.Last(testUid)
+	if err != nil || last.Vs != models.IsOff || !last.Time.Equal(testStart.Add(time.Hour)) {
+		t.Fatalf("last: %+v, %v", last, err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Fatal("read-only open of a missing file")
+	}
+	writer := testStoreAt(t, path)
+	record(t, writer, models.IsOnline, 0)
+	if _, err := OpenReadOnly(path); !IsLocked(err) {
+		t.Fatalf("read-only open while the writer is open: %v", err)
+	}
+	snapshot := SnapshotPath(path)
+	if err := writer.WriteSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenReadOnly(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if uid, err := reader.Lookup("model"); err != nil || uid != testUid {
+		t.Errorf("lookup: %d, %v", uid, err)
+	}
+	if _, err := reader.Record(Transition{Uid: testUid, Vs: models.IsOff}); err == nil {
+		t.Error("record into a read-only store")
+	}
+	if !record(t, writer, models.IsOff, time.Hour) {
+		t.Error("status change is not recorded while the snapshot is open")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store := testStoreAt(t, path)
+	queue := NewQueue(store, 10)
+	queue.Snapshot = SnapshotPath(path)
+	queue.OnError = func(err error) { t.Error(err) }
+	queue.Start()
+	for i, vs := range []uint64{models.IsOnline, models.IsOnline, models.IsOff} {
+		if err := queue.Add(Transition{Uid: testUid, Name: "Model", Vs: vs, Time: testStart.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue.Close()
+	if err := queue.Add(Transition{Uid: testUid}); err != ErrQueueClosed {
+		t.Errorf("add after close: %v", err)
+	}
+	reader, err := OpenReadOnly(queue.Snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	transitions, err := reader.Transitions(testUid, testStart, testStart.Add(time.Hour))
+	if err != nil || len(transitions) != 2 || transitions[1].Vs != models.IsOff {
+		t.Fatalf("transitions of the snapshot: %+v, %v", transitions, err)
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	store := testStore(t)
+	record(t, store, models.IsOnline, 0)
+	record(t, store, models.IsOff, 2*time.Hour)
+	record(t, store, models.IsOnline, 5*time.Hour)
+	record(t, store, models.IsOff, 6*time.Hour)
+	transitions, err := store.Transitions(testUid, testStart.Add(time.Hour), testStart.Add(6*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 3 {
+		t.Fatalf("got %d transitions, want 3", len(transitions))
+	}
+	if !transitions[0].Time.Equal(testStart) || !transitions[0].Online() {
+		t.Fatalf("status at from: %+v", transitions[0])
+	}
+	if _, err := store.Transitions(1, testStart, testStart.Add(time.Hour)); err != ErrUnknownModel {
+		t.Fatalf("unknown uid: %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store := testStore(t)
+	record(t, store, models.IsOnline, 0)
+	record(t, store, models.IsOff, 2*time.Hour)
+	record(t, store, models.IsOnline, 20*time.Hour)
+	record(t, store, models.IsAway, 21*time.Hour)
+	record(t, store, models.IsOnline, 44*time.Hour)
+	from, to := testStart.Add(time.Hour), testStart.Add(48*time.Hour)
+	sessions, err := store.Sessions(testUid, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Session{
+		{Start: from, End: testStart.Add(2 * time.Hour), Cut: true},
+		{Start: testStart.Add(20 * time.Hour), End: testStart.Add(21 * time.Hour)},
+		{Start: testStart.Add(44 * time.Hour), End: to, Open: true},
+	}
+	if len(sessions) != len(want) {
+		t.Fatalf("got %d sessions, want %d", len(sessions), len(want))
+	}
+	for i, session := range sessions {
+		if !session.Start.Equal(want[i].Start) || !session.End.Equal(want[i].End) ||
+			session.Cut != want[i].Cut || session.Open != want[i].Open {
+			t.Errorf("session %d: %+v, want %+v", i, session, want[i])
+		}
+	}
+	stats, err := store.Stats(testUid, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sessions != 3 || stats.Online != 6*time.Hour || stats.AverageSession != 2*time.Hour ||
+		stats.LongestSession != 4*time.Hour {
+		t.Fatalf("stats: %+v", stats)
+	}
+	if stats.StartHours[1] != 0 || stats.StartHours[20] != 2 || stats.TypicalStartHour != 20 {
+		t.Fatalf("start hours: %v, typical %d", stats.StartHours, stats.TypicalStartHour)
+	}
+}
+
+func TestStatsWithoutSessions(t *testing.T) {
+	if stats := statsOf(nil, time.UTC); stats.TypicalStartHour != -1 || stats.AverageSession != 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
@@ -0,0 +1,138 @@
+package history
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxBatch is the number of the queued transitions stored in one
+// transaction.
+const maxBatch = 1000
+
+// DefaultSnapshotInterval is the minimum time between the snapshots
+// written by a Queue.
+const DefaultSnapshotInterval = time.Minute
+
+var (
+	ErrQueueFull   = errors.New("history: the queue is full, the transition is dropped")
+	ErrQueueClosed = errors.New("history: the queue is closed")
+)
+
+// Queue stores the transitions in background, so the caller never waits
+// for the disk. The transitions queued meanwhile are stored in one
+// transaction. When Snapshot is set the snapshot of the store is written
+// there after the changes, at most every SnapshotInterval.
+type Queue struct {
+	Snapshot         string
+	SnapshotInterval time.Duration
+	// OnError is called with the errors of the store from the goroutine
+	// of the queue.
+	OnError func(err error)
+
+	store       *Store
+	transitions chan Transition
+	done        chan struct{}
+	mu          sync.Mutex
+	closed      bool
+}
+
+// NewQueue returns a queue of the store holding up to size transitions,
+// Start starts storing them.
+func NewQueue(store *Store, size int) *Queue {
+	return &Queue{
+		SnapshotInterval: DefaultSnapshotInterval,
+		store:            store,
+		transitions:      make(chan Transition, size),
+		done:             make(chan struct{}),
+	}
+}
+
+func (q *Queue) Start() {
+	go q.run()
+}
+
+// Add queues the transition, ErrQueueFull is returned when the queue is
+// full and ErrQueueClosed after Close.
+func (q *Queue) Add(t Transition) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.transitions <- t:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stores the queued transitions, writes the last snapshot and
+// returns.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.transitions)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	var ticker <-chan time.Time
+	if q.Snapshot != "" {
+		t := time.NewTicker(q.SnapshotInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+	changed := false
+	for {
+		select {
+		case t, ok := <-q.transitions:
+			if !ok {
+				if changed {
+					q.snapshot()
+				}
+				return
+			}
+			batch := []Transition{t}
+		Batch:
+			for len(batch) < maxBatch {
+				select {
+				case t, ok := <-q.transitions:
+					if !ok {
+						break Batch
+					}
+					batch = append(batch, t)
+				default:
+					break Batch
+				}
+			}
+			recorded, err := q.store.RecordAll(batch)
+			if err != nil {
+				q.error(err)
+			}
+			changed = changed || recorded > 0
+		case <-ticker:
+			if changed {
+				q.snapshot()
+				changed = false
+			}
+		}
+	}
+}
+
+func (q *Queue) snapshot() {
+	if err := q.store.WriteSnapshot(q.Snapshot); err != nil {
+		q.error(err)
+	}
+}
+
+func (q *Queue) error(err error) {
+	if q.OnError != nil {
+		q.OnError(err)
+	}
+}
@@ -0,0 +1,102 @@
+package history
+
+import (
+	"time"
+)
+
+// Session is an interval when the model was online. Sessions crossing
+// the bounds of the query are cut: Cut is set for a session started
+// before the query, Open for a session not finished at the end of it.
+type Session struct {
+	Start time.Time
+	End   time.Time
+	Cut   bool
+	Open  bool
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Stats summarizes the sessions of a model.
+type Stats struct {
+	Sessions       int
+	Online         time.Duration
+	AverageSession time.Duration
+	LongestSession time.Duration
+	// StartHours counts the sessions by the hour they started, in the
+	// location of the query. Cut sessions are not counted.
+	StartHours [24]int
+	// TypicalStartHour is the hour most sessions started at, -1 without
+	// sessions.
+	TypicalStartHour int
+}
+
+// Sessions returns the online sessions of the model in [from, to).
+func (s *Store) Sessions(uid uint64, from, to time.Time) (sessions []Session, err error) {
+	transitions, err := s.Transitions(uid, from, to)
+	if err != nil {
+		return
+	}
+	return sessionsOf(transitions, from, to), nil
+}
+
+func sessionsOf(transitions []Transition, from, to time.Time) (sessions []Session) {
+	var current *Session
+	for _, t := range transitions {
+		at := t.Time
+		cut := at.Before(from)
+		if cut {
+			at = from
+		}
+		switch {
+		case t.Online() && current == nil:
+			current = &Session{Start: at, Cut: cut}
+		case !t.Online() && current != nil:
+			current.End = at
+			sessions = append(sessions, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		current.End = to
+		current.Open = true
+		sessions = append(sessions, *current)
+	}
+	return
+}
+
+// Stats returns the statistics of the sessions in [from, to), start hours
+// are counted in the location of from.
+func (s *Store) Stats(uid uint64, from, to time.Time) (stats Stats, err error) {
+	sessions, err := s.Sessions(uid, from, to)
+	if err != nil {
+		return
+	}
+	return statsOf(sessions, from.Location()), nil
+}
+
+func statsOf(sessions []Session, location *time.Location) (stats Stats) {
+	stats.TypicalStartHour = -1
+	for _, session := range sessions {
+		duration := session.Duration()
+		stats.Sessions++
+		stats.Online += duration
+		if duration > stats.LongestSession {
+			stats.LongestSession = duration
+		}
+		if !session.Cut {
+			stats.StartHours[session.Start.In(location).Hour()]++
+		}
+	}
+	if stats.Sessions == 0 {
+		return
+	}
+	stats.AverageSession = stats.Online / time.Duration(stats.Sessions)
+	for hour, count := range stats.StartHours {
+		if count > 0 && (stats.TypicalStartHour < 0 || count > stats.StartHours[stats.TypicalStartHour]) {
+			stats.TypicalStartHour = hour
+		}
+	}
+	return
+}
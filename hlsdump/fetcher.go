@@ -0,0 +1,181 @@
+package hlsdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultInterval is the reload interval of a playlist without a target
+// duration.
+const defaultInterval = time.Second
+
+// maxPlaylistSize limits the size of a playlist download.
+const maxPlaylistSize = 1024 * 1024
+
+var ErrStreamGone = errors.New("hlsdump: the playlist is not available anymore")
+var ErrNoSegments = errors.New("hlsdump: master playlist without media playlists")
+
+// StatusError is an unexpected HTTP status of a playlist or a segment.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("hlsdump: %s: %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// gone reports whether the status tells the stream has ended.
+func (e *StatusError) gone() bool {
+	return e.Code == http.StatusNotFound || e.Code == http.StatusGone
+}
+
+// Fetcher polls a live playlist and downloads its segments.
+type Fetcher struct {
+	URL string
+	// Client makes the requests, http.DefaultClient is used when it is nil.
+	Client *http.Client
+	// Interval between the reloads of the playlist. When it is zero the
+	// playlist is reloaded after its target duration, after the half of
+	// it when nothing was added, as RFC 8216 asks.
+	Interval time.Duration
+}
+
+// Run downloads the segments until the playlist ends, handle returns an
+// error or the context is cancelled. Every segment is passed to handle
+// once in the order of the sequence numbers, the first ones are the
+// segments listed by the first load. A master playlist is replaced by
+// its variant with the highest bandwidth.
+// Run returns nil when the playlist ends with EXT-X-ENDLIST and
+// ErrStreamGone when the playlist is not found anymore after the first
+// segment, as the servers do when the model stops streaming.
+func (f *Fetcher) Run(ctx context.Context, handle func(segment Segment, data []byte) error) error {
+	mediaURL := f.URL
+	var next uint64
+	started := false
+	for {
+		loadStart := time.Now()
+		playlist, err := f.loadPlaylist(ctx, mediaURL)
+		if err != nil {
+			if statusErr, ok := err.(*StatusError); ok && started && statusErr.gone() {
+				return ErrStreamGone
+			}
+			return err
+		}
+		if playlist.IsMaster() {
+			if mediaURL != f.URL {
+				return fmt.Errorf("hlsdump: %s: nested master playlist", mediaURL)
+			}
+			variant, _ := playlist.BestVariant()
+			if variant.URI == "" {
+				return ErrNoSegments
+			}
+			mediaURL = variant.URI
+			continue
+		}
+		if started && restarted(playlist, next) {
+			next = playlist.MediaSequence
+		}
+		added := false
+		for _, segment := range playlist.Segments {
+			if started && segment.Sequence < next {
+				continue
+			}
+			data, err := f.get(ctx, segment.URI, 0)
+			if err != nil {
+				if statusErr, ok := err.(*StatusError); ok && statusErr.gone() {
+					// removed from the playlist meanwhile
+					continue
+				}
+				return err
+			}
+			if err = handle(segment, data); err != nil {
+				return err
+			}
+			next = segment.Sequence + 1
+			started = true
+			added = true
+		}
+		if playlist.Ended {
+			return nil
+		}
+		select {
+		case <-time.After(f.wait(playlist, added) - time.Since(loadStart)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// restarted reports whether the sequence numbers of the playlist started
+// again after a restart of the stream. A playlist behind next is a stale
+// copy of a cache unless it is more than a window behind or it starts
+// with a discontinuity.
+func restarted(playlist *Playlist, next uint64) bool {
+	window := uint64(len(playlist.Segments))
+	if window == 0 {
+		return false
+	}
+	end := playlist.MediaSequence + window
+	if end >= next {
+		return false
+	}
+	return end+window < next || playlist.Segments[0].Discontinuity
+}
+
+func (f *Fetcher) wait(playlist *Playlist, added bool) time.Duration {
+	if f.Interval > 0 {
+		return f.Interval
+	}
+	wait := playlist.TargetDuration
+	if wait <= 0 {
+		return defaultInterval
+	}
+	if !added {
+		wait /= 2
+	}
+	return wait
+}
+
+func (f *Fetcher) loadPlaylist(ctx context.Context, playlistURL string) (playlist *Playlist, err error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return
+	}
+	data, err := f.get(ctx, playlistURL, maxPlaylistSize)
+	if err != nil {
+		return
+	}
+	return Parse(data, base)
+}
+
+// get downloads the url, limit is the maximum size when it is not zero.
+func (f *Fetcher) get(ctx context.Context, target string, limit int64) (data []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: target, Code: resp.StatusCode}
+	}
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(body, limit)
+	}
+	return ioutil.ReadAll(body)
+}
@@ -0,0 +1,194 @@
+package hlsdump
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// liveServer serves a live playlist with a window of segments, every
+// load of the playlist adds a segment.
+type liveServer struct {
+	mu      sync.Mutex
+	window  int
+	last    int
+	total   int
+	gone    bool
+	served  bool
+	removed map[int]bool
+	loads   int
+}
+
+func (s *liveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.URL.Path == "/playlist.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=900\nhigh.m3u8\n")
+	case r.URL.Path == "/high.m3u8":
+		if s.gone && s.served {
+			http.NotFound(w, r)
+			return
+		}
+		s.loads++
+		first := s.last - s.window + 1
+		if first < 0 {
+			first = 0
+		}
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+		for i := first; i <= s.last; i++ {
+			fmt.Fprintf(w, "#EXTINF:1.0,\nsegment_%d.ts\n", i)
+		}
+		if s.last >= s.total && !s.gone {
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+		}
+		if s.last < s.total {
+			s.last++
+		} else {
+			s.served = true
+		}
+	case strings.HasPrefix(r.URL.Path, "/segment_"):
+		var index int
+		fmt.Sscanf(r.URL.Path, "/segment_%d.ts", &index)
+		if s.removed[index] {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "data %d", index)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func fetchAll(t *testing.T, live *liveServer) (got []string, err error) {
+	server := httptest.NewServer(live)
+	defer server.Close()
+	f := &Fetcher{URL: server.URL + "/playlist.m3u8", Interval: time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = f.Run(ctx, func(segment Segment, data []byte) error {
+		if expect := fmt.Sprintf("data %d", segment.Sequence); string(data) != expect {
+			t.Errorf("segment %d data got: %q, expect: %q", segment.Sequence, data, expect)
+		}
+		got = append(got, string(data))
+		return nil
+	})
+	return
+}
+
+func TestFetcherInOrder(t *testing.T) {
+	live := &liveServer{window: 3, last: 2, total: 6, removed: map[int]bool{4: true}}
+	got, err := fetchAll(t, live)
+	if err != nil {
+		t.Fatalf("Run error: %s", err)
+	}
+	// segment 4 is not available, the others come once and in order
+	expect := []string{"data 0", "data 1", "data 2", "data 3", "data 5", "data 6"}
+	if strings.Join(got, ",") != strings.Join(expect, ",") {
+		t.Errorf("segments got: %v, expect: %v", got, expect)
+	}
+}
+
+func TestFetcherGone(t *testing.T) {
+	live := &liveServer{window: 2, last: 1, total: 3, gone: true}
+	got, err := fetchAll(t, live)
+	if err != ErrStreamGone {
+		t.Errorf("Run got: %v, expect: %v", err, ErrStreamGone)
+	}
+	if len(got) != 4 {
+		t.Errorf("segments got: %v, expect 4", got)
+	}
+}
+
+func TestFetcherNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	f := &Fetcher{URL: server.URL + "/playlist.m3u8"}
+	err := f.Run(context.Background(), func(Segment, []byte) error { return nil })
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.Code != http.StatusNotFound {
+		t.Errorf("Run got: %v, expect a 404 StatusError", err)
+	}
+}
+
+// scriptedServer serves the media playlists in order, the last one is
+// repeated.
+type scriptedServer struct {
+	mu        sync.Mutex
+	playlists []string
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/segment_") {
+		var index int
+		fmt.Sscanf(r.URL.Path, "/segment_%d.ts", &index)
+		fmt.Fprintf(w, "data %d", index)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprint(w, s.playlists[0])
+	if len(s.playlists) > 1 {
+		s.playlists = s.playlists[1:]
+	}
+}
+
+func testPlaylist(first, count int, prefix string, ended bool) string {
+	playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n%s", first, prefix)
+	for i := first; i < first+count; i++ {
+		playlist += fmt.Sprintf("#EXTINF:1.0,\nsegment_%d.ts\n", i)
+	}
+	if ended {
+		playlist += "#EXT-X-ENDLIST\n"
+	}
+	return playlist
+}
+
+func fetchScripted(t *testing.T, playlists ...string) []string {
+	server := httptest.NewServer(&scriptedServer{playlists: playlists})
+	defer server.Close()
+	f := &Fetcher{URL: server.URL + "/media.m3u8", Interval: time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []string
+	err := f.Run(ctx, func(segment Segment, data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run error: %s", err)
+	}
+	return got
+}
+
+func TestFetcherStalePlaylist(t *testing.T) {
+	got := fetchScripted(t,
+		testPlaylist(5, 4, "", false),
+		testPlaylist(6, 4, "", false),
+		// a stale copy of the first load
+		testPlaylist(5, 4, "", false),
+		testPlaylist(7, 4, "", true),
+	)
+	expect := "data 5,data 6,data 7,data 8,data 9,data 10"
+	if strings.Join(got, ",") != expect {
+		t.Errorf("segments got: %v, expect: %s", got, expect)
+	}
+}
+
+func TestFetcherRestart(t *testing.T) {
+	got := fetchScripted(t,
+		testPlaylist(20, 3, "", false),
+		// the stream started again with a discontinuity
+		testPlaylist(18, 3, "#EXT-X-DISCONTINUITY\n", false),
+		// far behind without a discontinuity
+		testPlaylist(0, 2, "", true),
+	)
+	expect := "data 20,data 21,data 22,data 18,data 19,data 20,data 0,data 1"
+	if strings.Join(got, ",") != expect {
+		t.Errorf("segments got: %v, expect: %s", got, expect)
+	}
+}
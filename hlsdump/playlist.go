@@ -0,0 +1,178 @@
+// Package hlsdump downloads a live HLS stream: it parses the m3u8
+// playlists and polls the media playlist for the new segments, which are
+// passed on once and in the order of their sequence numbers.
+package hlsdump
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotPlaylist = errors.New("hlsdump: not an m3u8 playlist")
+
+// Variant is a stream of a master playlist.
+type Variant struct {
+	Bandwidth  int
+	Resolution string
+	URI        string
+}
+
+// Segment is a media segment, Sequence is its media sequence number.
+// Discontinuity is set when the timestamps of the segment do not follow
+// the previous one.
+type Segment struct {
+	Sequence      uint64
+	Duration      time.Duration
+	URI           string
+	Discontinuity bool
+}
+
+// Playlist is a master playlist with Variants or a media playlist with
+// Segments. The URIs are resolved against the url of the playlist.
+type Playlist struct {
+	Variants       []Variant
+	TargetDuration time.Duration
+	MediaSequence  uint64
+	Segments       []Segment
+	// Ended is set by EXT-X-ENDLIST, no segments are added anymore
+	Ended bool
+}
+
+func (p *Playlist) IsMaster() bool {
+	return len(p.Variants) > 0
+}
+
+// BestVariant returns the variant with the highest bandwidth.
+func (p *Playlist) BestVariant() (best Variant, ok bool) {
+	for i, variant := range p.Variants {
+		if i == 0 || variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+	return best, len(p.Variants) > 0
+}
+
+// Parse parses a master or a media playlist loaded from base.
+func Parse(data []byte, base *url.URL) (p *Playlist, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	p = &Playlist{}
+	header := false
+	var variant *Variant
+	var segment *Segment
+	discontinuity := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !header {
+			if line != "#EXTM3U" {
+				return nil, ErrNotPlaylist
+			}
+			header = true
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			uri, resolveErr := resolve(base, line)
+			if resolveErr != nil {
+				return nil, resolveErr
+			}
+			switch {
+			case variant != nil:
+				variant.URI = uri
+				p.Variants = append(p.Variants, *variant)
+				variant = nil
+			case segment != nil:
+				segment.URI = uri
+				segment.Sequence = p.MediaSequence + uint64(len(p.Segments))
+				segment.Discontinuity = discontinuity
+				p.Segments = append(p.Segments, *segment)
+				segment = nil
+				discontinuity = false
+			}
+			continue
+		}
+		tag, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			tag, value = line[:i], line[i+1:]
+		}
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			variant = &Variant{Bandwidth: bandwidth, Resolution: attrs["RESOLUTION"]}
+		case "#EXTINF":
+			duration, _ := time.ParseDuration(strings.TrimSpace(strings.SplitN(value, ",", 2)[0]) + "s")
+			segment = &Segment{Duration: duration}
+		case "#EXT-X-TARGETDURATION":
+			seconds, _ := strconv.Atoi(value)
+			p.TargetDuration = time.Duration(seconds) * time.Second
+		case "#EXT-X-MEDIA-SEQUENCE":
+			sequence, parseErr := strconv.ParseUint(value, 10, 64)
+			if parseErr != nil {
+				return nil, ErrNotPlaylist
+			}
+			p.MediaSequence = sequence
+		case "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case "#EXT-X-ENDLIST":
+			p.Ended = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, ErrNotPlaylist
+	}
+	return
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+// parseAttributes parses an attribute list, e.g.
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+	for list != "" {
+		eq := strings.IndexByte(list, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end < 0 {
+				value, list = list[1:], ""
+			} else {
+				value, list = list[1:end+1], list[end+2:]
+			}
+		} else if comma := strings.IndexByte(list, ','); comma >= 0 {
+			value = list[:comma]
+			list = list[comma:]
+		} else {
+			value = list
+			list = ""
+		}
+		attrs[name] = value
+		list = strings.TrimPrefix(list, ",")
+	}
+	return attrs
+}
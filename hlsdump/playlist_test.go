@@ -0,0 +1,73 @@
+package hlsdump
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseMaster(t *testing.T) {
+	base, _ := url.Parse("https://video1.example.com/NxServer/ngrp:mfc_1.f4v_mobile/playlist.m3u8")
+	data := []byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=400000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=320x240
+chunklist_low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720
+chunklist_high.m3u8?token=1
+`)
+	p, err := Parse(data, base)
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	if !p.IsMaster() || len(p.Variants) != 2 {
+		t.Fatalf("Parse got: %+v", p)
+	}
+	best, ok := p.BestVariant()
+	expect := "https://video1.example.com/NxServer/ngrp:mfc_1.f4v_mobile/chunklist_high.m3u8?token=1"
+	if !ok || best.URI != expect || best.Bandwidth != 1280000 || best.Resolution != "1280x720" {
+		t.Errorf("BestVariant got: %+v, expect uri: %s", best, expect)
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+	base, _ := url.Parse("http://localhost/live/chunklist.m3u8")
+	data := []byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:120
+
+#EXTINF:4.004,
+media_120.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:3.5,
+/other/media_121.ts
+#EXT-X-ENDLIST
+`)
+	p, err := Parse(data, base)
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	if p.IsMaster() || !p.Ended || p.TargetDuration != 4*time.Second || p.MediaSequence != 120 {
+		t.Errorf("Parse got: %+v", p)
+	}
+	expect := []Segment{
+		{Sequence: 120, Duration: 4004 * time.Millisecond, URI: "http://localhost/live/media_120.ts"},
+		{Sequence: 121, Duration: 3500 * time.Millisecond, URI: "http://localhost/other/media_121.ts", Discontinuity: true},
+	}
+	if len(p.Segments) != len(expect) {
+		t.Fatalf("segments got: %+v, expect: %+v", p.Segments, expect)
+	}
+	for i := range expect {
+		if p.Segments[i] != expect[i] {
+			t.Errorf("segment %d got: %+v, expect: %+v", i, p.Segments[i], expect[i])
+		}
+	}
+}
+
+func TestParseNotPlaylist(t *testing.T) {
+	for _, data := range []string{"", "<html></html>", "\n\n"} {
+		if _, err := Parse([]byte(data), nil); err != ErrNotPlaylist {
+			t.Errorf("Parse(%q) got: %v, expect: %v", data, err, ErrNotPlaylist)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOutput limits the response body and the command output kept in
+// the errors.
+const maxOutput = 512
+
+// Webhook posts the payload as JSON to URL, a response status other than
+// 2xx is an error. http.DefaultClient is used when Client is nil.
+type Webhook struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+func (w *Webhook) Run(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range w.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s %s", w.URL, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// Command runs the command line in the system shell, sh -c or cmd /C on
+// windows, with the payload in the environment: MFC_EVENT, MFC_MODEL,
+// MFC_UID, MFC_VS, MFC_CAMSERV, MFC_TIME (RFC 3339), MFC_FILE, MFC_BYTES,
+// MFC_DURATION and MFC_ERROR. The payload is also written as JSON to the
+// standard input. A non zero exit status is an error.
+type Command struct {
+	Line string
+	Dir  string
+}
+
+func (c *Command) Run(ctx context.Context, payload Payload) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.Line)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.Line)
+	}
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), Environ(payload)...)
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(output) > maxOutput {
+			output = output[len(output)-maxOutput:]
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("command %q: %s %s", c.Line, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Environ returns the environment variables of the payload passed to
+// the commands.
+func Environ(payload Payload) []string {
+	return []string{
+		"MFC_EVENT=" + string(payload.Event),
+		"MFC_MODEL=" + payload.Model,
+		"MFC_UID=" + strconv.FormatUint(payload.Uid, 10),
+		"MFC_VS=" + strconv.FormatUint(payload.Vs, 10),
+		"MFC_CAMSERV=" + strconv.FormatInt(int64(payload.Camserv), 10),
+		"MFC_TIME=" + payload.Time.Format(time.RFC3339),
+		"MFC_FILE=" + payload.File,
+		"MFC_BYTES=" + strconv.FormatInt(payload.Bytes, 10),
+		"MFC_DURATION=" + strconv.FormatFloat(payload.Duration, 'f', 3, 64),
+		"MFC_ERROR=" + payload.Error,
+	}
+}
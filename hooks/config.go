@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// hookConfig is a hook in the config file, exactly one of URL and Command
+// is set. Durations are strings like "10s".
+type hookConfig struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers"`
+	Command    string            `json:"command"`
+	Dir        string            `json:"dir"`
+	Events     []Event           `json:"events"`
+	Models     []string          `json:"models"`
+	Retries    int               `json:"retries"`
+	Timeout    string            `json:"timeout"`
+	RetryDelay string            `json:"retry_delay"`
+}
+
+// LoadConfig reads the hooks from a JSON file, see ReadConfig.
+func LoadConfig(path string) (hooks []*Hook, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ReadConfig(f)
+}
+
+// ReadConfig reads a JSON array of hooks:
+//
+//	[
+//		{"name": "notify", "url": "https://example.com/mfc", "headers": {"Authorization": "Bearer token"},
+//		 "events": ["online", "off"], "retries": 3, "timeout": "10s", "retry_delay": "5s"},
+//		{"command": "echo $MFC_MODEL $MFC_FILE >> records.txt", "events": ["record_finished"], "models": ["model"]}
+//	]
+func ReadConfig(r io.Reader) (hooks []*Hook, err error) {
+	var configs []hookConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("hooks config: %w", err)
+	}
+	for i, config := range configs {
+		hook, err := config.hook()
+		if err != nil {
+			return nil, fmt.Errorf("hooks config: hook %d: %w", i+1, err)
+		}
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("#%d", i+1)
+		}
+		hooks = append(hooks, hook)
+	}
+	return
+}
+
+func (c hookConfig) hook() (hook *Hook, err error) {
+	hook = &Hook{
+		Name:    c.Name,
+		Events:  c.Events,
+		Models:  c.Models,
+		Retries: c.Retries,
+	}
+	for _, event := range c.Events {
+		if !events[event] {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	if c.Retries < 0 {
+		return nil, fmt.Errorf("negative retries %d", c.Retries)
+	}
+	if hook.Timeout, err = parseDuration(c.Timeout); err != nil {
+		return nil, fmt.Errorf("timeout: %w", err)
+	}
+	if hook.RetryDelay, err = parseDuration(c.RetryDelay); err != nil {
+		return nil, fmt.Errorf("retry_delay: %w", err)
+	}
+	switch {
+	case c.URL != "" && c.Command != "":
+		return nil, fmt.Errorf("both url and command are set")
+	case c.URL != "":
+		header := make(http.Header)
+		for key, value := range c.Headers {
+			header.Set(key, value)
+		}
+		hook.Action = &Webhook{URL: c.URL, Header: header}
+	case c.Command != "":
+		hook.Action = &Command{Line: c.Command, Dir: c.Dir}
+	default:
+		return nil, fmt.Errorf("neither url nor command is set")
+	}
+	return
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,287 @@
+// Package hooks runs actions on model state transitions and recordings:
+// HTTP webhooks with a JSON payload, commands with the payload in the
+// environment or Go callbacks. Hooks are filtered by event and model,
+// every attempt is limited by a timeout and failed attempts are retried.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/models"
+)
+
+type Event string
+
+const (
+	EventOnline         Event = "online"
+	EventAway           Event = "away"
+	EventPrivate        Event = "private"
+	EventGroup          Event = "group"
+	EventOff            Event = "off"
+	EventRecordStarted  Event = "record_started"
+	EventRecordFinished Event = "record_finished"
+)
+
+var events = map[Event]bool{
+	EventOnline:         true,
+	EventAway:           true,
+	EventPrivate:        true,
+	EventGroup:          true,
+	EventOff:            true,
+	EventRecordStarted:  true,
+	EventRecordFinished: true,
+}
+
+var statusEvents = map[uint64]Event{
+	models.IsOnline:  EventOnline,
+	models.IsAway:    EventAway,
+	models.IsPrivate: EventPrivate,
+	models.IsGroup:   EventGroup,
+	models.IsOff:     EventOff,
+	models.Except:    EventOff,
+}
+
+// StatusEvent returns the event of the model status, ok is false for
+// an unknown status.
+func StatusEvent(vs uint64) (event Event, ok bool) {
+	event, ok = statusEvents[vs]
+	return
+}
+
+const (
+	DefaultTimeout    = 10 * time.Second
+	DefaultRetryDelay = 5 * time.Second
+	hookQueueSize     = 100
+)
+
+var ErrQueueFull = errors.New("hooks: queue is full, payload dropped")
+
+// Payload describes the event passed to the hooks. File, Bytes, Duration
+// and Error are set for the recording events only.
+type Payload struct {
+	Event    Event     `json:"event"`
+	Model    string    `json:"model"`
+	Uid      uint64    `json:"uid"`
+	Vs       uint64    `json:"vs"`
+	Camserv  int32     `json:"camserv,omitempty"`
+	Time     time.Time `json:"time"`
+	File     string    `json:"file,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// StatusPayload returns the payload of the status change of the model,
+// ok is false for an unknown status.
+func StatusPayload(m models.MFCModel, at time.Time) (payload Payload, ok bool) {
+	event, ok := StatusEvent(m.Vs)
+	if !ok {
+		return
+	}
+	payload = Payload{
+		Event:   event,
+		Model:   m.Nm,
+		Uid:     m.Uid,
+		Vs:      m.Vs,
+		Camserv: m.U.Camserv,
+		Time:    at,
+	}
+	return
+}
+
+// Action is run by a hook, it should stop when the context is done.
+type Action interface {
+	Run(ctx context.Context, payload Payload) error
+}
+
+// Func is a Go callback used as an action.
+type Func func(ctx context.Context, payload Payload) error
+
+func (f Func) Run(ctx context.Context, payload Payload) error {
+	return f(ctx, payload)
+}
+
+// Hook runs the action for the payloads passing the filters. Empty Events
+// or Models match everything, model names are compared ignoring case.
+// Every attempt is limited by Timeout, DefaultTimeout when zero, a failed
+// action is retried Retries times after RetryDelay, DefaultRetryDelay
+// when zero, growing with every attempt.
+type Hook struct {
+	Name       string
+	Action     Action
+	Events     []Event
+	Models     []string
+	Retries    int
+	Timeout    time.Duration
+	RetryDelay time.Duration
+}
+
+// Match reports whether the hook runs for the payload.
+func (h *Hook) Match(payload Payload) bool {
+	if len(h.Events) > 0 && !containsEvent(h.Events, payload.Event) {
+		return false
+	}
+	if len(h.Models) == 0 {
+		return true
+	}
+	for _, model := range h.Models {
+		if strings.EqualFold(model, payload.Model) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEvent(events []Event, event Event) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs the action with the retries, it returns the last error.
+func (h *Hook) run(ctx context.Context, payload Payload) (err error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	retryDelay := h.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = DefaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = h.Action.Run(attemptCtx, payload)
+		cancel()
+		if err == nil || attempt >= h.Retries {
+			return
+		}
+		select {
+		case <-time.After(retryDelay * time.Duration(attempt+1)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Error is a failure of a hook passed to Dispatcher.OnError.
+type Error struct {
+	Hook    *Hook
+	Payload Payload
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hook %q on %s of %q: %s", e.Hook.Name, e.Payload.Event, e.Payload.Model, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Dispatcher runs the hooks in background. Every hook has its own queue,
+// so a slow hook does not delay the others and the payloads reach a hook
+// in the order they were fired. When the queue of a hook is full the
+// payload is dropped. Failures are passed to OnError, it must be set
+// before the first Fire.
+type Dispatcher struct {
+	OnError func(err *Error)
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	workers []*hookWorker
+}
+
+type hookWorker struct {
+	hook  *Hook
+	queue chan Payload
+}
+
+// NewDispatcher starts the workers of the hooks.
+func NewDispatcher(hooks ...*Hook) *Dispatcher {
+	d := &Dispatcher{}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, hook := range hooks {
+		worker := &hookWorker{hook: hook, queue: make(chan Payload, hookQueueSize)}
+		d.workers = append(d.workers, worker)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for payload := range worker.queue {
+				if d.ctx.Err() != nil {
+					continue
+				}
+				if err := worker.hook.run(d.ctx, payload); err != nil {
+					d.fail(worker.hook, payload, err)
+				}
+			}
+		}()
+	}
+	return d
+}
+
+func (d *Dispatcher) fail(hook *Hook, payload Payload, err error) {
+	if d.OnError != nil {
+		d.OnError(&Error{Hook: hook, Payload: payload, Err: err})
+	}
+}
+
+// Fire queues the payload for the matching hooks, it never blocks.
+// Payloads fired after Close are ignored.
+func (d *Dispatcher) Fire(payload Payload) {
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, worker := range d.workers {
+		if !worker.hook.Match(payload) {
+			continue
+		}
+		select {
+		case worker.queue <- payload:
+		default:
+			d.fail(worker.hook, payload, ErrQueueFull)
+		}
+	}
+}
+
+// Close runs the queued payloads and waits for them until the context is
+// done, then the running actions are cancelled.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, worker := range d.workers {
+			close(worker.queue)
+		}
+	}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	defer d.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
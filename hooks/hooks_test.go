@@ -0,0 +1,236 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gomfc/models"
+)
+
+var testPayload = Payload{
+	Event: EventOnline,
+	Model: "Model",
+	Uid:   42,
+	Vs:    models.IsOnline,
+	Time:  time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC),
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusPayload(t *testing.T) {
+	var m models.MFCModel
+	m.Nm, m.Uid, m.Vs = "Model", 42, models.Except
+	payload, ok := StatusPayload(m, testPayload.Time)
+	if !ok || payload.Event != EventOff || payload.Model != "Model" || payload.Uid != 42 {
+		t.Fatalf("payload: %+v, %v", payload, ok)
+	}
+	m.Vs = 1
+	if _, ok := StatusPayload(m, testPayload.Time); ok {
+		t.Fatal("payload of unknown status")
+	}
+}
+
+func TestHookMatch(t *testing.T) {
+	tests := []struct {
+		hook Hook
+		want bool
+	}{
+		{Hook{}, true},
+		{Hook{Events: []Event{EventOnline, EventOff}}, true},
+		{Hook{Events: []Event{EventRecordStarted}}, false},
+		{Hook{Models: []string{"other", "MODEL"}}, true},
+		{Hook{Models: []string{"other"}}, false},
+		{Hook{Events: []Event{EventOnline}, Models: []string{"other"}}, false},
+	}
+	for i, test := range tests {
+		if got := test.hook.Match(testPayload); got != test.want {
+			t.Errorf("test %d: match %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestHookRetries(t *testing.T) {
+	attempts := 0
+	hook := &Hook{
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		Action: Func(func(ctx context.Context, payload Payload) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("failed")
+			}
+			return nil
+		}),
+	}
+	if err := hook.run(context.Background(), testPayload); err != nil || attempts != 3 {
+		t.Fatalf("run: %v after %d attempts", err, attempts)
+	}
+	attempts = -10
+	if err := hook.run(context.Background(), testPayload); err == nil || attempts != -7 {
+		t.Fatalf("run: %v after %d attempts, want 3 failed", err, attempts+10)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	hook := &Hook{
+		Timeout: 10 * time.Millisecond,
+		Action: Func(func(ctx context.Context, payload Payload) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	}
+	if err := hook.run(context.Background(), testPayload); err != context.DeadlineExceeded {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var got []Event
+	var failed []*Error
+	record := &Hook{Action: Func(func(ctx context.Context, payload Payload) error {
+		mu.Lock()
+		got = append(got, payload.Event)
+		mu.Unlock()
+		return nil
+	})}
+	broken := &Hook{
+		Name:   "broken",
+		Events: []Event{EventOff},
+		Action: Func(func(ctx context.Context, payload Payload) error {
+			return errors.New("failed")
+		}),
+	}
+	d := NewDispatcher(record, broken)
+	d.OnError = func(err *Error) {
+		mu.Lock()
+		failed = append(failed, err)
+		mu.Unlock()
+	}
+	for _, event := range []Event{EventOnline, EventAway, EventOnline, EventOff} {
+		payload := testPayload
+		payload.Event = event
+		d.Fire(payload)
+	}
+	closeDispatcher(t, d)
+	d.Fire(testPayload)
+	want := []Event{EventOnline, EventAway, EventOnline, EventOff}
+	if strings.Join(eventStrings(got), " ") != strings.Join(eventStrings(want), " ") {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if len(failed) != 1 || failed[0].Hook != broken || failed[0].Payload.Event != EventOff {
+		t.Fatalf("errors: %v", failed)
+	}
+}
+
+func eventStrings(events []Event) (s []string) {
+	for _, event := range events {
+		s = append(s, string(event))
+	}
+	return
+}
+
+func TestWebhook(t *testing.T) {
+	var got Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad headers", http.StatusForbidden)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	webhook := &Webhook{URL: server.URL, Header: http.Header{"Authorization": {"Bearer token"}}}
+	if err := webhook.Run(context.Background(), testPayload); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != EventOnline || got.Model != "Model" || !got.Time.Equal(testPayload.Time) {
+		t.Fatalf("payload: %+v", got)
+	}
+	webhook.Header = nil
+	if err := webhook.Run(context.Background(), testPayload); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("forbidden: %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is needed")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	command := &Command{Line: `echo "$MFC_EVENT $MFC_MODEL $MFC_UID" > ` + out + `; cat >> ` + out}
+	if err := command.Run(context.Background(), testPayload); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(data), "\n", 2)
+	if lines[0] != "online Model 42" || !strings.HasPrefix(lines[1], `{"event":"online"`) {
+		t.Fatalf("output: %q", data)
+	}
+	command.Line = "echo oops; exit 3"
+	if err := command.Run(context.Background(), testPayload); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("failed command: %v", err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	hooks, err := ReadConfig(strings.NewReader(`[
+		{"name": "notify", "url": "http://localhost/hook", "headers": {"X-Token": "secret"},
+		 "events": ["online", "off"], "retries": 3, "timeout": "2s", "retry_delay": "1s"},
+		{"command": "true", "models": ["model"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("got %d hooks", len(hooks))
+	}
+	webhook, ok := hooks[0].Action.(*Webhook)
+	if !ok || webhook.Header.Get("X-Token") != "secret" || hooks[0].Retries != 3 ||
+		hooks[0].Timeout != 2*time.Second || hooks[0].RetryDelay != time.Second {
+		t.Fatalf("webhook: %+v", hooks[0])
+	}
+	if _, ok := hooks[1].Action.(*Command); !ok || hooks[1].Name != "#2" {
+		t.Fatalf("command: %+v", hooks[1])
+	}
+	for _, config := range []string{
+		`[{"url": "http://localhost", "command": "true"}]`,
+		`[{}]`,
+		`[{"command": "true", "events": ["started"]}]`,
+		`[{"command": "true", "timeout": "soon"}]`,
+		`[{"command": "true", "retries": -1}]`,
+		`[{"command": "true", "unknown": 1}]`,
+	} {
+		if _, err := ReadConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("config %s is accepted", config)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "hooks.json")); !os.IsNotExist(err) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
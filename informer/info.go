@@ -6,32 +6,34 @@ import (
 	"bufio"
 	"os"
 	"strings"
+	"strconv"
+	"context"
 
 	"github.com/go-errors/errors"
 
 	"gomfc/ws_client"
 	"gomfc/models"
+	"gomfc/shutdown"
 
 )
 
 const waitTimeout = 60 * time.Second
 
 
-func getModelUid(modelName string) (uid uint64, err error) {
-	wsConn, err := ws_client.CreateConnection(modelName, false)
+// lookupModel resolves the argument as a model name or, when it is
+// a number, as an uid.
+func lookupModel(ctx context.Context, arg string) (model models.MFCModel, err error) {
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	client, err := ws_client.NewLookupClient(ctx, ws_client.DefaultClientConfig())
 	if err != nil {
 		return
 	}
-	modelRaw, err := wsConn.ReadSingle(waitTimeout)
-	if err != nil {
-		return
-	}
-	model, err := models.GetModelData(modelRaw)
-	if err != nil {
-		return
+	defer client.Close()
+	if uid, parseErr := strconv.ParseUint(arg, 10, 64); parseErr == nil {
+		return client.LookupByUID(ctx, uid)
 	}
-	uid = model.Uid
-	return
+	return client.LookupByName(ctx, arg)
 }
 
 func exitProgram(waitEnter bool) {
@@ -64,15 +66,19 @@ func main() {
 		modelName = os.Args[1]
 	}
 	defer exitProgram(waitEnter)
-	modelUid, err := getModelUid(modelName)
+	ctx, cancel := shutdown.Context()
+	defer cancel()
+	model, err := lookupModel(ctx, modelName)
 	if err != nil {
-		if err == models.NotFoundError {
+		if err == models.NotFoundError || err == context.Canceled {
 			fmt.Println(err)
 			return
 		} else {
 			panic(err)
 		}
 	}
-	fmt.Printf("Model uid: %d\n", modelUid)
+	fmt.Printf("Model: %s\n", model.Nm)
+	fmt.Printf("Model uid: %d\n", model.Uid)
+	fmt.Printf("Status: %s\n", model.Status)
 }
 
@@ -0,0 +1,164 @@
+// Package metrics holds the Prometheus metrics of the websocket client,
+// the RTMP connections and the recordings. They are served by Handler,
+// usually on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	rtmp "gomfc/gortmp"
+)
+
+const namespace = "mfc"
+
+// Registry holds the metrics of the package and the Go runtime and
+// process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	WebsocketConnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "connects_total",
+		Help:      "Websocket connection attempts of the supervised connections by result: connected, reconnected or failed.",
+	}, []string{"result"})
+	WebsocketMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "messages_total",
+		Help:      "FCS frames received by type.",
+	}, []string{"type"})
+	LoginDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "login_duration_seconds",
+		Help:      "Time from the challenge request to the model lookup of a new websocket connection by result: ok or error.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 4, 8, 16, 32},
+	}, []string{"result"})
+
+	RTMPBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rtmp",
+		Name:      "bytes_total",
+		Help:      "Bytes received (in) and sent (out) by the RTMP connections.",
+	}, []string{"direction"})
+	RTMPChunkSizeChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rtmp",
+		Name:      "chunk_size_changes_total",
+		Help:      "Chunk size changes requested by the server (in) and by the client (out).",
+	}, []string{"direction"})
+	RTMPAcknowledgements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rtmp",
+		Name:      "acknowledgements_total",
+		Help:      "Window acknowledgements received (in) and sent (out).",
+	}, []string{"direction"})
+	RTMPPingRoundTrip = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rtmp",
+		Name:      "ping_round_trip_seconds",
+		Help:      "Round trip time of the ping requests sent to the video servers.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	ActiveRecordings = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "recording",
+		Name:      "active",
+		Help:      "Recordings receiving the stream.",
+	})
+	RecordingBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "recording",
+		Name:      "bytes_total",
+		Help:      "Stream bytes written by model.",
+	}, []string{"model"})
+	RecordingStalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "recording",
+		Name:      "stalls_total",
+		Help:      "Recordings stopped because no data was received, by model.",
+	}, []string{"model"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		WebsocketConnects,
+		WebsocketMessages,
+		LoginDuration,
+		RTMPBytes,
+		RTMPChunkSizeChanges,
+		RTMPAcknowledgements,
+		RTMPPingRoundTrip,
+		ActiveRecordings,
+		RecordingBytes,
+		RecordingStalls,
+	)
+	rtmp.SetObserver(rtmpObserver{
+		bytesIn:        RTMPBytes.WithLabelValues("in"),
+		bytesOut:       RTMPBytes.WithLabelValues("out"),
+		chunkSizeIn:    RTMPChunkSizeChanges.WithLabelValues("in"),
+		chunkSizeOut:   RTMPChunkSizeChanges.WithLabelValues("out"),
+		acknowledgeIn:  RTMPAcknowledgements.WithLabelValues("in"),
+		acknowledgeOut: RTMPAcknowledgements.WithLabelValues("out"),
+	})
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves Handler on /metrics of the address until the
+// listener fails.
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// rtmpObserver passes the events of the RTMP connections to the metrics.
+type rtmpObserver struct {
+	bytesIn        prometheus.Counter
+	bytesOut       prometheus.Counter
+	chunkSizeIn    prometheus.Counter
+	chunkSizeOut   prometheus.Counter
+	acknowledgeIn  prometheus.Counter
+	acknowledgeOut prometheus.Counter
+}
+
+func (o rtmpObserver) BytesReceived(n int) {
+	o.bytesIn.Add(float64(n))
+}
+
+func (o rtmpObserver) BytesSent(n int) {
+	o.bytesOut.Add(float64(n))
+}
+
+func (o rtmpObserver) ChunkSizeChanged(inbound bool, size uint32) {
+	if inbound {
+		o.chunkSizeIn.Inc()
+	} else {
+		o.chunkSizeOut.Inc()
+	}
+}
+
+func (o rtmpObserver) Acknowledgement(inbound bool) {
+	if inbound {
+		o.acknowledgeIn.Inc()
+	} else {
+		o.acknowledgeOut.Inc()
+	}
+}
+
+func (o rtmpObserver) PingRoundTrip(rtt time.Duration) {
+	RTMPPingRoundTrip.Observe(rtt.Seconds())
+}
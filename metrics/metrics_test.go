@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	WebsocketConnects.WithLabelValues("connected").Inc()
+	RecordingBytes.WithLabelValues("bob").Add(1024)
+	rtmpObserver{}.PingRoundTrip(20 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`mfc_websocket_connects_total{result="connected"} 1`,
+		`mfc_recording_bytes_total{model="bob"} 1024`,
+		`mfc_rtmp_ping_round_trip_seconds_count 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics without %s", want)
+		}
+	}
+}
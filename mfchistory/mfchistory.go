@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomfc/history"
+	"gomfc/models"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -db file [flags] model|uid...\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(flag.CommandLine.Output(), "Prints the online sessions and their statistics from the history kept by spy -history.")
+	flag.PrintDefaults()
+}
+
+func lookup(store *history.Store, model string) (uid uint64, err error) {
+	uid, err = store.Lookup(model)
+	if err == history.ErrUnknownModel {
+		if parsed, parseErr := strconv.ParseUint(model, 10, 64); parseErr == nil {
+			return parsed, nil
+		}
+	}
+	return
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func printModel(store *history.Store, model string, from, to time.Time, showSessions bool) (err error) {
+	uid, err := lookup(store, model)
+	if err != nil {
+		return
+	}
+	sessions, err := store.Sessions(uid, from, to)
+	if err != nil {
+		return
+	}
+	name := model
+	status := "unknown"
+	if last, err := store.Last(uid); err == nil {
+		name = last.Name
+		if verbose, ok := models.StatusVerbose[last.Vs]; ok {
+			status = verbose
+		}
+		status += " since " + last.Time.Format("2006-01-02 15:04")
+	}
+	fmt.Printf("%s (uid %d), %s\n", name, uid, status)
+	if showSessions {
+		for _, session := range sessions {
+			end := session.End.Format("2006-01-02 15:04")
+			if session.Open {
+				end = "now"
+			}
+			fmt.Printf("  %s - %s  %s\n", session.Start.Format("2006-01-02 15:04"), end, formatDuration(session.Duration()))
+		}
+	}
+	stats, err := store.Stats(uid, from, to)
+	if err != nil {
+		return
+	}
+	fmt.Printf("  sessions: %d, online: %s, average: %s, longest: %s\n", stats.Sessions,
+		formatDuration(stats.Online), formatDuration(stats.AverageSession), formatDuration(stats.LongestSession))
+	if stats.TypicalStartHour >= 0 {
+		var hours []string
+		for hour, count := range stats.StartHours {
+			if count > 0 {
+				hours = append(hours, fmt.Sprintf("%02d:00 x%d", hour, count))
+			}
+		}
+		fmt.Printf("  typical start hour: %02d:00 (%s)\n", stats.TypicalStartHour, strings.Join(hours, ", "))
+	}
+	return
+}
+
+func main() {
+	dbFile := flag.String("db", "", "history file written by spy -history")
+	days := flag.Int("days", 7, "number of last days to query")
+	showSessions := flag.Bool("sessions", true, "print every session")
+	flag.Usage = usage
+	flag.Parse()
+	if *dbFile == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	store, err := history.OpenReadOnly(*dbFile)
+	if history.IsLocked(err) {
+		fmt.Println("The history is recorded by spy, reading its last snapshot.")
+		store, err = history.OpenReadOnly(history.SnapshotPath(*dbFile))
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(-1)
+	}
+	to := time.Now()
+	from := to.AddDate(0, 0, -*days)
+	exitCode := 0
+	for _, model := range flag.Args() {
+		if err := printModel(store, model, from, to, *showSessions); err != nil {
+			fmt.Printf("%s: %s\n", model, err)
+			exitCode = -1
+		}
+	}
+	store.Close()
+	os.Exit(exitCode)
+}
@@ -1,11 +1,9 @@
 package models
 
 import (
-	"regexp"
-	"net/url"
-	"encoding/json"
 	"errors"
-	"strings"
+
+	"gomfc/fcs"
 )
 
 const HDFlag int32 = 1024
@@ -76,41 +74,51 @@ func GetModelData(raw string) (mfcmodel MFCModel, err error) {
 	defer func() {
 		mfcmodel.SetStatus()
 	}()
-	var result string
-	result, err = url.QueryUnescape(raw)
-	if err != nil {
+	frames, err := fcs.ParseMessage(raw)
+	if err != nil && len(frames) == 0 {
+		err = ServiceInfoError
 		return
 	}
-	notFoundCase := regexp.MustCompile(`^\d+\s\d+\s\d+\s\d+\s\d+\s(\w+)$`)
-	notFoundMatch := notFoundCase.FindStringSubmatch(result)
-	if len(notFoundMatch) == 2 {
-		result = notFoundMatch[1]
-		if strings.Contains(result, "Guest") {
-			err = ServiceInfoError
-		} else {
-			err = NotFoundError
-			mfcmodel.Nm = result
-			mfcmodel.Exists = false
+	err = ServiceInfoError
+	for _, frame := range frames {
+		mfcmodel, err = FromFrame(frame)
+		if err != ServiceInfoError {
+			return
 		}
+	}
+	return
+}
+
+// FromFrame decodes the model data of a SESSIONSTATE, USERNAMELOOKUP or
+// DETAILS frame, ServiceInfoError is returned for other frames.
+func FromFrame(frame fcs.Frame) (mfcmodel MFCModel, err error) {
+	defer func() {
+		mfcmodel.SetStatus()
+	}()
+	switch frame.Type {
+	case fcs.FCTYPE_SESSIONSTATE, fcs.FCTYPE_USERNAMELOOKUP, fcs.FCTYPE_DETAILS:
+	default:
+		err = ServiceInfoError
 		return
 	}
-	CaseOne := regexp.MustCompile(`^\d+\s\d+\s\d+\s\d+\s\d+\s(\{.+\})$`)
-	CaseTwo := regexp.MustCompile(`^\d+\s\d+\s\d+\s\d+\s\d+\s(\{.+\})\d+\s\d+\s\d+\s\d+\s\d+\s(\{.+\})$`)
-	jsonStrings := CaseOne.FindStringSubmatch(result)
-	if len(jsonStrings) <= 1 {
-		jsonStrings = CaseTwo.FindStringSubmatch(result)
-		if len(jsonStrings) <= 1 {
-			err = ServiceInfoError
+	if !frame.IsJSON() {
+		if frame.Type == fcs.FCTYPE_USERNAMELOOKUP {
+			err = NotFoundError
+			mfcmodel.Nm = frame.Payload
+			mfcmodel.Exists = false
 			return
 		}
+		err = ServiceInfoError
+		return
 	}
-	result = jsonStrings[1]
-	if err = json.Unmarshal([]byte(result), &mfcmodel); err != nil {
+	var model MFCModel
+	if frame.Unmarshal(&model) != nil {
 		err = ServiceInfoError
 		return
 	}
+	mfcmodel = model
 	if mfcmodel.Nm != "" {
 		mfcmodel.Exists = true
 	}
-    return
-}
\ No newline at end of file
+	return
+}
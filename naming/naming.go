@@ -0,0 +1,137 @@
+// Package naming builds recording paths from templates.
+//
+// A template is a path with placeholders:
+//
+//	{model}    model name
+//	{uid}      model uid
+//	{camserv}  camera server
+//	{hd}       "hd" or "sd"
+//	{index}    segment index, 3 digits
+//	{unix}     start time, unix seconds
+//	{date}     start date, 2006-01-02
+//	{time}     start time, 15-04-05
+//	{YYYY} {MM} {DD} {hh} {mm} {ss}  parts of the start time
+//
+// Directories of the template, e.g. "{model}/{date}_{time}.flv", are
+// created when the path is reserved. Substituted values are sanitized, so
+// a model name can not produce a directory or an invalid file name.
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultTemplate = "{model}_{unix}.flv"
+const DefaultSegmentTemplate = "{model}_{unix}_{index}.flv"
+
+// maxAttempts limits the suffixes tried to find a free name.
+const maxAttempts = 1000
+
+var ErrNoFreeName = errors.New("naming: no free file name")
+
+// Vars are the values of the placeholders.
+type Vars struct {
+	Model   string
+	Uid     uint64
+	Camserv int32
+	HD      bool
+	Time    time.Time
+	Index   int
+}
+
+// Expand replaces the placeholders of the template, unknown placeholders
+// are kept as is.
+func Expand(template string, vars Vars) string {
+	t := vars.Time
+	hd := "sd"
+	if vars.HD {
+		hd = "hd"
+	}
+	return strings.NewReplacer(
+		"{model}", Sanitize(vars.Model),
+		"{uid}", strconv.FormatUint(vars.Uid, 10),
+		"{camserv}", strconv.FormatInt(int64(vars.Camserv), 10),
+		"{hd}", hd,
+		"{index}", fmt.Sprintf("%03d", vars.Index),
+		"{unix}", strconv.FormatInt(t.Unix(), 10),
+		"{date}", t.Format("2006-01-02"),
+		"{time}", t.Format("15-04-05"),
+		"{YYYY}", t.Format("2006"),
+		"{MM}", t.Format("01"),
+		"{DD}", t.Format("02"),
+		"{hh}", t.Format("15"),
+		"{mm}", t.Format("04"),
+		"{ss}", t.Format("05"),
+	).Replace(template)
+}
+
+// HasIndex reports whether the template has the segment index placeholder.
+func HasIndex(template string) bool {
+	return strings.Contains(template, "{index}")
+}
+
+// WithIndex returns the template with the segment index placeholder,
+// it is added before the extension when missing.
+func WithIndex(template string) string {
+	if HasIndex(template) {
+		return template
+	}
+	ext := filepath.Ext(template)
+	return strings.TrimSuffix(template, ext) + "_{index}" + ext
+}
+
+// Sanitize makes the value usable as a single file name: path separators,
+// characters reserved on Windows and control characters are replaced
+// with '_', trailing dots and spaces are removed.
+func Sanitize(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return '_'
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, value)
+	value = strings.TrimRight(value, ". ")
+	if value == "" {
+		value = "_"
+	}
+	return value
+}
+
+// Reserve expands the template, creates the directories and an empty file
+// which does not exist yet. When the path is taken "_1", "_2"... are added
+// before the extension. The returned path is the created file.
+func Reserve(template string, vars Vars) (path string, err error) {
+	path = Expand(template, vars)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 0; i < maxAttempts; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+		}
+		var f *os.File
+		f, err = os.OpenFile(candidate, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		path = candidate
+		err = f.Close()
+		return
+	}
+	return "", ErrNoFreeName
+}
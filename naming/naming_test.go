@@ -0,0 +1,82 @@
+package naming
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testVars = Vars{
+	Model:   "Test/Model:1",
+	Uid:     100500,
+	Camserv: 1544,
+	HD:      true,
+	Time:    time.Date(2019, 3, 7, 9, 5, 1, 0, time.Local),
+	Index:   7,
+}
+
+func TestExpand(t *testing.T) {
+	cases := []struct {
+		template string
+		expect   string
+	}{
+		{DefaultTemplate, "Test_Model_1_" + strconv.FormatInt(testVars.Time.Unix(), 10) + ".flv"},
+		{"{model}/{date}_{time}_{index}.mp4", "Test_Model_1/2019-03-07_09-05-01_007.mp4"},
+		{"{YYYY}{MM}{DD}-{hh}{mm}{ss}_{uid}_{camserv}_{hd}.flv", "20190307-090501_100500_1544_hd.flv"},
+		{"{unknown}.flv", "{unknown}.flv"},
+	}
+	for _, c := range cases {
+		if got := Expand(c.template, testVars); got != c.expect {
+			t.Errorf("Expand(%q) got: %q, expect: %q", c.template, got, c.expect)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := map[string]string{
+		"Model":       "Model",
+		"../etc":      ".._etc",
+		"a<b>c|d?e*f": "a_b_c_d_e_f",
+		"tab\tname. ": "tab_name",
+		"...":         "_",
+		"юникод_имя":  "юникод_имя",
+	}
+	for value, expect := range cases {
+		if got := Sanitize(value); got != expect {
+			t.Errorf("Sanitize(%q) got: %q, expect: %q", value, got, expect)
+		}
+	}
+}
+
+func TestWithIndex(t *testing.T) {
+	if got := WithIndex("{model}.flv"); got != "{model}_{index}.flv" {
+		t.Errorf("WithIndex got: %q", got)
+	}
+	if got := WithIndex("{index}/{model}.flv"); got != "{index}/{model}.flv" {
+		t.Errorf("WithIndex got: %q", got)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	dir, err := os.MkdirTemp("", "naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	template := filepath.Join(dir, "{model}", "{date}.flv")
+	expect := []string{"2019-03-07.flv", "2019-03-07_1.flv", "2019-03-07_2.flv"}
+	for _, name := range expect {
+		path, err := Reserve(template, testVars)
+		if err != nil {
+			t.Fatalf("Reserve error: %s", err)
+		}
+		if want := filepath.Join(dir, "Test_Model_1", name); path != want {
+			t.Errorf("Reserve got: %q, expect: %q", path, want)
+		}
+		if _, err = os.Stat(path); err != nil {
+			t.Errorf("reserved file: %s", err)
+		}
+	}
+}
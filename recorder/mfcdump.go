@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"bufio"
 	"strings"
+	"context"
+	"flag"
 
 	"github.com/go-errors/errors"
 
 	"gomfc/rtmpdump"
+	"gomfc/chat"
+	"gomfc/metrics"
 	"gomfc/models"
+	"gomfc/container"
+	"gomfc/shutdown"
 )
 
 func exitProgram(waitEnter bool) {
@@ -18,7 +24,7 @@ func exitProgram(waitEnter bool) {
 		exitCode = -1
 		e, _ := r.(error)
 		switch e {
-		case models.NoPublicStreams, models.NotFoundError:
+		case models.NoPublicStreams, models.NotFoundError, rtmpdump.NoRtmpServer, rtmpdump.NoHLSPlaylist:
 			fmt.Println(e)
 		default:
 			fmt.Println("Error:", e)
@@ -32,11 +38,39 @@ func exitProgram(waitEnter bool) {
 	os.Exit(exitCode)
 }
 
+func printEvents(events <-chan rtmpdump.SessionEvent) {
+	for event := range events {
+		switch event.Type {
+		case rtmpdump.EventBytesWritten:
+			fmt.Printf("\rFile size: %.2f MB (%d)", float32(event.Stats.BytesWritten)/1024/1024, event.Stats.BytesWritten)
+		case rtmpdump.EventStalled:
+			fmt.Println("\nNo data anymore, stream close")
+		case rtmpdump.EventStreamClosed:
+			fmt.Println("\nstream closed")
+		case rtmpdump.EventError:
+			if event.Err == context.Canceled {
+				fmt.Println("\nrecord cancelled")
+			}
+		}
+	}
+}
+
 func main() {
 	var waitEnter bool
 	var modelName string
 	var outFile string
-	if len(os.Args) == 1 {
+	maxDuration := flag.Duration("max-duration", 0, "start a new segment after the duration, e.g. 30m")
+	maxSize := flag.Int64("max-size", 0, "start a new segment after the size in megabytes")
+	outDir := flag.String("dir", "", "output directory, the streams folder near the executable by default")
+	nameTemplate := flag.String("name", "", "output path template inside the directory, e.g. {model}/{date}_{time}.flv")
+	relayAddr := flag.String("relay", "", "restream to local players on the address, e.g. :1935, play rtmp://localhost/live/<model>")
+	metricsAddr := flag.String("metrics", "", "serve the Prometheus metrics on the address, e.g. localhost:9100")
+	subtitles := flag.String("subtitles", "", "write the room chat next to the recordings in the formats: srt, vtt, ass, e.g. srt,vtt")
+	roomEvents := flag.Bool("events", false, "log the tips, topic and show state changes next to the recordings as JSON lines")
+	backendName := flag.String("backend", string(rtmpdump.BackendAuto), "record the streams over rtmp, hls or auto: rtmp with the fallback to hls")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
 		waitEnter = true
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter model name: ")
@@ -45,15 +79,72 @@ func main() {
 		modelName = strings.Replace(modelName, "\r", "", 1)
 	} else {
 		waitEnter = false
-		modelName = os.Args[1]
+		modelName = args[0]
 	}
 	defer exitProgram(waitEnter)
-	if len(os.Args) >= 3 {
-		outFile = os.Args[2]
+	if len(args) >= 2 {
+		outFile = args[1]
 	} else {
 		outFile = ""
 	}
-	err := rtmpdump.Record(modelName, outFile)
+	opts := rtmpdump.RecordOptions{
+		OutFile:  outFile,
+		Dir:      *outDir,
+		Template: *nameTemplate,
+		Segment:  container.SegmentLimits{
+			MaxDuration: *maxDuration,
+			MaxBytes:    *maxSize * 1024 * 1024,
+		},
+	}
+	if *relayAddr != "" {
+		relay, err := rtmpdump.NewRelay(*relayAddr)
+		if err != nil {
+			panic(err)
+		}
+		defer relay.Close()
+		opts.Relay = relay
+		fmt.Printf("Relay on %s, play rtmp://<host>/%s/<model>\n", *relayAddr, rtmpdump.RelayApp)
+	}
+	if *subtitles != "" {
+		formats, err := chat.ParseFormats(*subtitles)
+		if err != nil {
+			panic(err)
+		}
+		opts.Subtitles = formats
+	}
+	opts.RoomEvents = *roomEvents
+	backend, err := rtmpdump.ParseBackend(*backendName)
+	if err != nil {
+		panic(err)
+	}
+	opts.Backend = backend
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.ListenAndServe(*metricsAddr); err != nil {
+				fmt.Printf("Metrics: %s\n", err)
+			}
+		}()
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
+	ctx, cancel := shutdown.Context()
+	defer cancel()
+	session, err := rtmpdump.NewSessionWithOptions(ctx, modelName, opts)
+	if err == context.Canceled {
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Start record %q into file %s\n", modelName, session.Path)
+	err = session.Start(ctx)
+	if err != nil {
+		panic(err)
+	}
+	printEvents(session.Events())
+	err = session.Err()
+	if err == context.Canceled {
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
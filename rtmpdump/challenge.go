@@ -0,0 +1,163 @@
+package rtmpdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/zhangpeihao/goamf"
+
+	rtmp "gomfc/gortmp"
+)
+
+// DefaultChallengeTimeout limits the time of the challenge code.
+const DefaultChallengeTimeout = 5 * time.Second
+
+var (
+	ErrNoChallengeCode  = errors.New("loginResult without challenge code")
+	ErrChallengeTimeout = errors.New("challenge code timeout")
+)
+
+// ChallengeSolver computes the answer to the javascript challenge sent by
+// the video server in the loginResult command.
+type ChallengeSolver interface {
+	Solve(ctx context.Context, code string) (string, error)
+}
+
+// JSChallengeSolver runs the challenge code with goja. The code sees stubs
+// of the browser objects it checks: screen, document and navigator.
+type JSChallengeSolver struct {
+	Timeout      time.Duration
+	ScreenWidth  int
+	ScreenHeight int
+	Host         string
+}
+
+func NewJSChallengeSolver() *JSChallengeSolver {
+	return &JSChallengeSolver{
+		Timeout:      DefaultChallengeTimeout,
+		ScreenWidth:  1920,
+		ScreenHeight: 1080,
+		Host:         "www.myfreecams.com",
+	}
+}
+
+// Solve runs the code in a new vm, it is interrupted when the timeout
+// expires or the context is cancelled.
+func (s *JSChallengeSolver) Solve(ctx context.Context, code string) (result string, err error) {
+	vm := goja.New()
+	if err = s.sandbox(vm); err != nil {
+		return
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultChallengeTimeout
+	}
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt(ErrChallengeTimeout)
+	})
+	defer timer.Stop()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.Interrupt(ctx.Err())
+		case <-stop:
+		}
+	}()
+
+	value, err := vm.RunString(code)
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if cause, ok := interrupted.Value().(error); ok {
+				err = cause
+			}
+		}
+		return
+	}
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		err = fmt.Errorf("challenge code returned %v", value)
+		return
+	}
+	result = value.String()
+	return
+}
+
+func (s *JSChallengeSolver) sandbox(vm *goja.Runtime) (err error) {
+	screen := map[string]interface{}{
+		"width":       s.ScreenWidth,
+		"height":      s.ScreenHeight,
+		"availWidth":  s.ScreenWidth,
+		"availHeight": s.ScreenHeight,
+		"colorDepth":  24,
+	}
+	location := map[string]interface{}{
+		"host":     s.Host,
+		"hostname": s.Host,
+		"protocol": "https:",
+		"href":     "https://" + s.Host + "/",
+	}
+	document := map[string]interface{}{
+		"location": location,
+		"domain":   s.Host,
+	}
+	navigator := map[string]interface{}{
+		"userAgent": "Mozilla/5.0",
+		"language":  "en-US",
+	}
+	globals := map[string]interface{}{
+		"screen":    screen,
+		"document":  document,
+		"navigator": navigator,
+		"location":  location,
+	}
+	for name, value := range globals {
+		if err = vm.Set(name, value); err != nil {
+			return
+		}
+	}
+	return vm.Set("window", vm.GlobalObject())
+}
+
+// loginChallenge is the decoded loginResult command.
+type loginChallenge struct {
+	TransactionID uint32
+	Code          string
+}
+
+// readLoginChallenge finds the code in the values of the loginResult
+// command.
+func readLoginChallenge(cmd *rtmp.Command) (challenge loginChallenge, err error) {
+	challenge.TransactionID = cmd.TransactionID
+	for _, value := range cmd.Objects {
+		if challenge.Code = findChallengeCode(value); challenge.Code != "" {
+			return
+		}
+	}
+	err = ErrNoChallengeCode
+	return
+}
+
+func findChallengeCode(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return jsRegexp.FindString(v)
+	case amf.Object:
+		for _, item := range v {
+			if code := findChallengeCode(item); code != "" {
+				return code
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if code := findChallengeCode(item); code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
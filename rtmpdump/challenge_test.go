@@ -0,0 +1,71 @@
+package rtmpdump
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/goamf"
+
+	rtmp "gomfc/gortmp"
+)
+
+func TestJSChallengeSolver(t *testing.T) {
+	solver := NewJSChallengeSolver()
+	code := "(function(a){return a * (!!screen.width + !!screen.height + !!document.location.host)})(14)"
+	result, err := solver.Solve(context.Background(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "42" {
+		t.Errorf("result got: %s, expect: 42", result)
+	}
+	if _, err = solver.Solve(context.Background(), "(function(){"); err == nil {
+		t.Error("syntax error is not returned")
+	}
+}
+
+func TestJSChallengeSolverTimeout(t *testing.T) {
+	solver := NewJSChallengeSolver()
+	solver.Timeout = 50 * time.Millisecond
+	_, err := solver.Solve(context.Background(), "(function(){for(;;){}})()")
+	if err != ErrChallengeTimeout {
+		t.Errorf("error got: %v, expect: %v", err, ErrChallengeTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = NewJSChallengeSolver().Solve(ctx, "(function(){for(;;){}})()")
+	if err != context.Canceled {
+		t.Errorf("error got: %v, expect: %v", err, context.Canceled)
+	}
+}
+
+func TestReadLoginChallenge(t *testing.T) {
+	code := "(function(a){return a+1})(1)"
+	buf := new(bytes.Buffer)
+	amf.WriteString(buf, loginResultCMD)
+	amf.WriteDouble(buf, 7)
+	amf.WriteNull(buf)
+	amf.WriteString(buf, "var x = "+code+";")
+	cmd, err := rtmp.ReadCommand(rtmp.NewMessage(rtmp.CS_ID_COMMAND, rtmp.COMMAND_AMF0, 0, 0, buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := readLoginChallenge(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.TransactionID != 7 {
+		t.Errorf("transaction id got: %d, expect: 7", challenge.TransactionID)
+	}
+	if challenge.Code != code {
+		t.Errorf("code got: %q, expect: %q", challenge.Code, code)
+	}
+
+	cmd.Objects = cmd.Objects[:1]
+	if _, err = readLoginChallenge(cmd); err != ErrNoChallengeCode {
+		t.Errorf("error got: %v, expect: %v", err, ErrNoChallengeCode)
+	}
+}
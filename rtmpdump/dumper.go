@@ -2,28 +2,23 @@ package rtmpdump
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 	"regexp"
-	"log"
 	"bytes"
 	"time"
 	"errors"
+	"sync"
+	"context"
 
 	rtmp "gomfc/gortmp"
 	"gomfc/models"
+	"gomfc/servers"
+	"gomfc/container"
+	"gomfc/container/codec"
 
-	"github.com/dop251/goja"
 	"github.com/zhangpeihao/goflv"
-	"github.com/zhangpeihao/goamf"
 )
 
 const gType = "DOWNLOAD"
-const rtmpPattern = "rtmp://video%d.myfreecams.com:1935/NxServer"
-const playpathPattern = "mp4:mfc_%d.f4v"
-const playpathPatternPure = "mfc_%d"
-const serverOffset = -500
-const serverOffsetPure = -34
 const roomOffset = 100000000
 const loginResultCMD = "loginResult"
 const chanReadyTimeout = 60 * time.Second
@@ -39,156 +34,207 @@ type RtmpConn struct {
 	RoomId uint64
 }
 
+// RtmpUrlData returns the stream of the model on the legacy video
+// server of its camserv, see RtmpUrlDataFor.
 func RtmpUrlData(m *models.MFCModel) (rtmpConnData *RtmpConn) {
-	var serverId int32
-	var playPath string
-	if m.U.Camserv + serverOffset > 0 {
-		serverId = m.U.Camserv + serverOffset
-	} else {
-		serverId = m.U.Camserv + serverOffsetPure
-	}
-	if m.IsHD() {
-		playPath = playpathPattern
-	} else {
-		playPath = playpathPatternPure
-	}
+	return RtmpUrlDataFor(m, servers.Fallback(m.U.Camserv))
+}
+
+// RtmpUrlDataFor returns the stream of the model on the server, see
+// servers.Config.Resolve.
+func RtmpUrlDataFor(m *models.MFCModel, server servers.Server) (rtmpConnData *RtmpConn) {
+	roomId := m.Uid + roomOffset
 	rtmpConnData = &RtmpConn{
-		ServerUrl: fmt.Sprintf(rtmpPattern, serverId),
+		ServerUrl: server.RTMPURL(),
 		SessionId: m.Sid,
 		ModelId: m.Uid,
-		RoomId: m.Uid + roomOffset,
-		Playpath: fmt.Sprintf(playPath, m.Uid + roomOffset,),
+		RoomId: roomId,
+		Playpath: server.Playpath(roomId, m.IsHD()),
 	}
 	return
 }
 
 type MfcRtmpHandler struct {
-	*flv.File
+	sync.Mutex
+	container.Writer
+	normalizer *container.Normalizer
 	OutBountStreamChan chan rtmp.OutboundStream
-	dataSize int64
-	streamReadyChan chan struct{}
-	streamCloseChan chan struct{}
+	sessionStats SessionStats
+	writeErr error
+	solver ChallengeSolver
+	solverCtx context.Context
+	streamReadyChan chan error
+	// streamCloseChan receives nil when the stream is closed
+	streamCloseChan chan error
 }
 
 func (handler *MfcRtmpHandler) OnStatus(conn rtmp.OutboundConn) {}
 
 func (handler *MfcRtmpHandler) OnClosed(conn rtmp.Conn) {
-	handler.streamCloseChan <- struct{}{}
+	select {
+	case handler.streamCloseChan <- nil:
+	default:
+	}
+}
+
+// detach stops writing into the file, messages received after
+// it are dropped.
+func (handler *MfcRtmpHandler) detach() {
+	handler.Lock()
+	defer handler.Unlock()
+	handler.Writer = nil
+}
+
+func (handler *MfcRtmpHandler) start() {
+	handler.Lock()
+	defer handler.Unlock()
+	handler.sessionStats.StartTime = time.Now()
+}
+
+func (handler *MfcRtmpHandler) stats() SessionStats {
+	handler.Lock()
+	defer handler.Unlock()
+	return handler.sessionStats
+}
+
+// position returns the position in the recording of the current moment,
+// ok is false before the first video tag.
+func (handler *MfcRtmpHandler) position() (position time.Duration, ok bool) {
+	handler.Lock()
+	defer handler.Unlock()
+	if handler.sessionStats.VideoTags == 0 || handler.normalizer == nil {
+		return
+	}
+	position = time.Duration(handler.normalizer.Last()) * time.Millisecond + time.Since(handler.sessionStats.LastDataTime)
+	return position, true
+}
+
+// writeError returns the first error of the writer.
+func (handler *MfcRtmpHandler) writeError() error {
+	handler.Lock()
+	defer handler.Unlock()
+	return handler.writeErr
 }
 
 func (handler *MfcRtmpHandler) OnReceived(conn rtmp.Conn, message *rtmp.Message) {
-	msgAsString := message.Buf.String()
-	if strings.Contains(msgAsString, loginResultCMD) {
-		num, err := amf.ReadDouble(bytes.NewReader(message.Buf.Bytes()[14:23]))
-		if err != nil {
-			log.Fatal(err)
-		}
-		jsCode := jsRegexp.FindString(msgAsString)
-		jsCode = strings.Replace(jsCode, "!!screen.width", "1", 1)
-		jsCode = strings.Replace(jsCode, "!!screen.height", "1", 1)
-		jsCode = strings.Replace(jsCode, "!!document.location.host", "1", 1)
-		vm := goja.New()
-		v, err := vm.RunString(jsCode)
-		if err != nil {
-			log.Fatal(err)
-		}
-		challengeResult := v.ToString()
-
-		buf := new(bytes.Buffer)
-		cmd := &rtmp.Command{
-			IsFlex:        false,
-			Name:          "_result",
-			TransactionID: uint32(num),
-			Objects: []interface{}{nil, challengeResult},
-		}
-		err = cmd.Write(buf)
-		if err != nil {
-			log.Fatal(err)
-		}
-		msg := rtmp.NewMessage(rtmp.CS_ID_COMMAND, rtmp.COMMAND_AMF0, 0, 0, buf.Bytes())
-		conn.Send(msg)
-		handler.streamReadyChan <- struct{}{}
+	handler.writeTag(message.Type, message.Buf.Bytes(), message.AbsoluteTimestamp)
+}
+
+// writeTag writes the payload of an audio, video or metadata message,
+// the first error of the writer is kept for writeError.
+func (handler *MfcRtmpHandler) writeTag(messageType uint8, payload []byte, timestamp uint32) {
+	handler.Lock()
+	defer handler.Unlock()
+	if handler.Writer == nil {
+		return
 	}
-	switch message.Type {
+	var err error
+	switch messageType {
 	case rtmp.VIDEO_TYPE:
-		if handler.File != nil {
-			handler.WriteVideoTag(message.Buf.Bytes(), message.AbsoluteTimestamp)
-		}
-		handler.dataSize += int64(message.Buf.Len())
+		err = handler.WriteVideo(payload, timestamp)
+		handler.sessionStats.VideoTags++
 	case rtmp.AUDIO_TYPE:
-		if handler.File != nil {
-			handler.WriteAudioTag(message.Buf.Bytes(), message.AbsoluteTimestamp)
+		err = handler.WriteAudio(payload, timestamp)
+		handler.sessionStats.AudioTags++
+	case rtmp.DATA_AMF0:
+		if !codec.IsMetaData(payload) {
+			return
 		}
-		handler.dataSize += int64(message.Buf.Len())
+		err = handler.WriteMeta(payload, timestamp)
+	default:
+		return
 	}
+	if err != nil && handler.writeErr == nil {
+		handler.writeErr = err
+	}
+	handler.sessionStats.BytesWritten += int64(len(payload))
+	handler.sessionStats.LastTimestamp = timestamp
+	handler.sessionStats.LastDataTime = time.Now()
 }
 
-func (handler *MfcRtmpHandler) OnReceivedRtmpCommand(conn rtmp.Conn, command *rtmp.Command) {}
+// answerChallenge sends the result of the challenge code, the server
+// accepts the stream creation after it.
+func (handler *MfcRtmpHandler) answerChallenge(conn rtmp.Conn, challenge loginChallenge) (err error) {
+	solver := handler.solver
+	if solver == nil {
+		solver = NewJSChallengeSolver()
+	}
+	ctx := handler.solverCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	challengeResult, err := solver.Solve(ctx, challenge.Code)
+	if err != nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	cmd := &rtmp.Command{
+		IsFlex:        false,
+		Name:          "_result",
+		TransactionID: challenge.TransactionID,
+		Objects: []interface{}{nil, challengeResult},
+	}
+	err = cmd.Write(buf)
+	if err != nil {
+		return
+	}
+	msg := rtmp.NewMessage(rtmp.CS_ID_COMMAND, rtmp.COMMAND_AMF0, 0, 0, buf.Bytes())
+	return conn.Send(msg)
+}
+
+func (handler *MfcRtmpHandler) OnReceivedRtmpCommand(conn rtmp.Conn, command *rtmp.Command) {
+	switch command.Name {
+	case loginResultCMD:
+		challenge, err := readLoginChallenge(command)
+		if err == nil {
+			err = handler.answerChallenge(conn, challenge)
+		}
+		select {
+		case handler.streamReadyChan <- err:
+		default:
+		}
+	}
+}
 
 func (handler *MfcRtmpHandler) OnStreamCreated(conn rtmp.OutboundConn, stream rtmp.OutboundStream) {
 	handler.OutBountStreamChan <- stream
 }
 
-func waitForCreateStreamReady(streamReadyChan chan struct{}, timeout time.Duration) (err error) {
+func waitForCreateStreamReady(ctx context.Context, streamReadyChan chan error, timeout time.Duration) (err error) {
 	select {
-	case _, ok := <-streamReadyChan:
+	case challengeErr, ok := <-streamReadyChan:
 		if !ok {
 			err = errors.New("streamReadyChan closed")
+		} else if challengeErr != nil {
+			err = fmt.Errorf("login challenge: %w", challengeErr)
 		}
 	case <-time.After(timeout):
 		err = errors.New("streamReadyChan wait timeout")
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
 	return err
 }
 
 func RecordStream(serverUrl string, roomId, modelId int64, playPath string, wsToken string, flv *flv.File) (err error){
-	createStreamChan := make(chan rtmp.OutboundStream)
-	mfcHandler := &MfcRtmpHandler{
-		File:flv,
-		OutBountStreamChan: createStreamChan,
-		dataSize: 0,
-		streamCloseChan: make(chan struct{}),
-		streamReadyChan: make(chan struct{}),
-	}
-	obConn, err := rtmp.Dial(serverUrl, mfcHandler, 100)
-	if err != nil {
-		return
-	}
-	defer obConn.Close()
-	err = obConn.Connect(wsToken, "", strconv.FormatInt(roomId, 10), gType, modelId, 0, "")
-	if err != nil {
-		return
-	}
-	err = waitForCreateStreamReady(mfcHandler.streamReadyChan, chanReadyTimeout)
-	if err != nil {
-		return
+	return RecordStreamContext(context.Background(), serverUrl, roomId, modelId, playPath, wsToken, flv)
+}
+
+// RecordStreamContext records until the stream ends or the context is cancelled.
+// On return nothing is written into the file anymore, so it can be closed.
+func RecordStreamContext(ctx context.Context, serverUrl string, roomId, modelId int64, playPath string, wsToken string, flv *flv.File) (err error){
+	conn := RtmpConn{
+		ServerUrl: serverUrl,
+		Playpath: playPath,
+		ModelId: uint64(modelId),
+		RoomId: uint64(roomId),
 	}
-	err = obConn.CreateStream()
+	session := NewRecordingSession(conn, wsToken, container.NewFLVWriter(flv))
+	err = session.Start(ctx)
 	if err != nil {
 		return
 	}
-	select {
-	case stream := <-createStreamChan:
-		stream.Play(playPath, nil, nil, nil)
-	case <-time.After(chanReadyTimeout):
-		return errors.New("create stream timeout")
-	}
-	lastGet := int64(0)
-	dataReceiveTicker := time.NewTicker(dataReceiveTimeout)
-	everySecond := time.NewTicker(time.Second)
-	for {
-		select {
-		case <- mfcHandler.streamCloseChan:
-			fmt.Println("\nstream closed")
-			return
-		case <- dataReceiveTicker.C:
-			if lastGet == mfcHandler.dataSize {
-				fmt.Println("\nNo data anymore, stream close")
-				return
-			}
-			lastGet = mfcHandler.dataSize
-		case <- everySecond.C:
-			fmt.Printf("\rFile size: %.2f MB (%d)", float32(mfcHandler.dataSize)/1024/1024, mfcHandler.dataSize)
-		}
+	for range session.Events() {
 	}
-}
\ No newline at end of file
+	return session.Err()
+}
@@ -0,0 +1,163 @@
+package rtmpdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/container"
+	"gomfc/container/mpegts"
+	rtmp "gomfc/gortmp"
+	"gomfc/hlsdump"
+)
+
+var NoHLSPlaylist = errors.New("the video server of the model has no public hls playlist")
+
+// Backend is the protocol a session records the stream with.
+type Backend string
+
+const (
+	BackendRTMP Backend = "rtmp"
+	BackendHLS  Backend = "hls"
+	// BackendAuto records over RTMP and falls back to HLS when the
+	// server has no RTMP streams or the RTMP stream does not start.
+	BackendAuto Backend = "auto"
+)
+
+// ParseBackend parses a backend name, the empty name is BackendRTMP.
+func ParseBackend(name string) (backend Backend, err error) {
+	switch backend = Backend(strings.ToLower(strings.TrimSpace(name))); backend {
+	case "":
+		return BackendRTMP, nil
+	case BackendRTMP, BackendHLS, BackendAuto:
+		return
+	}
+	return "", fmt.Errorf("unknown backend %q, expect rtmp, hls or auto", name)
+}
+
+// NewHLSRecordingSession creates a session recording the HLS playlist into
+// the writer, the segments are remuxed into flv tags, see mpegts.Demuxer.
+// The writer is not closed by the session.
+func NewHLSRecordingSession(playlist string, writer container.Writer) *RecordingSession {
+	s := NewRecordingSession(RtmpConn{}, "", writer)
+	s.backend = BackendHLS
+	s.playlist = playlist
+	return s
+}
+
+func (s *RecordingSession) recordBackend(ctx context.Context) (err error) {
+	switch s.backend {
+	case BackendHLS:
+		return s.recordHLS(ctx)
+	case BackendAuto:
+		err = s.record(ctx)
+		if err == nil || ctx.Err() != nil || s.playlist == "" || !s.handler.stats().StartTime.IsZero() {
+			return
+		}
+		log.Printf("%s: rtmp: %s, recording %s", s.ModelName, err, s.playlist)
+		return s.recordHLS(ctx)
+	}
+	return s.record(ctx)
+}
+
+// recordHLS downloads the segments of the playlist until it ends, stalls
+// or the context is cancelled. The demuxed tags go through the handler,
+// so the stats and the position are the same as with RTMP.
+func (s *RecordingSession) recordHLS(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	defer func() {
+		cancel()
+		<-finished
+	}()
+	handler := s.handler
+	demuxer := mpegts.NewDemuxer(hlsTags{handler})
+	fetcher := &hlsdump.Fetcher{URL: s.playlist, Client: s.HTTPClient}
+	started := make(chan struct{})
+	var startOnce sync.Once
+	closed := make(chan error, 1)
+	go func() {
+		defer close(finished)
+		fetchErr := fetcher.Run(ctx, func(segment hlsdump.Segment, data []byte) error {
+			startOnce.Do(func() {
+				handler.start()
+				close(started)
+			})
+			if s.copier != nil {
+				if err := s.copier.writeSegment(data); err != nil {
+					return err
+				}
+			}
+			if _, err := demuxer.Write(data); err != nil {
+				return err
+			}
+			return handler.writeError()
+		})
+		if fetchErr == nil || fetchErr == hlsdump.ErrStreamGone {
+			fetchErr = demuxer.Flush()
+		}
+		closed <- fetchErr
+	}()
+	select {
+	case <-started:
+	case err = <-closed:
+		if err == nil {
+			err = errors.New("hls playlist ended before the first segment")
+		}
+		return
+	case <-time.After(chanReadyTimeout):
+		return errors.New("hls playlist timeout")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.sendEvent(EventStarted, nil)
+	return s.monitor(ctx, closed, nil)
+}
+
+// hlsTags writes the demuxed HLS stream through the rtmp handler.
+type hlsTags struct {
+	handler *MfcRtmpHandler
+}
+
+func (t hlsTags) WriteVideo(payload []byte, timestamp uint32) error {
+	t.handler.writeTag(rtmp.VIDEO_TYPE, payload, timestamp)
+	return nil
+}
+
+func (t hlsTags) WriteAudio(payload []byte, timestamp uint32) error {
+	t.handler.writeTag(rtmp.AUDIO_TYPE, payload, timestamp)
+	return nil
+}
+
+// tsCopier is the writer of an HLS recording into a .ts file: the
+// segments are concatenated as they are downloaded, the demuxed tags
+// are only counted by the handler.
+type tsCopier struct {
+	out io.WriteCloser
+}
+
+func (c *tsCopier) writeSegment(data []byte) error {
+	_, err := c.out.Write(data)
+	return err
+}
+
+func (c *tsCopier) WriteVideo(payload []byte, timestamp uint32) error {
+	return nil
+}
+
+func (c *tsCopier) WriteAudio(payload []byte, timestamp uint32) error {
+	return nil
+}
+
+func (c *tsCopier) WriteMeta(payload []byte, timestamp uint32) error {
+	return nil
+}
+
+func (c *tsCopier) Close() error {
+	return c.out.Close()
+}
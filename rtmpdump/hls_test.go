@@ -0,0 +1,206 @@
+package rtmpdump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gomfc/container/codec"
+	"gomfc/container/mpegts"
+)
+
+// testSegment returns a transport stream segment with a key frame and an
+// audio frame, the segment of the index starts at index seconds.
+func testSegment(t *testing.T, index int) []byte {
+	out := &bytes.Buffer{}
+	m := mpegts.NewMuxer(out)
+	sps := []byte{0x67, 0x42, 0x00, 0x1f, 0xf2, 0x80, 0xa0, 0x0b, 0x72}
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0, 0x1f, 0xff, 0xe1, 0, byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, 0, byte(len(pps)))
+	config = append(config, pps...)
+	frame := []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 4, 0x65, 0x88, 0x84, byte(index + 1)}
+	timestamp := uint32(index * 1000)
+	steps := []error{
+		m.WriteVideo(config, timestamp),
+		m.WriteAudio([]byte{0xaf, 0, 0x12, 0x10}, timestamp),
+		m.WriteVideo(frame, timestamp),
+		m.WriteAudio([]byte{0xaf, 1, 1, 2, byte(index + 1)}, timestamp),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("segment %d step %d error: %s", index, i, err)
+		}
+	}
+	return out.Bytes()
+}
+
+// testHLSServer serves a master playlist and a live media playlist of
+// four segments: the first load lists 0-2, the second 1-3 and ends.
+func testHLSServer(segments [][]byte) *httptest.Server {
+	var mu sync.Mutex
+	loads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/NxServer/playlist.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000\nchunklist.m3u8\n")
+		case r.URL.Path == "/NxServer/chunklist.m3u8":
+			mu.Lock()
+			loads++
+			first := 0
+			if loads > 1 {
+				first = 1
+			}
+			mu.Unlock()
+			fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+			for i := first; i < first+3; i++ {
+				fmt.Fprintf(w, "#EXTINF:1.0,\nmedia_%d.ts\n", i)
+			}
+			if first > 0 {
+				fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+			}
+		case strings.HasPrefix(r.URL.Path, "/NxServer/media_"):
+			var index int
+			fmt.Sscanf(r.URL.Path, "/NxServer/media_%d.ts", &index)
+			w.Write(segments[index])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+type testTag struct {
+	video     bool
+	payload   []byte
+	timestamp uint32
+}
+
+type testWriter struct {
+	tags   []testTag
+	closed bool
+}
+
+func (w *testWriter) WriteVideo(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{true, append([]byte(nil), payload...), timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteAudio(payload []byte, timestamp uint32) error {
+	w.tags = append(w.tags, testTag{false, append([]byte(nil), payload...), timestamp})
+	return nil
+}
+
+func (w *testWriter) WriteMeta(payload []byte, timestamp uint32) error {
+	return nil
+}
+
+func (w *testWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func recordTestHLS(t *testing.T, session *RecordingSession) (events []SessionEventType) {
+	if err := session.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %s", err)
+	}
+	for event := range session.Events() {
+		if event.Type != EventBytesWritten {
+			events = append(events, event.Type)
+		}
+	}
+	if err := session.Err(); err != nil {
+		t.Fatalf("session error: %s", err)
+	}
+	return
+}
+
+func TestHLSRecordingSession(t *testing.T) {
+	var segments [][]byte
+	for i := 0; i < 4; i++ {
+		segments = append(segments, testSegment(t, i))
+	}
+	server := testHLSServer(segments)
+	defer server.Close()
+
+	writer := &testWriter{}
+	session := NewHLSRecordingSession(server.URL+"/NxServer/playlist.m3u8", writer)
+	events := recordTestHLS(t, session)
+	if len(events) != 2 || events[0] != EventStarted || events[1] != EventStreamClosed {
+		t.Errorf("events got: %v, expect: [started stream closed]", events)
+	}
+	var headers, keyFrames []uint32
+	for _, tag := range writer.tags {
+		if !tag.video {
+			continue
+		}
+		if codec.IsVideoSequenceHeader(tag.payload) {
+			headers = append(headers, tag.timestamp)
+		} else if codec.IsKeyFrame(tag.payload) {
+			keyFrames = append(keyFrames, tag.timestamp)
+			if index := tag.payload[len(tag.payload)-1]; int(index) != len(keyFrames) {
+				t.Errorf("key frame %d is of segment %d", len(keyFrames), index-1)
+			}
+		}
+	}
+	if len(headers) != 1 || fmt.Sprint(keyFrames) != "[0 1000 2000 3000]" {
+		t.Errorf("sequence headers got: %v, key frames got: %v, expect: 1 header, [0 1000 2000 3000]", headers, keyFrames)
+	}
+	if stats := session.Stats(); stats.VideoTags != 5 || stats.AudioTags != 5 {
+		t.Errorf("stats got: %d video, %d audio tags, expect: 5, 5", stats.VideoTags, stats.AudioTags)
+	}
+	if writer.closed {
+		t.Error("the writer of the caller is closed")
+	}
+}
+
+type testCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestHLSRecordingSessionCopy(t *testing.T) {
+	var segments [][]byte
+	for i := 0; i < 4; i++ {
+		segments = append(segments, testSegment(t, i))
+	}
+	server := testHLSServer(segments)
+	defer server.Close()
+
+	out := &testCloser{}
+	copier := &tsCopier{out: out}
+	session := NewHLSRecordingSession(server.URL+"/NxServer/playlist.m3u8", copier)
+	session.copier = copier
+	session.ownWriter = true
+	recordTestHLS(t, session)
+	if !bytes.Equal(out.Bytes(), bytes.Join(segments, nil)) {
+		t.Errorf("file got: %d bytes, expect the %d segments concatenated", out.Len(), len(segments))
+	}
+	if !out.closed {
+		t.Error("the file is not closed")
+	}
+	if stats := session.Stats(); stats.VideoTags != 5 {
+		t.Errorf("video tags got: %d, expect: 5", stats.VideoTags)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	for name, expect := range map[string]Backend{"": BackendRTMP, "HLS": BackendHLS, " auto": BackendAuto} {
+		if got, err := ParseBackend(name); err != nil || got != expect {
+			t.Errorf("ParseBackend(%q) got: %q, %v, expect: %q", name, got, err, expect)
+		}
+	}
+	if _, err := ParseBackend("dash"); err == nil {
+		t.Error("ParseBackend(dash) got no error")
+	}
+}
@@ -1,19 +1,27 @@
 package rtmpdump
 
 import (
+	"context"
 	"time"
 	"fmt"
 	"os"
 
-	"github.com/zhangpeihao/goflv"
-
+	"gomfc/chat"
+	"gomfc/container"
+	"gomfc/naming"
 	"gomfc/ws_client"
 	"gomfc/models"
+	"gomfc/servers"
 	"path/filepath"
+	"log"
+	"errors"
+	"strings"
 )
 const waitTimeout = 60 * time.Second
 const folder = "streams"
 
+var NoRtmpServer = errors.New("the video server of the model has no rtmp streams")
+
 func GetParentDir() (parentDir string, err error){
 	ex, err := os.Executable()
 	if err != nil {
@@ -31,59 +39,265 @@ func CreateFolder(parentDir, folderName string) error {
 	return os.MkdirAll(filepath.Join(parentDir, folderName), os.ModePerm)
 }
 
+// RecordOptions configures the output of a recording, the format is
+// selected by the extension, see container.Create.
+// OutFile is the output path template. When it is empty the path is
+// Template, naming.DefaultTemplate by default, in Dir, the streams folder
+// near the executable by default. With segment limits the recording is
+// split, the {index} placeholder is added to the template if missing.
+// Existing files are never overwritten, see naming.Reserve.
+// With Relay the recording is restreamed to the local players.
+// OnStart is called by RecordWithOptions when the stream starts, OnFinish
+// after a started recording is finished with its error.
+// Backend selects RTMP or HLS, see Backend. An HLS recording into a .ts
+// file without segment limits keeps the downloaded segments as they are.
+type RecordOptions struct {
+	OutFile    string
+	Dir        string
+	Template   string
+	Segment    container.SegmentLimits
+	Relay      *Relay
+	OnStart    func(session *RecordingSession)
+	OnFinish   func(session *RecordingSession, err error)
+	// Subtitles and RoomEvents are the room sidecars, see RecordingSession.
+	Subtitles  []chat.Format
+	RoomEvents bool
+	// Lookup resolves the models over a shared websocket session instead
+	// of logging in for every recording.
+	Lookup     *ws_client.LookupClient
+	Backend    Backend
+}
+
+func (opts RecordOptions) template() (template string, err error) {
+	if opts.OutFile != "" {
+		template = opts.OutFile
+	} else {
+		dir := opts.Dir
+		if dir == "" {
+			dir, err = GetParentDir()
+			if err != nil {
+				return
+			}
+			dir = filepath.Join(dir, folder)
+		}
+		template = opts.Template
+		if template == "" {
+			template = naming.DefaultTemplate
+			if opts.Segment.Enabled() {
+				template = naming.DefaultSegmentTemplate
+			}
+		}
+		template, err = filepath.Abs(filepath.Join(dir, template))
+		if err != nil {
+			return
+		}
+	}
+	if opts.Segment.Enabled() {
+		template = naming.WithIndex(template)
+	}
+	return
+}
+
 func Record(modelName string, outFile string) (err error) {
-	wsConn, err := ws_client.CreateConnection(modelName, false)
+	return RecordContext(context.Background(), modelName, outFile)
+}
+
+// RecordContext is Record stopped by cancelling the context,
+// the file recorded so far is closed properly.
+func RecordContext(ctx context.Context, modelName string, outFile string) (err error) {
+	return RecordWithOptions(ctx, modelName, RecordOptions{OutFile: outFile})
+}
+
+func RecordWithOptions(ctx context.Context, modelName string, opts RecordOptions) (err error) {
+	session, err := NewSessionWithOptions(ctx, modelName, opts)
 	if err != nil {
 		return
 	}
-	modelRaw, err := wsConn.ReadSingle(waitTimeout)
+	fmt.Printf("Start record %q into file %s\n", modelName, session.Path)
+	err = session.Start(ctx)
 	if err != nil {
 		return
 	}
-	wsToken := wsConn.GetTokenId()
-	model, err := models.GetModelData(modelRaw)
+	started := false
+	for event := range session.Events() {
+		if event.Type == EventStarted && !started {
+			started = true
+			if opts.OnStart != nil {
+				opts.OnStart(session)
+			}
+		}
+	}
+	err = session.Err()
+	if started && opts.OnFinish != nil {
+		opts.OnFinish(session, err)
+	}
+	return
+}
+
+// NewSession looks up the model and creates the output file, the file is
+// closed when the returned session is finished.
+func NewSession(ctx context.Context, modelName string, outFile string) (session *RecordingSession, err error) {
+	return NewSessionWithOptions(ctx, modelName, RecordOptions{OutFile: outFile})
+}
+
+// lookupModel returns the model data and the token of the websocket
+// session, the shared Lookup client is used when it is set. Otherwise the
+// token is valid while wsConn is open, it is closed after the recording.
+func (opts RecordOptions) lookupModel(ctx context.Context, modelName string) (model models.MFCModel, wsToken string, wsConn *ws_client.WSConnector, err error) {
+	waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	if opts.Lookup != nil {
+		if model, err = opts.Lookup.LookupByName(waitCtx, modelName); err != nil {
+			return
+		}
+		wsToken, err = opts.Lookup.TokenId()
+		return
+	}
+	wsConn, err = ws_client.CreateConnectionContext(ctx, modelName, false)
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			wsConn.Close()
+			wsConn = nil
+		}
+	}()
+	modelRaw, err := wsConn.ReadSingleContext(waitCtx)
+	if err != nil {
+		return
+	}
+	wsToken = wsConn.GetTokenId()
+	model, err = models.GetModelData(modelRaw)
+	return
+}
+
+// resolveServer returns the video server of the camserv from the server
+// config of the site, the legacy server when the config is not available.
+func resolveServer(ctx context.Context, modelName string, camserv int32) (server servers.Server) {
+	config, err := ws_client.DefaultClientConfig().ServerConfig(ctx)
+	if err != nil {
+		server = servers.Fallback(camserv)
+		log.Printf("%s: server config: %s, using %s", modelName, err, server.Host())
+		return
+	}
+	server = config.Resolve(camserv)
+	if server.Fallback {
+		log.Printf("%s: camserv %d is unknown, using %s", modelName, camserv, server.Host())
+	}
+	return
+}
+
+func NewSessionWithOptions(ctx context.Context, modelName string, opts RecordOptions) (session *RecordingSession, err error) {
+	model, wsToken, wsConn, err := opts.lookupModel(ctx, modelName)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil && wsConn != nil {
+			wsConn.Close()
+		}
+	}()
 	if !model.RecordEnable() {
 		err = models.NoPublicStreams
 		return
 	}
-	var flvPath string
-	if outFile == "" {
-		var parentDir string
-		parentDir, err = GetParentDir()
+	server := resolveServer(ctx, modelName, model.U.Camserv)
+	playlist, hasPlaylist := server.HLSURL(model.Uid + roomOffset)
+	backend := opts.Backend
+	if backend == "" {
+		backend = BackendRTMP
+	}
+	if backend == BackendAuto && !server.SupportsRTMP() {
+		backend = BackendHLS
+	}
+	switch {
+	case backend == BackendRTMP && !server.SupportsRTMP():
+		err = NoRtmpServer
+		return
+	case backend == BackendHLS && !hasPlaylist:
+		err = NoHLSPlaylist
+		return
+	}
+	template, err := opts.template()
+	if err != nil {
+		return
+	}
+	vars := naming.Vars{
+		Model:   modelName,
+		Uid:     model.Uid,
+		Camserv: model.U.Camserv,
+		HD:      model.IsHD(),
+		Time:    time.Now(),
+	}
+	var writer container.Writer
+	var copier *tsCopier
+	var segments *segmentLog
+	var outPath string
+	if opts.Segment.Enabled() {
+		vars.Index = 1
+		outPath, err = naming.Reserve(template, vars)
 		if err != nil {
 			return
 		}
-		err = CreateFolder(parentDir, folder)
+		segments = &segmentLog{}
+		segmenter := container.NewSegmenter(opts.Segment, func(index int) (container.Writer, error) {
+			segmentPath := outPath
+			if index > 1 {
+				segmentVars := vars
+				segmentVars.Index = index
+				var err error
+				segmentPath, err = naming.Reserve(template, segmentVars)
+				if err != nil {
+					return nil, err
+				}
+			}
+			segments.add(index, segmentPath)
+			return container.Create(segmentPath)
+		})
+		segmenter.OnSegment = segments.begin
+		writer = segmenter
+	} else {
+		outPath, err = naming.Reserve(template, vars)
 		if err != nil {
 			return
 		}
-		flvName := GetFLVName(modelName)
-		flvPath, err = filepath.Abs(filepath.Join(parentDir, folder, flvName))
-		if err != nil {
-			return
+		if backend == BackendHLS && strings.EqualFold(filepath.Ext(outPath), ".ts") {
+			var file *os.File
+			file, err = os.Create(outPath)
+			if err != nil {
+				return
+			}
+			copier = &tsCopier{out: file}
+			writer = copier
+		} else {
+			writer, err = container.Create(outPath)
+			if err != nil {
+				return
+			}
 		}
-	} else {
-		flvPath = outFile
 	}
-	flvFile, err := flv.CreateFile(flvPath)
-	if err != nil {
-		return
+	if opts.Relay != nil {
+		writer = opts.Relay.Tee(modelName, writer)
 	}
-	fmt.Printf("Start record %q into file %s\n", modelName, flvPath)
-	connData := RtmpUrlData(&model)
-	err = RecordStream(
-		connData.ServerUrl,
-		int64(connData.RoomId),
-		int64(connData.ModelId),
-		connData.Playpath,
-		wsToken,
-		flvFile,
-	)
-	if err != nil {
-		return
+	session = NewRecordingSession(*RtmpUrlDataFor(&model, server), wsToken, writer)
+	session.backend = backend
+	session.playlist = playlist
+	session.copier = copier
+	session.segments = segments
+	session.ModelName = modelName
+	session.Uid = model.Uid
+	session.Path = outPath
+	session.Subtitles = opts.Subtitles
+	session.RoomEvents = opts.RoomEvents
+	session.ownWriter = true
+	if wsConn != nil {
+		session.wsConn = wsConn
 	}
 	return
-}
\ No newline at end of file
+}
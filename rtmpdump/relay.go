@@ -0,0 +1,400 @@
+package rtmpdump
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/zhangpeihao/goamf"
+
+	"gomfc/container"
+	"gomfc/container/codec"
+	"gomfc/gortmp"
+)
+
+// RelayApp is the application players connect to:
+// rtmp://localhost/live/<model>.
+const RelayApp = "live"
+
+// relayQueueSize is the number of tags buffered for a slow player,
+// on overflow the player skips to the next key frame.
+const relayQueueSize = 1024
+
+// relayMaxGOP limits the cached group of pictures for streams
+// with rare key frames.
+const relayMaxGOP = 4096
+
+// relayRebase is the data type of the marker queued when the recording
+// is finished, the timestamps of the next recording are rebased on it.
+const relayRebase uint8 = 0
+
+var ErrRelayClosed = errors.New("relay is closed")
+
+type relayTag struct {
+	dataType  uint8
+	payload   []byte
+	timestamp uint32
+}
+
+// relayStream is the part of gortmp.InboundStream used by the relay.
+type relayStream interface {
+	SendAudioData(data []byte, timestamp uint32) error
+	SendVideoData(data []byte, timestamp uint32) error
+	SendData(dataType uint8, data []byte, timestamp uint32) error
+}
+
+type relaySubscriber struct {
+	stream  relayStream
+	queue   chan relayTag
+	waitKey bool
+	// rebase is set while the marker of a reset waits for a free place
+	// in the queue.
+	rebase bool
+}
+
+func newRelaySubscriber(stream relayStream) *relaySubscriber {
+	return &relaySubscriber{
+		stream: stream,
+		queue:  make(chan relayTag, relayQueueSize),
+	}
+}
+
+// push queues the tag without blocking the recording. The rebase marker
+// is never dropped, it is queued before the next tag when the queue is
+// full.
+func (sub *relaySubscriber) push(tag relayTag) {
+	if tag.dataType == relayRebase {
+		sub.rebase = true
+	} else if sub.waitKey {
+		if tag.dataType != gortmp.VIDEO_TYPE || !codec.IsKeyFrame(tag.payload) {
+			return
+		}
+		sub.waitKey = false
+	}
+	if sub.rebase {
+		select {
+		case sub.queue <- relayTag{dataType: relayRebase}:
+			sub.rebase = false
+		default:
+			sub.waitKey = true
+			return
+		}
+		if tag.dataType == relayRebase {
+			return
+		}
+	}
+	select {
+	case sub.queue <- tag:
+	default:
+		sub.waitKey = true
+	}
+}
+
+// run sends the queued tags with timestamps starting from zero
+// until the queue is closed or the player is gone. After a rebase marker
+// the timestamps of the next recording continue from the last sent one.
+func (sub *relaySubscriber) run() {
+	var base, offset, last uint32
+	started := false
+	failed := false
+	for tag := range sub.queue {
+		if tag.dataType == relayRebase {
+			started = false
+			offset = last
+			continue
+		}
+		if failed {
+			continue
+		}
+		if !started {
+			started = true
+			base = tag.timestamp
+		}
+		timestamp := offset
+		if tag.timestamp > base {
+			timestamp += tag.timestamp - base
+		}
+		last = timestamp
+		var err error
+		switch tag.dataType {
+		case gortmp.VIDEO_TYPE:
+			err = sub.stream.SendVideoData(tag.payload, timestamp)
+		case gortmp.AUDIO_TYPE:
+			err = sub.stream.SendAudioData(tag.payload, timestamp)
+		default:
+			err = sub.stream.SendData(tag.dataType, tag.payload, timestamp)
+		}
+		failed = err != nil
+	}
+}
+
+// relayHub keeps the state of a model stream needed by joining players:
+// the last onMetaData, the sequence headers and the tags since the last
+// key frame.
+type relayHub struct {
+	sync.Mutex
+	meta        []byte
+	videoHeader []byte
+	audioHeader []byte
+	gop         []relayTag
+	subscribers map[*relaySubscriber]bool
+}
+
+func newRelayHub() *relayHub {
+	return &relayHub{subscribers: make(map[*relaySubscriber]bool)}
+}
+
+func (hub *relayHub) write(tag relayTag) {
+	hub.Lock()
+	defer hub.Unlock()
+	switch tag.dataType {
+	case gortmp.DATA_AMF0:
+		hub.meta = tag.payload
+	case gortmp.VIDEO_TYPE:
+		switch {
+		case codec.IsVideoSequenceHeader(tag.payload):
+			hub.videoHeader = tag.payload
+		case codec.IsKeyFrame(tag.payload):
+			hub.gop = append(hub.gop[:0], tag)
+		case hub.gop != nil && len(hub.gop) < relayMaxGOP:
+			hub.gop = append(hub.gop, tag)
+		}
+	case gortmp.AUDIO_TYPE:
+		if codec.IsAudioSequenceHeader(tag.payload) {
+			hub.audioHeader = tag.payload
+		} else if hub.gop != nil && len(hub.gop) < relayMaxGOP {
+			hub.gop = append(hub.gop, tag)
+		}
+	}
+	for sub := range hub.subscribers {
+		sub.push(tag)
+	}
+}
+
+// subscribe queues the cached metadata, sequence headers and the latest
+// GOP, the player gets the live tags after them.
+func (hub *relayHub) subscribe(sub *relaySubscriber) {
+	hub.Lock()
+	defer hub.Unlock()
+	var start uint32
+	if len(hub.gop) > 0 {
+		start = hub.gop[0].timestamp
+	}
+	if hub.meta != nil {
+		sub.push(relayTag{gortmp.DATA_AMF0, hub.meta, start})
+	}
+	if hub.videoHeader != nil {
+		sub.push(relayTag{gortmp.VIDEO_TYPE, hub.videoHeader, start})
+	}
+	if hub.audioHeader != nil {
+		sub.push(relayTag{gortmp.AUDIO_TYPE, hub.audioHeader, start})
+	}
+	for _, tag := range hub.gop {
+		sub.push(tag)
+	}
+	hub.subscribers[sub] = true
+}
+
+func (hub *relayHub) unsubscribe(sub *relaySubscriber) {
+	hub.Lock()
+	defer hub.Unlock()
+	if hub.subscribers[sub] {
+		delete(hub.subscribers, sub)
+		close(sub.queue)
+	}
+}
+
+// reset drops the cache when the recording is finished, the players
+// stay subscribed and get the next recording of the model after a rebase
+// marker.
+func (hub *relayHub) reset() {
+	hub.Lock()
+	defer hub.Unlock()
+	hub.meta = nil
+	hub.videoHeader = nil
+	hub.audioHeader = nil
+	hub.gop = nil
+	for sub := range hub.subscribers {
+		sub.push(relayTag{dataType: relayRebase})
+	}
+}
+
+func (hub *relayHub) close() {
+	hub.Lock()
+	defer hub.Unlock()
+	for sub := range hub.subscribers {
+		delete(hub.subscribers, sub)
+		close(sub.queue)
+	}
+}
+
+// Relay is a local RTMP server restreaming the recordings to any number
+// of players. A player of rtmp://<addr>/live/<model> gets the stream of
+// the model recorded through the writer of Tee. Players may connect
+// before the recording starts, they wait for it.
+type Relay struct {
+	server *gortmp.Server
+	mu     sync.Mutex
+	hubs   map[string]*relayHub
+	conns  map[gortmp.InboundConn][]*relaySubscriber
+	closed bool
+}
+
+// NewRelay starts listening on addr, e.g. ":1935".
+func NewRelay(addr string) (relay *Relay, err error) {
+	relay = &Relay{
+		hubs:  make(map[string]*relayHub),
+		conns: make(map[gortmp.InboundConn][]*relaySubscriber),
+	}
+	relay.server, err = gortmp.NewServer("tcp", addr, relay)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Close stops the server, the players are not served anymore.
+func (relay *Relay) Close() error {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	if relay.closed {
+		return ErrRelayClosed
+	}
+	relay.closed = true
+	relay.server.Close()
+	for _, hub := range relay.hubs {
+		hub.close()
+	}
+	relay.conns = make(map[gortmp.InboundConn][]*relaySubscriber)
+	return nil
+}
+
+// Tee returns a writer writing to w and to the players of the model.
+// Errors are reported for w only, closing the writer closes w.
+func (relay *Relay) Tee(modelName string, w container.Writer) container.Writer {
+	return &relayWriter{Writer: w, hub: relay.hub(modelName)}
+}
+
+func (relay *Relay) hub(modelName string) *relayHub {
+	key := strings.ToLower(modelName)
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	hub, found := relay.hubs[key]
+	if !found {
+		hub = newRelayHub()
+		relay.hubs[key] = hub
+	}
+	return hub
+}
+
+// NewConnection accepts the players of the live application.
+func (relay *Relay) NewConnection(conn gortmp.InboundConn, connectReq *gortmp.Command, server *gortmp.Server) bool {
+	params, ok := connectReq.Objects[0].(amf.Object)
+	if !ok {
+		return false
+	}
+	app, _ := params["app"].(string)
+	if strings.Trim(app, "/") != RelayApp {
+		return false
+	}
+	conn.Attach(relay)
+	return true
+}
+
+func (relay *Relay) OnStatus(conn gortmp.InboundConn) {
+	status, _ := conn.Status()
+	if status != gortmp.INBOUND_CONN_STATUS_CLOSE {
+		return
+	}
+	relay.mu.Lock()
+	subscribers := relay.conns[conn]
+	delete(relay.conns, conn)
+	hubs := make([]*relayHub, 0, len(relay.hubs))
+	for _, hub := range relay.hubs {
+		hubs = append(hubs, hub)
+	}
+	relay.mu.Unlock()
+	for _, sub := range subscribers {
+		for _, hub := range hubs {
+			hub.unsubscribe(sub)
+		}
+	}
+}
+
+func (relay *Relay) OnStreamCreated(conn gortmp.InboundConn, stream gortmp.InboundStream) {
+	stream.Attach(relay)
+}
+
+func (relay *Relay) OnStreamClosed(conn gortmp.InboundConn, stream gortmp.InboundStream) {
+}
+
+func (relay *Relay) OnReceived(conn gortmp.Conn, message *gortmp.Message) {
+}
+
+func (relay *Relay) OnReceivedRtmpCommand(conn gortmp.Conn, command *gortmp.Command) {
+}
+
+func (relay *Relay) OnClosed(conn gortmp.Conn) {
+}
+
+// OnPlayStart subscribes the player to the model of the stream name.
+func (relay *Relay) OnPlayStart(stream gortmp.InboundStream) {
+	name := stream.StreamName()
+	if i := strings.IndexByte(name, '?'); i >= 0 {
+		name = name[:i]
+	}
+	hub := relay.hub(name)
+	sub := newRelaySubscriber(stream)
+	relay.mu.Lock()
+	if relay.closed {
+		relay.mu.Unlock()
+		return
+	}
+	relay.conns[stream.Conn()] = append(relay.conns[stream.Conn()], sub)
+	relay.mu.Unlock()
+	go sub.run()
+	hub.subscribe(sub)
+}
+
+func (relay *Relay) OnPublishStart(stream gortmp.InboundStream) {
+}
+
+func (relay *Relay) OnReceiveAudio(stream gortmp.InboundStream, on bool) {
+}
+
+func (relay *Relay) OnReceiveVideo(stream gortmp.InboundStream, on bool) {
+}
+
+type relayWriter struct {
+	container.Writer
+	hub *relayHub
+}
+
+func (w *relayWriter) WriteVideo(payload []byte, timestamp uint32) (err error) {
+	err = w.Writer.WriteVideo(payload, timestamp)
+	w.hub.write(relayTag{gortmp.VIDEO_TYPE, copyPayload(payload), timestamp})
+	return
+}
+
+func (w *relayWriter) WriteAudio(payload []byte, timestamp uint32) (err error) {
+	err = w.Writer.WriteAudio(payload, timestamp)
+	w.hub.write(relayTag{gortmp.AUDIO_TYPE, copyPayload(payload), timestamp})
+	return
+}
+
+func (w *relayWriter) WriteMeta(payload []byte, timestamp uint32) (err error) {
+	err = w.Writer.WriteMeta(payload, timestamp)
+	w.hub.write(relayTag{gortmp.DATA_AMF0, copyPayload(payload), timestamp})
+	return
+}
+
+func (w *relayWriter) Close() error {
+	w.hub.reset()
+	return w.Writer.Close()
+}
+
+// copyPayload detaches the payload from the message buffer, the relay
+// sends it after the writer returns.
+func copyPayload(payload []byte) []byte {
+	return append([]byte(nil), payload...)
+}
@@ -0,0 +1,164 @@
+package rtmpdump
+
+import (
+	"testing"
+
+	"gomfc/gortmp"
+)
+
+type sentTag struct {
+	dataType  uint8
+	payload   string
+	timestamp uint32
+}
+
+type testStream struct {
+	sent chan sentTag
+}
+
+func (s *testStream) SendAudioData(data []byte, timestamp uint32) error {
+	s.sent <- sentTag{gortmp.AUDIO_TYPE, string(data), timestamp}
+	return nil
+}
+
+func (s *testStream) SendVideoData(data []byte, timestamp uint32) error {
+	s.sent <- sentTag{gortmp.VIDEO_TYPE, string(data), timestamp}
+	return nil
+}
+
+func (s *testStream) SendData(dataType uint8, data []byte, timestamp uint32) error {
+	s.sent <- sentTag{dataType, string(data), timestamp}
+	return nil
+}
+
+var (
+	testVideoHeader = "\x17\x00\x00\x00\x00avc"
+	testAudioHeader = "\xaf\x00asc"
+	testMeta        = "\x02\x00\x0aonMetaData"
+	testKeyFrame    = "\x17\x01\x00\x00\x00key"
+	testInterFrame  = "\x27\x01\x00\x00\x00inter"
+	testAudioFrame  = "\xaf\x01aac"
+)
+
+func TestRelayHubJoin(t *testing.T) {
+	hub := newRelayHub()
+	write := func(dataType uint8, payload string, timestamp uint32) {
+		hub.write(relayTag{dataType, []byte(payload), timestamp})
+	}
+	write(gortmp.DATA_AMF0, testMeta, 0)
+	write(gortmp.VIDEO_TYPE, testVideoHeader, 0)
+	write(gortmp.AUDIO_TYPE, testAudioHeader, 0)
+	write(gortmp.VIDEO_TYPE, testKeyFrame, 1000)
+	write(gortmp.VIDEO_TYPE, testInterFrame, 1040)
+	write(gortmp.VIDEO_TYPE, testKeyFrame, 2000)
+	write(gortmp.AUDIO_TYPE, testAudioFrame, 2010)
+	write(gortmp.VIDEO_TYPE, testInterFrame, 2040)
+
+	stream := &testStream{sent: make(chan sentTag, 100)}
+	sub := newRelaySubscriber(stream)
+	hub.subscribe(sub)
+	write(gortmp.VIDEO_TYPE, testInterFrame, 2080)
+	done := make(chan struct{})
+	go func() {
+		sub.run()
+		close(done)
+	}()
+	hub.unsubscribe(sub)
+	<-done
+	close(stream.sent)
+
+	expect := []sentTag{
+		{gortmp.DATA_AMF0, testMeta, 0},
+		{gortmp.VIDEO_TYPE, testVideoHeader, 0},
+		{gortmp.AUDIO_TYPE, testAudioHeader, 0},
+		{gortmp.VIDEO_TYPE, testKeyFrame, 0},
+		{gortmp.AUDIO_TYPE, testAudioFrame, 10},
+		{gortmp.VIDEO_TYPE, testInterFrame, 40},
+		{gortmp.VIDEO_TYPE, testInterFrame, 80},
+	}
+	i := 0
+	for tag := range stream.sent {
+		if i >= len(expect) {
+			t.Fatalf("unexpected tag: %+v", tag)
+		}
+		if tag != expect[i] {
+			t.Errorf("tag %d got: %+v, expect: %+v", i, tag, expect[i])
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Errorf("tags got: %d, expect: %d", i, len(expect))
+	}
+}
+
+func TestRelaySubscriberOverflow(t *testing.T) {
+	sub := newRelaySubscriber(&testStream{})
+	for i := 0; i < relayQueueSize+10; i++ {
+		sub.push(relayTag{gortmp.VIDEO_TYPE, []byte(testInterFrame), uint32(i)})
+	}
+	if !sub.waitKey {
+		t.Fatal("overflow does not wait for a key frame")
+	}
+	<-sub.queue
+	sub.push(relayTag{gortmp.VIDEO_TYPE, []byte(testInterFrame), 0})
+	if !sub.waitKey {
+		t.Error("inter frame resumes the player")
+	}
+	sub.push(relayTag{gortmp.VIDEO_TYPE, []byte(testKeyFrame), 0})
+	if sub.waitKey {
+		t.Error("key frame does not resume the player")
+	}
+}
+
+func TestRelayHubReset(t *testing.T) {
+	hub := newRelayHub()
+	stream := &testStream{sent: make(chan sentTag, 100)}
+	sub := newRelaySubscriber(stream)
+	hub.subscribe(sub)
+	write := func(dataType uint8, payload string, timestamp uint32) {
+		hub.write(relayTag{dataType, []byte(payload), timestamp})
+	}
+	write(gortmp.VIDEO_TYPE, testKeyFrame, 5000)
+	write(gortmp.VIDEO_TYPE, testInterFrame, 5040)
+	hub.reset()
+	write(gortmp.VIDEO_TYPE, testKeyFrame, 100)
+	write(gortmp.VIDEO_TYPE, testInterFrame, 140)
+	done := make(chan struct{})
+	go func() {
+		sub.run()
+		close(done)
+	}()
+	hub.unsubscribe(sub)
+	<-done
+	close(stream.sent)
+
+	var timestamps []uint32
+	for tag := range stream.sent {
+		timestamps = append(timestamps, tag.timestamp)
+	}
+	expect := []uint32{0, 40, 40, 80}
+	if len(timestamps) != len(expect) {
+		t.Fatalf("timestamps got: %v, expect: %v", timestamps, expect)
+	}
+	for i := range expect {
+		if timestamps[i] != expect[i] {
+			t.Fatalf("timestamps got: %v, expect: %v", timestamps, expect)
+		}
+	}
+}
+
+func TestRelaySubscriberRebaseOverflow(t *testing.T) {
+	sub := newRelaySubscriber(&testStream{})
+	for i := 0; i < relayQueueSize; i++ {
+		sub.push(relayTag{gortmp.VIDEO_TYPE, []byte(testInterFrame), uint32(i)})
+	}
+	sub.push(relayTag{dataType: relayRebase})
+	if !sub.rebase {
+		t.Fatal("rebase marker of a full queue is dropped")
+	}
+	<-sub.queue
+	sub.push(relayTag{gortmp.VIDEO_TYPE, []byte(testKeyFrame), 0})
+	if sub.rebase {
+		t.Error("rebase marker is not queued before the key frame")
+	}
+}
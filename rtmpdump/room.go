@@ -0,0 +1,204 @@
+package rtmpdump
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"gomfc/chat"
+	"gomfc/ws_client"
+)
+
+// recordedSegment is a segment of a split recording, start is its position
+// on the timeline of the session, it is known once the segment has begun.
+type recordedSegment struct {
+	path  string
+	start time.Duration
+	begun bool
+}
+
+// segmentLog collects the segments of a split recording as they are
+// created, so the room sidecars can be split the same way.
+type segmentLog struct {
+	mu       sync.Mutex
+	segments []recordedSegment
+}
+
+// add is called when the segment of the index is created at the path.
+func (l *segmentLog) add(index int, path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index == len(l.segments)+1 {
+		l.segments = append(l.segments, recordedSegment{path: path, begun: index == 1})
+	}
+}
+
+// begin is the container.Segmenter hook, the first segment starts at the
+// beginning of the session.
+func (l *segmentLog) begin(index int, start uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index > 1 && index <= len(l.segments) {
+		l.segments[index-1].start = time.Duration(start) * time.Millisecond
+		l.segments[index-1].begun = true
+	}
+}
+
+// at returns the last begun segment starting before the offset, index is
+// 0 for a recording which is not split.
+func (l *segmentLog) at(offset time.Duration) (index int, segment recordedSegment) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, s := range l.segments {
+		if !s.begun || s.start > offset {
+			break
+		}
+		index, segment = i+1, s
+	}
+	return
+}
+
+func (l *segmentLog) list() []recordedSegment {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]recordedSegment(nil), l.segments...)
+}
+
+// eventsSidecar appends the room events to the events file of the
+// recording, or of the current segment of a split recording, as they are
+// received.
+type eventsSidecar struct {
+	session *RecordingSession
+	file    *chat.EventsFile
+	opened  bool
+	index   int
+	start   time.Duration
+}
+
+func (e *eventsSidecar) append(event chat.Event) {
+	s := e.session
+	index, segment := s.segments.at(event.Offset)
+	if !e.opened || index > e.index {
+		e.close()
+		e.opened = true
+		e.index, e.start = index, segment.start
+		path := s.Path
+		if index > 0 {
+			path = segment.path
+		}
+		file, err := chat.CreateEventsFile(path)
+		if err != nil {
+			log.Printf("%s: room events: %s", s.ModelName, err)
+			return
+		}
+		e.file = file
+	}
+	if e.file == nil {
+		return
+	}
+	event.Offset -= e.start
+	if event.Offset < 0 {
+		event.Offset = 0
+	}
+	if err := e.file.Append(event); err != nil {
+		log.Printf("%s: room events: %s", s.ModelName, err)
+	}
+}
+
+func (e *eventsSidecar) close() {
+	if e.file == nil {
+		return
+	}
+	if err := e.file.Close(); err != nil {
+		log.Printf("%s: room events: %s", e.session.ModelName, err)
+	} else {
+		log.Printf("%s: room events written to %s", e.session.ModelName, e.file.Path())
+	}
+	e.file = nil
+}
+
+// roomRecorder captures the room chat and events while the session records.
+type roomRecorder struct {
+	session *RecordingSession
+	cancel  context.CancelFunc
+	capture chan *chat.Capture
+	events  *eventsSidecar
+}
+
+// captureRoom joins the room of the model in background. The messages
+// and the events are placed at the position of the recording when they
+// are received, see RecordingSession.Position, on the timeline of the
+// whole session. The events are written as they are received, the
+// subtitles when the recording is finished, see finish.
+func (s *RecordingSession) captureRoom(ctx context.Context) *roomRecorder {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &roomRecorder{
+		session: s,
+		cancel:  cancel,
+		capture: make(chan *chat.Capture, 1),
+	}
+	if s.RoomEvents {
+		r.events = &eventsSidecar{session: s}
+	}
+	go func() {
+		capture, err := chat.StartCapture(ctx, ws_client.DefaultClientConfig(), s.ModelName, s.Uid, s.Position)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("%s: room capture: %s", s.ModelName, err)
+		}
+		if capture != nil && r.events != nil {
+			capture.SetEventHandler(r.events.append)
+		}
+		r.capture <- capture
+	}()
+	return r
+}
+
+// finish stops the capture, closes the events file and writes the
+// subtitles next to the recording. A split recording gets them next to
+// every segment with the offsets in the segment.
+func (r *roomRecorder) finish() {
+	r.cancel()
+	capture := <-r.capture
+	if capture == nil {
+		return
+	}
+	s := r.session
+	messages := capture.Stop()
+	if r.events != nil {
+		r.events.close()
+	}
+	if len(s.Subtitles) == 0 {
+		return
+	}
+	segments := s.segments.list()
+	if len(segments) == 0 {
+		r.writeSubtitles(s.Path, messages)
+		return
+	}
+	for i, segment := range segments {
+		var end time.Duration
+		if i+1 < len(segments) {
+			end = segments[i+1].start
+		}
+		r.writeSubtitles(segment.path, chat.SegmentMessages(messages, segment.start, end))
+	}
+}
+
+// writeSubtitles writes the subtitles of the recording at the path.
+func (r *roomRecorder) writeSubtitles(recording string, messages []chat.Message) {
+	s := r.session
+	paths, err := chat.WriteFiles(recording, s.Subtitles, messages)
+	if err != nil {
+		log.Printf("%s: subtitles: %s", s.ModelName, err)
+	}
+	for _, path := range paths {
+		log.Printf("%s: subtitles written to %s", s.ModelName, path)
+	}
+}
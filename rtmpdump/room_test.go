@@ -0,0 +1,52 @@
+package rtmpdump
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gomfc/chat"
+)
+
+func TestEventsSidecarSegments(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "model_1.flv"), filepath.Join(dir, "model_2.flv")
+	segments := &segmentLog{}
+	segments.add(1, first)
+	segments.begin(1, 0)
+	session := &RecordingSession{ModelName: "model", Path: first, segments: segments}
+	events := &eventsSidecar{session: session}
+
+	events.append(chat.Event{Offset: time.Second, Type: chat.EventTopic, Topic: "one"})
+	// the file is written before the recording is finished
+	if data, _ := ioutil.ReadFile(chat.EventsPath(first)); !strings.Contains(string(data), `"offset":1,`) {
+		t.Fatalf("events of segment 1 before the next one: %s", data)
+	}
+	segments.add(2, second)
+	events.append(chat.Event{Offset: 61 * time.Second, Type: chat.EventTopic, Topic: "two"})
+	segments.begin(2, 60000)
+	events.append(chat.Event{Offset: 62 * time.Second, Type: chat.EventTopic, Topic: "three"})
+	events.close()
+
+	expect := map[string][]string{
+		first:  {`"offset":1,`, `"offset":61,`},
+		second: {`"offset":2,`},
+	}
+	for path, offsets := range expect {
+		data, err := ioutil.ReadFile(chat.EventsPath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != len(offsets) {
+			t.Fatalf("%s: %s", filepath.Base(path), data)
+		}
+		for i, offset := range offsets {
+			if !strings.Contains(lines[i], offset) {
+				t.Errorf("%s line %d got: %s, expect %s", filepath.Base(path), i+1, lines[i], offset)
+			}
+		}
+	}
+}
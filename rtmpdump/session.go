@@ -0,0 +1,349 @@
+package rtmpdump
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gomfc/chat"
+	"gomfc/container"
+	rtmp "gomfc/gortmp"
+	"gomfc/metrics"
+)
+
+const eventChanCap = 100
+const progressInterval = time.Second
+const pingInterval = 30 * time.Second
+
+var SessionStartedError = errors.New("recording session already started")
+
+type SessionEventType int
+
+const (
+	EventStarted SessionEventType = iota
+	EventBytesWritten
+	EventStalled
+	EventStreamClosed
+	EventError
+)
+
+var sessionEventVerbose = map[SessionEventType]string{
+	EventStarted:      "started",
+	EventBytesWritten: "bytes written",
+	EventStalled:      "stalled",
+	EventStreamClosed: "stream closed",
+	EventError:        "error",
+}
+
+func (t SessionEventType) String() string {
+	return sessionEventVerbose[t]
+}
+
+// SessionStats is a snapshot of the recording progress.
+type SessionStats struct {
+	StartTime     time.Time
+	LastDataTime  time.Time
+	BytesWritten  int64
+	VideoTags     int64
+	AudioTags     int64
+	LastTimestamp uint32
+}
+
+// SessionEvent reports a change of the recording session, Err is set
+// for EventError only.
+type SessionEvent struct {
+	Type  SessionEventType
+	Time  time.Time
+	Stats SessionStats
+	Err   error
+}
+
+// RecordingSession records a single rtmp stream into a container writer,
+// or the HLS playlist of the stream, see NewHLSRecordingSession.
+// Events are sent on the channel returned by Events, they are dropped when
+// nobody reads them. The channel is closed when the session is finished.
+type RecordingSession struct {
+	ModelName string
+	Uid       uint64
+	Path      string
+	// Solver answers the login challenge of the video server,
+	// a JSChallengeSolver is used when it is nil.
+	Solver ChallengeSolver
+	// Subtitles are the formats of the room chat written next to Path,
+	// or next to every segment of a split recording, when the recording
+	// is finished, see captureRoom.
+	Subtitles []chat.Format
+	// RoomEvents writes the tips, topic and show state changes next to
+	// Path, or next to every segment, as they are received, see
+	// chat.EventsPath.
+	RoomEvents bool
+	// HTTPClient downloads the HLS playlist and the segments,
+	// http.DefaultClient is used when it is nil.
+	HTTPClient *http.Client
+
+	backend  Backend
+	playlist string
+	copier   *tsCopier
+	segments *segmentLog
+	conn     RtmpConn
+	wsToken  string
+	// wsConn is the websocket session of wsToken, it is closed when the
+	// recording is finished.
+	wsConn    io.Closer
+	handler   *MfcRtmpHandler
+	writer    container.Writer
+	ownWriter bool
+	events    chan SessionEvent
+	done      chan struct{}
+	mu        sync.Mutex
+	started   bool
+	cancel    context.CancelFunc
+	err       error
+}
+
+// NewRecordingSession creates a session writing into the writer, the writer
+// is not closed by the session.
+// The timestamps of the stream are normalized, see container.Normalizer.
+func NewRecordingSession(conn RtmpConn, wsToken string, writer container.Writer) *RecordingSession {
+	s := &RecordingSession{
+		conn:    conn,
+		wsToken: wsToken,
+		writer:  writer,
+		events:  make(chan SessionEvent, eventChanCap),
+		done:    make(chan struct{}),
+	}
+	normalizer := container.NewNormalizer(writer)
+	normalizer.OnDiscontinuity = s.logDiscontinuity
+	s.handler = &MfcRtmpHandler{
+		Writer:             normalizer,
+		normalizer:         normalizer,
+		OutBountStreamChan: make(chan rtmp.OutboundStream, 1),
+		streamCloseChan:    make(chan error, 1),
+		streamReadyChan:    make(chan error, 1),
+	}
+	return s
+}
+
+func (s *RecordingSession) logDiscontinuity(d container.Discontinuity) {
+	track := "audio"
+	if d.Video {
+		track = "video"
+	}
+	log.Printf("%s: %s timestamp %d jumps, moved by %d ms", s.ModelName, track, d.Timestamp, d.Shift)
+}
+
+func (s *RecordingSession) Events() <-chan SessionEvent {
+	return s.events
+}
+
+// Done is closed when the recording is finished.
+func (s *RecordingSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the recording has finished, it is nil for a stream
+// closed by the server or stalled.
+func (s *RecordingSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *RecordingSession) Stats() SessionStats {
+	return s.handler.stats()
+}
+
+// Position returns the position in the recording of the current moment:
+// the timestamp of the last written tag plus the time since it was
+// received. ok is false before the first video tag is written.
+func (s *RecordingSession) Position() (position time.Duration, ok bool) {
+	return s.handler.position()
+}
+
+// Start connects to the server and records in background until the stream
+// ends, Stop is called or the context is cancelled.
+func (s *RecordingSession) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return SessionStartedError
+	}
+	s.started = true
+	ctx, s.cancel = context.WithCancel(ctx)
+	go s.run(ctx)
+	return nil
+}
+
+// Stop finishes the recording and waits until the file is not written anymore.
+// A session stopped before Start only closes its file.
+func (s *RecordingSession) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		s.mu.Unlock()
+		if s.ownWriter && s.writer != nil {
+			s.writer.Close()
+		}
+		s.closeWS()
+		close(s.events)
+		close(s.done)
+		return
+	}
+	cancel := s.cancel
+	s.mu.Unlock()
+	cancel()
+	<-s.done
+}
+
+// Wait blocks until the recording is finished and returns Err.
+func (s *RecordingSession) Wait() error {
+	<-s.done
+	return s.Err()
+}
+
+func (s *RecordingSession) run(ctx context.Context) {
+	var room *roomRecorder
+	if len(s.Subtitles) > 0 || s.RoomEvents {
+		room = s.captureRoom(ctx)
+	}
+	err := s.recordBackend(ctx)
+	s.handler.detach()
+	s.closeWS()
+	if room != nil {
+		room.finish()
+	}
+	if err != nil {
+		s.sendEvent(EventError, err)
+	}
+	if s.ownWriter && s.writer != nil {
+		if closeErr := s.writer.Close(); err == nil && closeErr != nil {
+			err = closeErr
+			s.sendEvent(EventError, err)
+		}
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.cancel()
+	close(s.events)
+	close(s.done)
+}
+
+func (s *RecordingSession) closeWS() {
+	if s.wsConn != nil {
+		s.wsConn.Close()
+	}
+}
+
+func (s *RecordingSession) record(ctx context.Context) (err error) {
+	handler := s.handler
+	handler.solver = s.Solver
+	handler.solverCtx = ctx
+	obConn, err := rtmp.DialContext(ctx, s.conn.ServerUrl, handler, 100)
+	if err != nil {
+		return
+	}
+	defer obConn.Close()
+	err = obConn.Connect(s.wsToken, "", strconv.FormatUint(s.conn.RoomId, 10), gType, int64(s.conn.ModelId), 0, "")
+	if err != nil {
+		return
+	}
+	err = waitForCreateStreamReady(ctx, handler.streamReadyChan, chanReadyTimeout)
+	if err != nil {
+		return
+	}
+	err = obConn.CreateStream()
+	if err != nil {
+		return
+	}
+	select {
+	case stream := <-handler.OutBountStreamChan:
+		err = stream.Play(s.conn.Playpath, nil, nil, nil)
+		if err != nil {
+			return
+		}
+	case <-time.After(chanReadyTimeout):
+		return errors.New("create stream timeout")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	handler.start()
+	s.sendEvent(EventStarted, nil)
+	return s.monitor(ctx, handler.streamCloseChan, obConn.Conn().Ping)
+}
+
+// monitor reports the progress of a started recording until the stream
+// is closed, stalls or the context is cancelled. closed receives nil
+// when the stream ends and the error of a failed stream. ping keeps the
+// connection alive when it is not nil.
+func (s *RecordingSession) monitor(ctx context.Context, closed <-chan error, ping func()) (err error) {
+	handler := s.handler
+	metrics.ActiveRecordings.Inc()
+	defer metrics.ActiveRecordings.Dec()
+	recordingBytes := metrics.RecordingBytes.WithLabelValues(s.ModelName)
+
+	var lastWritten int64
+	defer func() {
+		recordingBytes.Add(float64(handler.stats().BytesWritten - lastWritten))
+	}()
+	lastCheck := handler.stats()
+	stallTicker := time.NewTicker(dataReceiveTimeout)
+	defer stallTicker.Stop()
+	progressTicker := time.NewTicker(progressInterval)
+	defer progressTicker.Stop()
+	var pings <-chan time.Time
+	if ping != nil {
+		pingTicker := time.NewTicker(pingInterval)
+		defer pingTicker.Stop()
+		pings = pingTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-closed:
+			if err != nil {
+				return
+			}
+			s.sendEvent(EventStreamClosed, nil)
+			return
+		case <-stallTicker.C:
+			stats := handler.stats()
+			if stats.BytesWritten == lastCheck.BytesWritten {
+				metrics.RecordingStalls.WithLabelValues(s.ModelName).Inc()
+				s.sendEvent(EventStalled, nil)
+				return
+			}
+			lastCheck = stats
+		case <-pings:
+			ping()
+		case <-progressTicker.C:
+			if err = handler.writeError(); err != nil {
+				return
+			}
+			if written := handler.stats().BytesWritten; written != lastWritten {
+				recordingBytes.Add(float64(written - lastWritten))
+				lastWritten = written
+				s.sendEvent(EventBytesWritten, nil)
+			}
+		}
+	}
+}
+
+func (s *RecordingSession) sendEvent(eventType SessionEventType, err error) {
+	event := SessionEvent{
+		Type:  eventType,
+		Time:  time.Now(),
+		Stats: s.handler.stats(),
+		Err:   err,
+	}
+	select {
+	case s.events <- event:
+	default:
+	}
+}
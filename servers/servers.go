@@ -0,0 +1,229 @@
+// Package servers parses the server tables of serverconfig.js and
+// resolves the video server of a camserv.
+//
+// The config maps the camserv of a model to a video host in several
+// tables, one per streaming platform:
+//
+//	wzobs_servers    {"1968": "video3068"}  OBS streams, RTMP and HLS
+//	h5video_servers  {"1544": "video1044"}  RTMP and HLS
+//	ngvideo_servers  {"1900": "video1100"}  HLS with a session key only
+//	video_servers    ["video1", ...]        legacy RTMP servers
+//
+// A camserv missing in the tables falls back to the legacy numbering,
+// video<camserv-500>, see Fallback.
+package servers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// DefaultDomain is the domain of the hosts of the config.
+const DefaultDomain = "myfreecams.com"
+
+const (
+	legacyOffset     = -500
+	legacyOffsetPure = -34
+	rtmpApp          = "NxServer"
+	rtmpPort         = 1935
+)
+
+var ErrNoWebsocketServers = errors.New("servers: no websocket servers")
+
+// Kind is the platform of a video server.
+type Kind string
+
+const (
+	KindWzObs  Kind = "wzobs"
+	KindH5     Kind = "h5video"
+	KindNg     Kind = "ngvideo"
+	KindLegacy Kind = "video"
+)
+
+// Config holds the server tables of serverconfig.js.
+type Config struct {
+	ChatServers      []string
+	WebsocketServers map[string]string
+	VideoServers     []string
+	H5VideoServers   map[int32]string
+	NgVideoServers   map[int32]string
+	WzObsServers     map[int32]string
+	// Domain of the hosts, DefaultDomain when empty
+	Domain string
+	// Skipped lists the malformed entries of the camserv tables, the
+	// rest of the config is usable without them.
+	Skipped []string
+}
+
+type rawConfig struct {
+	ChatServers      []string          `json:"chat_servers"`
+	WebsocketServers map[string]string `json:"websocket_servers"`
+	VideoServers     []string          `json:"video_servers"`
+	H5VideoServers   map[string]string `json:"h5video_servers"`
+	NgVideoServers   map[string]string `json:"ngvideo_servers"`
+	WzObsServers     map[string]string `json:"wzobs_servers"`
+}
+
+// Parse parses serverconfig.js. The camserv keys which are not numbers
+// are skipped and listed in Skipped, so one bad entry of a video table
+// does not break the websocket servers or the other camservs.
+func Parse(data []byte) (c *Config, err error) {
+	var raw rawConfig
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("servers: %s", err)
+	}
+	c = &Config{
+		ChatServers:      raw.ChatServers,
+		WebsocketServers: raw.WebsocketServers,
+		VideoServers:     raw.VideoServers,
+	}
+	tables := []struct {
+		name string
+		raw  map[string]string
+		dst  *map[int32]string
+	}{
+		{"h5video_servers", raw.H5VideoServers, &c.H5VideoServers},
+		{"ngvideo_servers", raw.NgVideoServers, &c.NgVideoServers},
+		{"wzobs_servers", raw.WzObsServers, &c.WzObsServers},
+	}
+	for _, table := range tables {
+		parsed := make(map[int32]string, len(table.raw))
+		for key, host := range table.raw {
+			camserv, parseErr := strconv.ParseInt(key, 10, 32)
+			if parseErr != nil {
+				c.Skipped = append(c.Skipped, fmt.Sprintf("%s[%q]", table.name, key))
+				continue
+			}
+			parsed[int32(camserv)] = host
+		}
+		*table.dst = parsed
+	}
+	return
+}
+
+// WebsocketServer returns a random websocket server, the rfc6455 ones
+// are preferred.
+func (c *Config) WebsocketServer() (server string, err error) {
+	var preferred, other []string
+	for name, protocol := range c.WebsocketServers {
+		if protocol == "rfc6455" {
+			preferred = append(preferred, name)
+		} else {
+			other = append(other, name)
+		}
+	}
+	if len(preferred) == 0 {
+		preferred = other
+	}
+	if len(preferred) == 0 {
+		err = ErrNoWebsocketServers
+		return
+	}
+	return preferred[rand.Intn(len(preferred))], nil
+}
+
+// Server is the video server of a camserv. Fallback is set when the
+// camserv is in none of the tables.
+type Server struct {
+	Camserv  int32
+	Name     string
+	Kind     Kind
+	Domain   string
+	Fallback bool
+}
+
+// Resolve returns the video server of the camserv. The tables are
+// searched in the order wzobs, h5video, ngvideo and the legacy servers,
+// an unknown camserv falls back to the legacy numbering.
+func (c *Config) Resolve(camserv int32) Server {
+	domain := c.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	tables := []struct {
+		kind  Kind
+		hosts map[int32]string
+	}{
+		{KindWzObs, c.WzObsServers},
+		{KindH5, c.H5VideoServers},
+		{KindNg, c.NgVideoServers},
+	}
+	for _, table := range tables {
+		if name, ok := table.hosts[camserv]; ok && name != "" {
+			return Server{Camserv: camserv, Name: name, Kind: table.kind, Domain: domain}
+		}
+	}
+	server := Fallback(camserv)
+	server.Domain = domain
+	if len(c.VideoServers) > 0 && containsName(c.VideoServers, server.Name) {
+		server.Fallback = false
+	}
+	return server
+}
+
+// Fallback returns the legacy server of the camserv, it is used without
+// a config.
+func Fallback(camserv int32) Server {
+	id := camserv + legacyOffset
+	if id <= 0 {
+		id = camserv + legacyOffsetPure
+	}
+	return Server{
+		Camserv:  camserv,
+		Name:     fmt.Sprintf("video%d", id),
+		Kind:     KindLegacy,
+		Domain:   DefaultDomain,
+		Fallback: true,
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, item := range names {
+		if strings.EqualFold(item, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host returns the host name of the server.
+func (s Server) Host() string {
+	return s.Name + "." + s.Domain
+}
+
+// SupportsRTMP reports whether the stream can be played over RTMP.
+func (s Server) SupportsRTMP() bool {
+	return s.Kind != KindNg
+}
+
+// RTMPURL returns the url of the RTMP application of the server.
+func (s Server) RTMPURL() string {
+	return fmt.Sprintf("rtmp://%s:%d/%s", s.Host(), rtmpPort, rtmpApp)
+}
+
+// Playpath returns the RTMP stream name of the room.
+func (s Server) Playpath(roomId uint64, hd bool) string {
+	switch {
+	case s.Kind == KindWzObs:
+		return fmt.Sprintf("mfc_a_%d", roomId)
+	case hd:
+		return fmt.Sprintf("mp4:mfc_%d.f4v", roomId)
+	}
+	return fmt.Sprintf("mfc_%d", roomId)
+}
+
+// HLSURL returns the url of the HLS playlist of the room, ok is false
+// for the servers which need a session key.
+func (s Server) HLSURL(roomId uint64) (url string, ok bool) {
+	switch s.Kind {
+	case KindWzObs:
+		return fmt.Sprintf("https://%s/%s/ngrp:mfc_a_%d.f4v_mobile/playlist.m3u8", s.Host(), rtmpApp, roomId), true
+	case KindNg:
+		return "", false
+	}
+	return fmt.Sprintf("https://%s/%s/ngrp:mfc_%d.f4v_mobile/playlist.m3u8", s.Host(), rtmpApp, roomId), true
+}
@@ -0,0 +1,144 @@
+package servers
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadFixture(t *testing.T, name string) *Config {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(%s) error: %s", name, err)
+	}
+	return config
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		fixture  string
+		camserv  int32
+		name     string
+		kind     Kind
+		fallback bool
+		rtmp     bool
+	}{
+		{"serverconfig.json", 1544, "video1044", KindH5, false, true},
+		{"serverconfig.json", 1545, "video3045", KindWzObs, false, true},
+		{"serverconfig.json", 1968, "video3068", KindWzObs, false, true},
+		{"serverconfig.json", 1900, "video1100", KindNg, false, false},
+		{"serverconfig.json", 1546, "video1046", KindLegacy, false, true},
+		{"serverconfig.json", 100, "video66", KindLegacy, false, true},
+		{"serverconfig.json", 2500, "video2000", KindLegacy, true, true},
+		{"legacy.json", 1544, "video1044", KindLegacy, false, true},
+		{"legacy.json", 35, "video1", KindLegacy, false, true},
+		{"legacy.json", 1545, "video1045", KindLegacy, true, true},
+	}
+	for _, c := range cases {
+		server := loadFixture(t, c.fixture).Resolve(c.camserv)
+		if server.Name != c.name || server.Kind != c.kind || server.Fallback != c.fallback || server.SupportsRTMP() != c.rtmp {
+			t.Errorf("%s: Resolve(%d) = %+v, want %s %s fallback %v rtmp %v",
+				c.fixture, c.camserv, server, c.name, c.kind, c.fallback, c.rtmp)
+		}
+	}
+}
+
+func TestStreamURLs(t *testing.T) {
+	config := loadFixture(t, "serverconfig.json")
+	const roomId = 100100500
+	cases := []struct {
+		camserv  int32
+		hd       bool
+		rtmp     string
+		playpath string
+		hls      string
+	}{
+		{1544, true, "rtmp://video1044.myfreecams.com:1935/NxServer", "mp4:mfc_100100500.f4v",
+			"https://video1044.myfreecams.com/NxServer/ngrp:mfc_100100500.f4v_mobile/playlist.m3u8"},
+		{1544, false, "rtmp://video1044.myfreecams.com:1935/NxServer", "mfc_100100500",
+			"https://video1044.myfreecams.com/NxServer/ngrp:mfc_100100500.f4v_mobile/playlist.m3u8"},
+		{1968, true, "rtmp://video3068.myfreecams.com:1935/NxServer", "mfc_a_100100500",
+			"https://video3068.myfreecams.com/NxServer/ngrp:mfc_a_100100500.f4v_mobile/playlist.m3u8"},
+		{1900, false, "rtmp://video1100.myfreecams.com:1935/NxServer", "mfc_100100500", ""},
+	}
+	for _, c := range cases {
+		server := config.Resolve(c.camserv)
+		if url := server.RTMPURL(); url != c.rtmp {
+			t.Errorf("%d: rtmp url %s, want %s", c.camserv, url, c.rtmp)
+		}
+		if playpath := server.Playpath(roomId, c.hd); playpath != c.playpath {
+			t.Errorf("%d: playpath %s, want %s", c.camserv, playpath, c.playpath)
+		}
+		if hls, ok := server.HLSURL(roomId); hls != c.hls || ok != (c.hls != "") {
+			t.Errorf("%d: hls url %s %v, want %s", c.camserv, hls, ok, c.hls)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	cases := []struct {
+		camserv int32
+		host    string
+	}{
+		{1544, "video1044.myfreecams.com"},
+		{501, "video1.myfreecams.com"},
+		{500, "video466.myfreecams.com"},
+		{100, "video66.myfreecams.com"},
+	}
+	for _, c := range cases {
+		if server := Fallback(c.camserv); server.Host() != c.host || !server.Fallback {
+			t.Errorf("Fallback(%d) = %+v, want %s", c.camserv, server, c.host)
+		}
+	}
+	config := &Config{Domain: "example.org"}
+	if host := config.Resolve(1544).Host(); host != "video1044.example.org" {
+		t.Errorf("host with a domain: %s", host)
+	}
+}
+
+func TestWebsocketServer(t *testing.T) {
+	cases := []struct {
+		fixture string
+		allowed map[string]bool
+	}{
+		{"serverconfig.json", map[string]bool{"xchat20": true, "xchat21": true}},
+		{"legacy.json", map[string]bool{"xchat1": true}},
+	}
+	for _, c := range cases {
+		config := loadFixture(t, c.fixture)
+		for i := 0; i < 20; i++ {
+			server, err := config.WebsocketServer()
+			if err != nil || !c.allowed[server] {
+				t.Fatalf("%s: WebsocketServer() = %s, %v", c.fixture, server, err)
+			}
+		}
+	}
+	if _, err := (&Config{}).WebsocketServer(); err != ErrNoWebsocketServers {
+		t.Errorf("empty config: %v", err)
+	}
+}
+
+func TestParseBadKey(t *testing.T) {
+	config := loadFixture(t, "badkey.json")
+	if len(config.Skipped) != 1 || config.Skipped[0] != `h5video_servers["video"]` {
+		t.Errorf("skipped: %q", config.Skipped)
+	}
+	if server, err := config.WebsocketServer(); err != nil || server != "xchat20" {
+		t.Errorf("WebsocketServer() = %s, %v", server, err)
+	}
+	if server := config.Resolve(1544); server.Fallback || server.Name != "video1044" {
+		t.Errorf("Resolve(1544) = %+v", server)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range [][]byte{[]byte("var config = {"), []byte(`{"video_servers": {}}`)} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Parse(%.30s) without error", data)
+		}
+	}
+}
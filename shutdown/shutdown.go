@@ -0,0 +1,30 @@
+// Package shutdown stops the command line tools gracefully: the context
+// of Context is cancelled on SIGINT or SIGTERM, so the recordings are
+// closed properly.
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Context returns a context cancelled on SIGINT or SIGTERM, the signals
+// are not handled anymore after it is cancelled.
+func Context() (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\nGot %s, stopping...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return
+}
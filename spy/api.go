@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gomfc/directory"
+)
+
+// modelSubscriber requests the states of the models from the server,
+// it is the supervised websocket connection.
+type modelSubscriber interface {
+	AddModel(modelName string) error
+	RemoveModel(modelName string)
+}
+
+// API is the HTTP/JSON control API of the spy:
+//
+//	GET    /models                watched models with their states and recordings
+//	POST   /models                watch the model, the body is {"name": "model"}
+//	DELETE /models/{name}         stop watching the model
+//	POST   /models/{name}/record  resume recording the model
+//	DELETE /models/{name}/record  stop the recording and pause recording the model
+//	GET    /recordings            active recordings
+//	GET    /errors?limit=n        recent errors, newest first
+//	GET    /directory             online models, filtered by the parameters
+//	                              status=away,private hd=true camserv=1544,1545 name=anna*
+//	GET    /directory/changes?since=2006-01-02T15:04:05Z
+//	                              models which came online and went offline since the moment
+//
+// Errors are returned as {"error": "message"}.
+type API struct {
+	watcher    *Watcher
+	subscriber modelSubscriber
+	errors     *ErrorLog
+	online     *directory.Directory
+}
+
+func NewAPI(watcher *Watcher, subscriber modelSubscriber, errors *ErrorLog, online *directory.Directory) *API {
+	return &API{
+		watcher:    watcher,
+		subscriber: subscriber,
+		errors:     errors,
+		online:     online,
+	}
+}
+
+// OnlineModel is a model of the directory.
+type OnlineModel struct {
+	Name    string    `json:"name"`
+	Uid     uint64    `json:"uid"`
+	Vs      uint64    `json:"vs"`
+	Status  string    `json:"status"`
+	Camserv int32     `json:"camserv"`
+	HD      bool      `json:"hd"`
+	Online  time.Time `json:"online"`
+	Updated time.Time `json:"updated"`
+}
+
+// DirectorySnapshot is the set of the online models at Time.
+type DirectorySnapshot struct {
+	Time   time.Time     `json:"time"`
+	Models []OnlineModel `json:"models"`
+}
+
+// DirectoryDiff holds the models which came online and went offline.
+type DirectoryDiff struct {
+	Online  []OnlineModel `json:"online"`
+	Offline []OnlineModel `json:"offline"`
+}
+
+func onlineModels(entries []directory.Entry) []OnlineModel {
+	list := make([]OnlineModel, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, OnlineModel{
+			Name:    entry.Nm,
+			Uid:     entry.Uid,
+			Vs:      entry.Vs,
+			Status:  entry.Status,
+			Camserv: entry.U.Camserv,
+			HD:      entry.IsHD(),
+			Online:  entry.Online,
+			Updated: entry.Updated,
+		})
+	}
+	return list
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "models":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, api.watcher.Models())
+		case http.MethodPost:
+			api.addModel(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(path) == 2 && path[0] == "models":
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		if err := api.watcher.RemoveModel(path[1]); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		api.subscriber.RemoveModel(path[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(path) == 3 && path[0] == "models" && path[2] == "record":
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			err = api.watcher.StartRecord(path[1])
+		case http.MethodDelete:
+			err = api.watcher.StopRecord(path[1])
+		default:
+			methodNotAllowed(w, http.MethodPost, http.MethodDelete)
+			return
+		}
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(path) == 1 && path[0] == "recordings":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeJSON(w, http.StatusOK, api.watcher.Recordings())
+	case len(path) == 1 && path[0] == "errors":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("bad limit %q", value))
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, api.errors.Recent(limit))
+	case len(path) == 1 && path[0] == "directory":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		filter, err := parseFilter(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		snapshot := api.online.Snapshot().Filter(filter)
+		writeJSON(w, http.StatusOK, DirectorySnapshot{Time: snapshot.Time, Models: onlineModels(snapshot.Models)})
+	case len(path) == 2 && path[0] == "directory" && path[1] == "changes":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad since: %s", err))
+			return
+		}
+		diff, err := api.online.Since(since)
+		if err == directory.ErrTruncated {
+			writeError(w, http.StatusGone, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, DirectoryDiff{Online: onlineModels(diff.Online), Offline: onlineModels(diff.Offline)})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+func (api *API) addModel(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || strings.ContainsAny(name, " /") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad model name %q", request.Name))
+		return
+	}
+	if err := api.watcher.AddModel(name); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if err := api.subscriber.AddModel(name); err != nil {
+		api.errors.Add("websocket", name, err)
+	}
+	writeJSON(w, http.StatusCreated, WatchedModel{Name: name})
+}
+
+// parseFilter returns the directory filter of the query parameters.
+func parseFilter(query url.Values) (filter directory.Filter, err error) {
+	for _, name := range splitList(query.Get("status")) {
+		vs, ok := directory.ParseStatus(name)
+		if !ok {
+			err = fmt.Errorf("bad status %q", name)
+			return
+		}
+		filter.Vs = append(filter.Vs, vs)
+	}
+	if value := query.Get("hd"); value != "" {
+		hd, parseErr := strconv.ParseBool(value)
+		if parseErr != nil {
+			err = fmt.Errorf("bad hd %q", value)
+			return
+		}
+		filter.HD = &hd
+	}
+	for _, value := range splitList(query.Get("camserv")) {
+		camserv, parseErr := strconv.ParseInt(value, 10, 32)
+		if parseErr != nil {
+			err = fmt.Errorf("bad camserv %q", value)
+			return
+		}
+		filter.Camserv = append(filter.Camserv, int32(camserv))
+	}
+	filter.Name = query.Get("name")
+	err = filter.Validate()
+	return
+}
+
+func splitList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+func statusOf(err error) int {
+	switch err {
+	case ErrNotWatched:
+		return http.StatusNotFound
+	case ErrAlreadyWatched:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gomfc/directory"
+	"gomfc/models"
+	"gomfc/rtmpdump"
+)
+
+type testSubscriber struct {
+	added   []string
+	removed []string
+}
+
+func (s *testSubscriber) AddModel(modelName string) error {
+	s.added = append(s.added, modelName)
+	return nil
+}
+
+func (s *testSubscriber) RemoveModel(modelName string) {
+	s.removed = append(s.removed, modelName)
+}
+
+func testRequest(t *testing.T, api *API, method, path, body string, wantStatus int, result interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, wantStatus, rec.Body)
+	}
+	if result != nil {
+		if err := json.NewDecoder(rec.Body).Decode(result); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := NewWatcher(ctx, []string{"Alice"}, 1, rtmpdump.RecordOptions{})
+	subscriber := &testSubscriber{}
+	errorLog := NewErrorLog(2)
+	api := NewAPI(watcher, subscriber, errorLog, directory.New())
+
+	testRequest(t, api, http.MethodPost, "/models", `{"name": "bob"}`, http.StatusCreated, nil)
+	testRequest(t, api, http.MethodPost, "/models", `{"name": "ALICE"}`, http.StatusConflict, nil)
+	testRequest(t, api, http.MethodPost, "/models", `{"name": "a/b"}`, http.StatusBadRequest, nil)
+	var watched []WatchedModel
+	testRequest(t, api, http.MethodGet, "/models", "", http.StatusOK, &watched)
+	if len(watched) != 2 || watched[0].Name != "Alice" || watched[1].Name != "bob" || watched[0].State != nil {
+		t.Fatalf("models: %+v", watched)
+	}
+	if len(subscriber.added) != 1 || subscriber.added[0] != "bob" {
+		t.Fatalf("subscribed: %v", subscriber.added)
+	}
+
+	testRequest(t, api, http.MethodDelete, "/models/alice/record", "", http.StatusNoContent, nil)
+	testRequest(t, api, http.MethodGet, "/models", "", http.StatusOK, &watched)
+	if !watched[0].Paused || watched[1].Paused {
+		t.Fatalf("paused: %+v", watched)
+	}
+	testRequest(t, api, http.MethodPost, "/models/alice/record", "", http.StatusNoContent, nil)
+	testRequest(t, api, http.MethodPost, "/models/carol/record", "", http.StatusNotFound, nil)
+	testRequest(t, api, http.MethodPut, "/models/alice/record", "", http.StatusMethodNotAllowed, nil)
+
+	testRequest(t, api, http.MethodDelete, "/models/bob", "", http.StatusNoContent, nil)
+	testRequest(t, api, http.MethodDelete, "/models/bob", "", http.StatusNotFound, nil)
+	if names := watcher.ModelNames(); len(names) != 1 || names[0] != "Alice" {
+		t.Fatalf("watched: %v", names)
+	}
+	if len(subscriber.removed) != 1 || subscriber.removed[0] != "bob" {
+		t.Fatalf("unsubscribed: %v", subscriber.removed)
+	}
+
+	var recordings []Recording
+	testRequest(t, api, http.MethodGet, "/recordings", "", http.StatusOK, &recordings)
+	if recordings == nil || len(recordings) != 0 {
+		t.Fatalf("recordings: %v", recordings)
+	}
+
+	for _, message := range []string{"first", "second", "third"} {
+		errorLog.Add("record", "alice", errors.New(message))
+	}
+	var entries []ErrorEntry
+	testRequest(t, api, http.MethodGet, "/errors", "", http.StatusOK, &entries)
+	if len(entries) != 2 || entries[0].Error != "third" || entries[1].Error != "second" {
+		t.Fatalf("errors: %+v", entries)
+	}
+	testRequest(t, api, http.MethodGet, "/errors?limit=1", "", http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Model != "alice" {
+		t.Fatalf("limited errors: %+v", entries)
+	}
+	testRequest(t, api, http.MethodGet, "/errors?limit=x", "", http.StatusBadRequest, nil)
+	testRequest(t, api, http.MethodGet, "/unknown", "", http.StatusNotFound, nil)
+
+	cancel()
+	watcher.Wait()
+}
+
+func TestAPIDirectory(t *testing.T) {
+	online := directory.New()
+	api := NewAPI(nil, &testSubscriber{}, NewErrorLog(1), online)
+	start := time.Now().Add(-time.Minute)
+	for i, name := range []string{"Anna", "annabelle", "Bella"} {
+		m := models.MFCModel{Lv: models.ModelLv, Nm: name, Uid: uint64(i + 1), Vs: models.IsOnline}
+		m.U.Camserv = int32(1544 + i)
+		online.Update(m, start)
+	}
+	online.Update(models.MFCModel{Lv: models.ModelLv, Nm: "Bella", Uid: 3, Vs: models.IsOff}, start.Add(time.Second))
+
+	var snapshot DirectorySnapshot
+	testRequest(t, api, http.MethodGet, "/directory?name=anna*&camserv=1545", "", http.StatusOK, &snapshot)
+	if len(snapshot.Models) != 1 || snapshot.Models[0].Name != "annabelle" || snapshot.Models[0].Uid != 2 {
+		t.Fatalf("directory: %+v", snapshot)
+	}
+	testRequest(t, api, http.MethodGet, "/directory?status=away", "", http.StatusOK, &snapshot)
+	if snapshot.Models == nil || len(snapshot.Models) != 0 {
+		t.Fatalf("away models: %+v", snapshot)
+	}
+	testRequest(t, api, http.MethodGet, "/directory?status=busy", "", http.StatusBadRequest, nil)
+	testRequest(t, api, http.MethodGet, "/directory?hd=maybe", "", http.StatusBadRequest, nil)
+	testRequest(t, api, http.MethodGet, "/directory?name=[a", "", http.StatusBadRequest, nil)
+
+	var diff DirectoryDiff
+	since := url.QueryEscape(start.Add(-time.Second).Format(time.RFC3339))
+	testRequest(t, api, http.MethodGet, "/directory/changes?since="+since, "", http.StatusOK, &diff)
+	if len(diff.Online) != 2 || len(diff.Offline) != 0 {
+		t.Fatalf("changes: %+v", diff)
+	}
+	testRequest(t, api, http.MethodGet, "/directory/changes", "", http.StatusBadRequest, nil)
+}
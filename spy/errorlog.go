@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const errorLogSize = 100
+
+// ErrorEntry is an error kept by ErrorLog, Source is the subsystem that
+// has failed: record, websocket, history or hook.
+type ErrorEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Model  string    `json:"model,omitempty"`
+	Error  string    `json:"error"`
+}
+
+// ErrorLog keeps the last errors, the oldest ones are dropped.
+type ErrorLog struct {
+	sync.Mutex
+	size    int
+	entries []ErrorEntry
+}
+
+func NewErrorLog(size int) *ErrorLog {
+	return &ErrorLog{size: size}
+}
+
+func (l *ErrorLog) Add(source, model string, err error) {
+	l.Lock()
+	defer l.Unlock()
+	l.entries = append(l.entries, ErrorEntry{
+		Time:   time.Now(),
+		Source: source,
+		Model:  model,
+		Error:  err.Error(),
+	})
+	if len(l.entries) > l.size {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-l.size:]...)
+	}
+}
+
+// Recent returns up to limit last errors, newest first. All kept errors
+// are returned when limit is not positive.
+func (l *ErrorLog) Recent(limit int) (entries []ErrorEntry) {
+	l.Lock()
+	defer l.Unlock()
+	if limit <= 0 || limit > len(l.entries) {
+		limit = len(l.entries)
+	}
+	entries = make([]ErrorEntry, 0, limit)
+	for i := len(l.entries) - 1; i >= len(l.entries)-limit; i-- {
+		entries = append(entries, l.entries[i])
+	}
+	return
+}
+
+// RecentErrors are the errors of the spy served by the API.
+var RecentErrors = NewErrorLog(errorLogSize)
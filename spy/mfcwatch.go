@@ -7,16 +7,33 @@ import (
 	"strings"
 	"time"
 	"sync"
+	"flag"
+	"context"
+	"net"
+	"net/http"
 
 	"github.com/go-errors/errors"
 
+	"gomfc/directory"
+	"gomfc/history"
+	"gomfc/hooks"
 	"gomfc/models"
 	"gomfc/ws_client"
+	"gomfc/container"
 	"gomfc/rtmpdump"
+	"gomfc/chat"
+	"gomfc/metrics"
+	"gomfc/shutdown"
 
 )
 
 const stateChanCap = 10000
+const defaultMaxRecords = 5
+const hooksCloseTimeout = 30 * time.Second
+
+// historyQueueSize is the number of the states waiting for the history
+// file, the states over it are dropped.
+const historyQueueSize = 10000
 
 type ModelState struct {
 	models.MFCModel
@@ -42,6 +59,19 @@ func (m *ModelMapType) Set(uid uint64, state ModelState) {
 	m.Data[uid] = state
 }
 
+// FindByName returns the state of the model, the name is compared
+// ignoring case.
+func (m *ModelMapType) FindByName(name string) (state ModelState, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, state = range m.Data {
+		if strings.EqualFold(state.Nm, name) {
+			return state, true
+		}
+	}
+	return ModelState{}, false
+}
+
 func (m *ModelMapType) SendState(state ModelState) (err error) {
 	select {
 	case m.StateChan <- state:
@@ -53,7 +83,18 @@ func (m *ModelMapType) SendState(state ModelState) (err error) {
 
 var ModelMap ModelMapType
 
-func stateHandle(modelName string) {
+// reconcileDelay is the time the room data of a new websocket session
+// takes, the models not sent by then are offline.
+const reconcileDelay = 30 * time.Second
+
+// OnlineModels is the directory of all online models streamed by the
+// room data subscription.
+var OnlineModels = directory.New()
+
+// stateHandle passes the states to the watcher, queues the history
+// of them when queue is not nil and fires the hooks when dispatcher
+// is not nil. The first state seen of every model is a transition too.
+func stateHandle(watcher *Watcher, queue *history.Queue, dispatcher *hooks.Dispatcher) {
 Loop:
 	for {
 		select {
@@ -61,20 +102,65 @@ Loop:
 			if !ok {
 				break Loop
 			}
-			if strings.ToLower(state.Nm) == strings.ToLower(modelName) {
-				for {
-					currentState, _ := ModelMap.Get(state.Uid)
-					if currentState.RecordEnable() {
-						rtmpdump.Record(modelName, "")
-					} else {
-						break
-					}
+			if queue != nil {
+				if err := queue.Add(history.FromModel(state.MFCModel, state.ChangeStateTime)); err != nil {
+					fmt.Printf("History of %q: %s\n", state.Nm, err)
+					RecentErrors.Add("history", state.Nm, err)
+				}
+			}
+			if dispatcher != nil {
+				if payload, ok := hooks.StatusPayload(state.MFCModel, state.ChangeStateTime); ok {
+					dispatcher.Fire(payload)
 				}
 			}
+			watcher.Dispatch(state)
 		}
 	}
 }
 
+// recordPayload returns the payload of the recording event of the session.
+func recordPayload(event hooks.Event, session *rtmpdump.RecordingSession, err error) hooks.Payload {
+	state, _ := ModelMap.Get(session.Uid)
+	stats := session.Stats()
+	payload := hooks.Payload{
+		Event:   event,
+		Model:   session.ModelName,
+		Uid:     session.Uid,
+		Vs:      state.Vs,
+		Camserv: state.U.Camserv,
+		File:    session.Path,
+		Bytes:   stats.BytesWritten,
+	}
+	if event == hooks.EventRecordFinished {
+		payload.Duration = float64(stats.LastTimestamp) / 1000
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	return payload
+}
+
+func hookErrorHandle(err *hooks.Error) {
+	fmt.Println("Error:", err)
+	RecentErrors.Add("hook", err.Payload.Model, err)
+}
+
+func connEventHandle(event ws_client.ConnEvent) {
+	if event.Err != nil {
+		fmt.Printf("Websocket %s (attempt %d): %s\n", event.Type, event.Attempt, event.Err)
+		RecentErrors.Add("websocket", "", fmt.Errorf("%s (attempt %d): %s", event.Type, event.Attempt, event.Err))
+	} else {
+		fmt.Printf("Websocket %s\n", event.Type)
+	}
+	if event.Type == ws_client.EventReconnected {
+		// the models which went offline while the connection was lost
+		// are not sent again by the new session
+		time.AfterFunc(reconcileDelay, func() {
+			OnlineModels.Reconcile(event.Time, time.Now())
+		})
+	}
+}
+
 func exitProgram(waitEnter bool) {
 	var exitCode = 0
 	if r := recover(); r != nil {
@@ -92,11 +178,17 @@ func exitProgram(waitEnter bool) {
 }
 
 func modelMapper(msg string) (err error){
+	OnlineModels.HandleMessage(msg)
 	model, err := models.GetModelData(msg)
 	if err == models.ServiceInfoError {
 		err = nil
 		return
 	}
+	if err == models.NotFoundError {
+		fmt.Printf("Model %q %s\n", model.Nm, err)
+		err = nil
+		return
+	}
 	if err != nil {
 		return
 	}
@@ -130,34 +222,151 @@ func init() {
 
 func main() {
 	var waitEnter bool
-	var modelName string
-	if len(os.Args) == 1 {
+	var modelNames []string
+	listFile := flag.String("list", "", "file with model names, one per line")
+	maxRecords := flag.Int("max", defaultMaxRecords, "maximum number of simultaneous recordings")
+	maxDuration := flag.Duration("max-duration", 0, "start a new segment after the duration, e.g. 30m")
+	maxSize := flag.Int64("max-size", 0, "start a new segment after the size in megabytes")
+	outDir := flag.String("dir", "", "output directory, the streams folder near the executable by default")
+	nameTemplate := flag.String("name", "", "output path template inside the directory, e.g. {model}/{date}_{time}.flv")
+	relayAddr := flag.String("relay", "", "restream to local players on the address, e.g. :1935, play rtmp://localhost/live/<model>")
+	historyFile := flag.String("history", "", "keep the status history of the models in the file, see mfchistory")
+	apiAddr := flag.String("api", "", "serve the HTTP control API on the address, e.g. localhost:8080")
+	hooksFile := flag.String("hooks", "", "JSON file with the webhooks and commands run on the state transitions and recordings")
+	metricsAddr := flag.String("metrics", "", "serve the Prometheus metrics on the address, e.g. localhost:9100")
+	subtitles := flag.String("subtitles", "", "write the room chat next to the recordings in the formats: srt, vtt, ass, e.g. srt,vtt")
+	roomEvents := flag.Bool("events", false, "log the tips, topic and show state changes next to the recordings as JSON lines")
+	backendName := flag.String("backend", string(rtmpdump.BackendAuto), "record the streams over rtmp, hls or auto: rtmp with the fallback to hls")
+	flag.Parse()
+	modelNames = flag.Args()
+	if *listFile != "" {
+		names, err := ReadWatchList(*listFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		modelNames = append(modelNames, names...)
+	}
+	if len(modelNames) == 0 && *apiAddr == "" {
 		waitEnter = true
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter model name: ")
-		modelName, _ = reader.ReadString('\n')
+		modelName, _ := reader.ReadString('\n')
 		modelName = strings.Replace(modelName, "\n", "", 1)
 		modelName = strings.Replace(modelName, "\r", "", 1)
+		modelNames = append(modelNames, modelName)
 	} else {
 		waitEnter = false
-		modelName = os.Args[1]
 	}
 	defer exitProgram(waitEnter)
+	if *maxRecords < 1 {
+		*maxRecords = 1
+	}
 
-	wsConn, err := ws_client.CreateConnection(modelName, true)
+	ctx, cancel := shutdown.Context()
+	defer cancel()
+	wsConn := ws_client.NewSupervisedConnector(ws_client.DefaultClientConfig(), modelNames, true)
+	wsConn.SetEventHdlr(connEventHandle)
+	opts := rtmpdump.RecordOptions{
+		Dir:      *outDir,
+		Template: *nameTemplate,
+		Segment:  container.SegmentLimits{
+			MaxDuration: *maxDuration,
+			MaxBytes:    *maxSize * 1024 * 1024,
+		},
+	}
+	if *relayAddr != "" {
+		relay, err := rtmpdump.NewRelay(*relayAddr)
+		if err != nil {
+			panic(err)
+		}
+		defer relay.Close()
+		opts.Relay = relay
+		fmt.Printf("Relay on %s, play rtmp://<host>/%s/<model>\n", *relayAddr, rtmpdump.RelayApp)
+	}
+	if *subtitles != "" {
+		formats, err := chat.ParseFormats(*subtitles)
+		if err != nil {
+			panic(err)
+		}
+		opts.Subtitles = formats
+	}
+	opts.RoomEvents = *roomEvents
+	backend, err := rtmpdump.ParseBackend(*backendName)
 	if err != nil {
 		panic(err)
 	}
-	go stateHandle(modelName)
-	wsConn.SetMsgHdlr(modelMapper)
-	err = wsConn.ReadForever()
-	if err != nil {
-		if err == models.NotFoundError {
-			fmt.Println(err)
-
-		} else {
+	opts.Backend = backend
+	if lookup, err := ws_client.NewLookupClient(ctx, ws_client.DefaultClientConfig()); err != nil {
+		fmt.Printf("Lookup session: %s, logging in for every recording\n", err)
+	} else {
+		defer lookup.Close()
+		opts.Lookup = lookup
+	}
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.ListenAndServe(*metricsAddr); err != nil {
+				fmt.Printf("Metrics: %s\n", err)
+			}
+		}()
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
+	var dispatcher *hooks.Dispatcher
+	if *hooksFile != "" {
+		hookList, err := hooks.LoadConfig(*hooksFile)
+		if err != nil {
 			panic(err)
 		}
+		dispatcher = hooks.NewDispatcher(hookList...)
+		dispatcher.OnError = hookErrorHandle
+		defer func() {
+			closeCtx, cancel := context.WithTimeout(context.Background(), hooksCloseTimeout)
+			defer cancel()
+			dispatcher.Close(closeCtx)
+		}()
+		opts.OnStart = func(session *rtmpdump.RecordingSession) {
+			dispatcher.Fire(recordPayload(hooks.EventRecordStarted, session, nil))
+		}
+		opts.OnFinish = func(session *rtmpdump.RecordingSession, err error) {
+			dispatcher.Fire(recordPayload(hooks.EventRecordFinished, session, err))
+		}
+	}
+	watcher := NewWatcher(ctx, modelNames, *maxRecords, opts)
+	var queue *history.Queue
+	if *historyFile != "" {
+		store, err := history.Open(*historyFile)
+		if err != nil {
+			panic(err)
+		}
+		defer store.Close()
+		queue = history.NewQueue(store, historyQueueSize)
+		queue.Snapshot = history.SnapshotPath(*historyFile)
+		queue.OnError = func(err error) {
+			fmt.Println("History:", err)
+			RecentErrors.Add("history", "", err)
+		}
+		queue.Start()
+		defer queue.Close()
+	}
+	go stateHandle(watcher, queue, dispatcher)
+	if *apiAddr != "" {
+		listener, err := net.Listen("tcp", *apiAddr)
+		if err != nil {
+			panic(err)
+		}
+		server := &http.Server{Handler: NewAPI(watcher, wsConn, RecentErrors, OnlineModels)}
+		go server.Serve(listener)
+		defer server.Close()
+		fmt.Printf("API on http://%s\n", listener.Addr())
+	}
+	wsConn.SetMsgHdlr(modelMapper)
+	err = wsConn.ReadForeverContext(ctx)
+	cancel()
+	watcher.Wait()
+	if err == context.Canceled {
+		return
+	}
+	if err != nil {
+		panic(err)
 	}
 }
-
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/rtmpdump"
+)
+
+const workerChanCap = 100
+const recordRetryDelay = 10 * time.Second
+
+// ReadWatchList reads model names from a file, one per line.
+// Empty lines and lines starting with '#' are skipped.
+func ReadWatchList(path string) (names []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	err = scanner.Err()
+	return
+}
+
+var ErrNotWatched = errors.New("the model is not watched")
+var ErrAlreadyWatched = errors.New("the model is already watched")
+
+// Watcher fans model states out to one recording worker per watched model.
+// The number of simultaneous recordings is limited by the slots channel.
+// Models are added and removed while watching, a removed model finishes
+// its recording. Workers stop when the context is cancelled, Wait returns
+// after all of them have finished their recordings.
+type Watcher struct {
+	sync.RWMutex
+	ctx     context.Context
+	wg      sync.WaitGroup
+	workers map[string]*modelWorker
+	slots   chan struct{}
+	opts    rtmpdump.RecordOptions
+}
+
+// WatchedModel is a watched model with its last state, State is nil until
+// the model is found. Recording is set while the model is recorded.
+type WatchedModel struct {
+	Name      string      `json:"name"`
+	State     *ModelState `json:"state"`
+	Paused    bool        `json:"paused"`
+	Recording *Recording  `json:"recording"`
+}
+
+// Recording is the progress of an active recording, Duration is the
+// recorded stream time in seconds.
+type Recording struct {
+	Model    string    `json:"model"`
+	Uid      uint64    `json:"uid"`
+	File     string    `json:"file"`
+	Started  time.Time `json:"started"`
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"duration"`
+}
+
+// NewWatcher starts the workers, recordings are made with opts,
+// opts.OutFile is ignored.
+func NewWatcher(ctx context.Context, modelNames []string, maxRecords int, opts rtmpdump.RecordOptions) *Watcher {
+	w := &Watcher{
+		ctx:     ctx,
+		workers: make(map[string]*modelWorker),
+		slots:   make(chan struct{}, maxRecords),
+		opts:    opts,
+	}
+	for _, name := range modelNames {
+		w.AddModel(name)
+	}
+	return w
+}
+
+func (w *Watcher) Wait() {
+	w.wg.Wait()
+}
+
+// AddModel starts the worker of the model, the last known state of the
+// model is passed to it at once.
+func (w *Watcher) AddModel(name string) error {
+	key := strings.ToLower(name)
+	w.Lock()
+	if _, ok := w.workers[key]; ok {
+		w.Unlock()
+		return ErrAlreadyWatched
+	}
+	ctx, cancel := context.WithCancel(w.ctx)
+	worker := &modelWorker{
+		modelName: name,
+		states:    make(chan ModelState, workerChanCap),
+		slots:     w.slots,
+		opts:      w.opts,
+		cancel:    cancel,
+	}
+	w.workers[key] = worker
+	w.wg.Add(1)
+	w.Unlock()
+	go func() {
+		defer w.wg.Done()
+		worker.run(ctx)
+	}()
+	if state, ok := ModelMap.FindByName(name); ok {
+		worker.send(state)
+	}
+	return nil
+}
+
+// RemoveModel stops the worker of the model, the recording is finished.
+func (w *Watcher) RemoveModel(name string) error {
+	key := strings.ToLower(name)
+	w.Lock()
+	worker, ok := w.workers[key]
+	delete(w.workers, key)
+	w.Unlock()
+	if !ok {
+		return ErrNotWatched
+	}
+	worker.cancel()
+	return nil
+}
+
+// StartRecord resumes the recording of the model paused by StopRecord,
+// the model is recorded when it is online.
+func (w *Watcher) StartRecord(name string) error {
+	worker, ok := w.worker(name)
+	if !ok {
+		return ErrNotWatched
+	}
+	worker.setPaused(false)
+	if state, ok := ModelMap.FindByName(name); ok {
+		worker.send(state)
+	}
+	return nil
+}
+
+// StopRecord finishes the recording of the model and pauses recording
+// it until StartRecord.
+func (w *Watcher) StopRecord(name string) error {
+	worker, ok := w.worker(name)
+	if !ok {
+		return ErrNotWatched
+	}
+	worker.setPaused(true)
+	return nil
+}
+
+func (w *Watcher) worker(name string) (worker *modelWorker, ok bool) {
+	w.RLock()
+	defer w.RUnlock()
+	worker, ok = w.workers[strings.ToLower(name)]
+	return
+}
+
+func (w *Watcher) ModelNames() (names []string) {
+	w.RLock()
+	defer w.RUnlock()
+	for _, worker := range w.workers {
+		names = append(names, worker.modelName)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Models returns the watched models sorted by name.
+func (w *Watcher) Models() (watched []WatchedModel) {
+	w.RLock()
+	defer w.RUnlock()
+	watched = make([]WatchedModel, 0, len(w.workers))
+	for _, worker := range w.workers {
+		model := WatchedModel{
+			Name:      worker.modelName,
+			Paused:    worker.isPaused(),
+			Recording: worker.recording(),
+		}
+		if state, ok := ModelMap.FindByName(worker.modelName); ok {
+			model.State = &state
+		}
+		watched = append(watched, model)
+	}
+	sort.Slice(watched, func(i, j int) bool {
+		return strings.ToLower(watched[i].Name) < strings.ToLower(watched[j].Name)
+	})
+	return
+}
+
+// Recordings returns the active recordings sorted by model name.
+func (w *Watcher) Recordings() (recordings []Recording) {
+	recordings = []Recording{}
+	for _, model := range w.Models() {
+		if model.Recording != nil {
+			recordings = append(recordings, *model.Recording)
+		}
+	}
+	return
+}
+
+// Dispatch passes the state to the worker of the model, if the model is watched.
+func (w *Watcher) Dispatch(state ModelState) {
+	if worker, ok := w.worker(state.Nm); ok {
+		worker.send(state)
+	}
+}
+
+type modelWorker struct {
+	modelName string
+	states    chan ModelState
+	slots     chan struct{}
+	opts      rtmpdump.RecordOptions
+	cancel    context.CancelFunc
+
+	mu         sync.Mutex
+	paused     bool
+	stopRecord context.CancelFunc
+	session    *rtmpdump.RecordingSession
+}
+
+func (mw *modelWorker) send(state ModelState) {
+	select {
+	case mw.states <- state:
+	default:
+		fmt.Printf("Worker of %q is busy, state dropped\n", mw.modelName)
+	}
+}
+
+func (mw *modelWorker) isPaused() bool {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	return mw.paused
+}
+
+// setPaused pauses or resumes recording, pausing stops the current
+// recording.
+func (mw *modelWorker) setPaused(paused bool) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.paused = paused
+	if paused && mw.stopRecord != nil {
+		mw.stopRecord()
+	}
+}
+
+func (mw *modelWorker) setSession(session *rtmpdump.RecordingSession) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.session = session
+}
+
+func (mw *modelWorker) recording() *Recording {
+	mw.mu.Lock()
+	session := mw.session
+	mw.mu.Unlock()
+	if session == nil {
+		return nil
+	}
+	stats := session.Stats()
+	return &Recording{
+		Model:    session.ModelName,
+		Uid:      session.Uid,
+		File:     session.Path,
+		Started:  stats.StartTime,
+		Bytes:    stats.BytesWritten,
+		Duration: float64(stats.LastTimestamp) / 1000,
+	}
+}
+
+func (mw *modelWorker) run(ctx context.Context) {
+	for {
+		var state ModelState
+		select {
+		case state = <-mw.states:
+		case <-ctx.Done():
+			return
+		}
+		if !state.RecordEnable() || mw.isPaused() {
+			continue
+		}
+		select {
+		case mw.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		mw.record(ctx, state.Uid)
+		<-mw.slots
+	}
+}
+
+func (mw *modelWorker) record(ctx context.Context, uid uint64) {
+	for ctx.Err() == nil {
+		currentState, _ := ModelMap.Get(uid)
+		if !currentState.RecordEnable() {
+			return
+		}
+		recordCtx, cancel := context.WithCancel(ctx)
+		mw.mu.Lock()
+		if mw.paused {
+			mw.mu.Unlock()
+			cancel()
+			return
+		}
+		mw.stopRecord = cancel
+		mw.mu.Unlock()
+		opts := mw.opts
+		opts.OutFile = ""
+		opts.OnStart = func(session *rtmpdump.RecordingSession) {
+			mw.setSession(session)
+			if mw.opts.OnStart != nil {
+				mw.opts.OnStart(session)
+			}
+		}
+		opts.OnFinish = func(session *rtmpdump.RecordingSession, err error) {
+			mw.setSession(nil)
+			if mw.opts.OnFinish != nil {
+				mw.opts.OnFinish(session, err)
+			}
+		}
+		err := rtmpdump.RecordWithOptions(recordCtx, mw.modelName, opts)
+		mw.mu.Lock()
+		mw.stopRecord = nil
+		mw.session = nil
+		mw.mu.Unlock()
+		stopped := recordCtx.Err() != nil
+		cancel()
+		if err != nil && !stopped {
+			fmt.Printf("Record %q error: %s\n", mw.modelName, err)
+			RecentErrors.Add("record", mw.modelName, err)
+			select {
+			case <-time.After(recordRetryDelay):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 	"sync"
+	"context"
 
 	"math/rand"
 	"net/http"
@@ -18,11 +19,19 @@ import (
 
 	"strings"
 	"errors"
+	"log"
+
+	"gomfc/fcs"
+	"gomfc/metrics"
+	"gomfc/servers"
 )
 
 const wsHostPattern = "wss://%s.myfreecams.com/fcsl"
-const serverCfgUrl = "https://www.myfreecams.com/_js/serverconfig.js"
-const apiChallengePattern = "https://api.myfreecams.com/dc?nc=%.16f&site=%s"
+const siteBaseUrl = "https://www.myfreecams.com"
+const apiBaseUrl = "https://api.myfreecams.com"
+const serverCfgPath = "/_js/serverconfig.js"
+const apiChallengePath = "/dc?nc=%.16f&site=%s"
+const defaultOrigin = "http://localhost/"
 const site = "www"
 const intervalLen = 600
 const sessionPosition  = 5
@@ -30,6 +39,8 @@ const tokenPosition = 2
 const wsPingTimeout = 10 * time.Second
 const maxTries = 3
 const modelDataTimeOut = 30 * time.Second
+const roomOffset = 100000000
+const chanJoin = 1
 
 type ApiChallengeResult struct {
 	Id string
@@ -44,9 +55,86 @@ type ApiChallengeResult struct {
 	Err int64
 }
 
+// WSDialer opens a websocket connection, websocket.Dial is used by default.
+type WSDialer func(url, protocol, origin string) (*websocket.Conn, error)
+
+// ClientConfig holds the endpoints and the transport used by the client.
+type ClientConfig struct {
+	// Base url of the site, serverconfig.js is loaded from it
+	SiteBaseUrl string
+	// Base url of the api, the dc challenge is requested from it
+	ApiBaseUrl string
+	// Pattern of the websocket url, gets the chat server name
+	WSHostPattern string
+	// HttpClient makes the api requests, http.DefaultClient when nil
+	HttpClient *http.Client
+	// ServerConfigClient downloads serverconfig.js, HttpClient when nil
+	ServerConfigClient *http.Client
+	Dialer             WSDialer
+	Origin             string
+}
+
+// DefaultClientConfig returns the endpoints of the site. The certificate
+// of the site is not verified for serverconfig.js only, as always.
+func DefaultClientConfig() ClientConfig {
+	transCfg := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // disable verify
+	}
+	return ClientConfig{
+		SiteBaseUrl:        siteBaseUrl,
+		ApiBaseUrl:         apiBaseUrl,
+		WSHostPattern:      wsHostPattern,
+		ServerConfigClient: &http.Client{Transport: transCfg},
+		Dialer:             websocket.Dial,
+		Origin:             defaultOrigin,
+	}
+}
+
+func (cfg ClientConfig) httpClient() *http.Client {
+	if cfg.HttpClient == nil {
+		return http.DefaultClient
+	}
+	return cfg.HttpClient
+}
+
+func (cfg ClientConfig) serverConfigClient() *http.Client {
+	if cfg.ServerConfigClient == nil {
+		return cfg.httpClient()
+	}
+	return cfg.ServerConfigClient
+}
+
+func (cfg ClientConfig) dial(url string) (*websocket.Conn, error) {
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = websocket.Dial
+	}
+	origin := cfg.Origin
+	if origin == "" {
+		origin = defaultOrigin
+	}
+	return dialer(url, "", origin)
+}
+
 func GetApiChallengeResult() (apiChallengResponse *ApiChallengeResult, err error) {
-	apiUrl := fmt.Sprintf(apiChallengePattern, rand.Float64(), site)
-	resp, err := http.Get(apiUrl)
+	return DefaultClientConfig().GetApiChallengeResult()
+}
+
+func (cfg ClientConfig) GetApiChallengeResult() (apiChallengResponse *ApiChallengeResult, err error) {
+	return cfg.getApiChallengeResult(context.Background())
+}
+
+func get(ctx context.Context, client *http.Client, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	return client.Do(req.WithContext(ctx))
+}
+
+func (cfg ClientConfig) getApiChallengeResult(ctx context.Context) (apiChallengResponse *ApiChallengeResult, err error) {
+	apiUrl := cfg.ApiBaseUrl + fmt.Sprintf(apiChallengePath, rand.Float64(), site)
+	resp, err := get(ctx, cfg.httpClient(), apiUrl)
 	if err != nil {
 		return
 	}
@@ -58,12 +146,15 @@ func GetApiChallengeResult() (apiChallengResponse *ApiChallengeResult, err error
 
 type WSMsgHandler func(string) error
 
+var ConnectionClosedError = errors.New("websocket connection closed")
+
 type WSConnector struct {
 	modelName      string
 	sync.Mutex
 	*websocket.Conn
 	result         chan string
 	stop           chan struct{}
+	stopOnce       sync.Once
 	tokenId        string
 	sessionId      string
 	modelRequestId int64
@@ -73,32 +164,33 @@ type WSConnector struct {
 	trace string
 }
 
-func getWSServer() (server string, err error) {
-	type CfgResp struct {
-		Websocket_Servers map[string]string
-	}
-	transCfg := &http.Transport{
-        	TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // disable verify
-    	}
-	client := &http.Client{Transport: transCfg}
-	resp, err := client.Get(serverCfgUrl)
+// ServerConfig downloads serverconfig.js and parses its server tables,
+// the skipped entries are logged.
+func (cfg ClientConfig) ServerConfig(ctx context.Context) (config *servers.Config, err error) {
+	resp, err := get(ctx, cfg.serverConfigClient(), cfg.SiteBaseUrl + serverCfgPath)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	cfgResp := CfgResp{}
-	err = json.Unmarshal(body, &cfgResp)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	for server, _ = range cfgResp.Websocket_Servers {
-		return
+	config, err = servers.Parse(body)
+	if err == nil && len(config.Skipped) > 0 {
+		log.Printf("serverconfig: skipped malformed entries %s", strings.Join(config.Skipped, ", "))
 	}
-	err = errors.New("no ws servers")
 	return
 }
 
+func getWSServer(ctx context.Context, cfg ClientConfig) (server string, err error) {
+	config, err := cfg.ServerConfig(ctx)
+	if err != nil {
+		return
+	}
+	return config.WebsocketServer()
+}
+
 func (c *WSConnector) GetTokenId() string {
 	return c.tokenId
 }
@@ -113,8 +205,9 @@ func (c *WSConnector) SendString(message string) error {
 }
 
 func (c *WSConnector) Close() error {
-	c.stop <- struct{}{}
-	close(c.stop)
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
 	return c.Conn.Close()
 }
 
@@ -122,8 +215,71 @@ func (c *WSConnector) SetMsgHdlr(handler WSMsgHandler) {
 	c.msgHandler = handler
 }
 
-func CreateConnection(modelName string, allFlag bool) (ws WSConnector, err error) {
+// RequestModel sends an additional username lookup over the
+// already logged in connection.
+func (c *WSConnector) RequestModel(modelName string) error {
+	requestId := nextRequestId()
+	return c.SendString(fmt.Sprintf("10 %s 0 %d 0 %s\n", c.tokenId, requestId, modelName))
+}
+
+// RoomChannel returns the chat channel of the model room.
+func RoomChannel(uid uint64) uint64 {
+	return uid + roomOffset
+}
+
+// JoinRoom joins the chat room of the model, the room messages are
+// received after it.
+func (c *WSConnector) JoinRoom(uid uint64) error {
+	return c.SendString(fmt.Sprintf("%d %s 0 %d %d\n", fcs.FCTYPE_JOINCHAN, c.tokenId, RoomChannel(uid), chanJoin))
+}
+
+func CreateConnection(modelName string, allFlag bool) (ws *WSConnector, err error) {
+	return NewConnectionContext(context.Background(), DefaultClientConfig(), modelName, allFlag)
+}
+
+func CreateConnectionContext(ctx context.Context, modelName string, allFlag bool) (ws *WSConnector, err error) {
+	return NewConnectionContext(ctx, DefaultClientConfig(), modelName, allFlag)
+}
+
+// NewConnection logs in as guest using the endpoints and the transport
+// from cfg and requests the model data.
+func NewConnection(cfg ClientConfig, modelName string, allFlag bool) (ws *WSConnector, err error) {
+	return NewConnectionContext(context.Background(), cfg, modelName, allFlag)
+}
+
+// NewConnectionContext is NewConnection bound to the context, cancelling
+// the context closes the connection and stops its goroutines.
+func NewConnectionContext(ctx context.Context, cfg ClientConfig, modelName string, allFlag bool) (ws *WSConnector, err error) {
+	return newConnection(ctx, cfg, modelName, allFlag, true)
+}
+
+// NewChatConnectionContext is NewConnectionContext without the room data
+// subscription, the states of all the online models are not sent to the
+// connections joining a single room.
+func NewChatConnectionContext(ctx context.Context, cfg ClientConfig, modelName string) (ws *WSConnector, err error) {
+	return newConnection(ctx, cfg, modelName, true, false)
+}
+
+// newConnection logs in, the model lookup is skipped without a model name
+// and the room data subscription without roomData.
+func newConnection(ctx context.Context, cfg ClientConfig, modelName string, allFlag bool, roomData bool) (ws *WSConnector, err error) {
 	var tries = 0
+	loginStart := time.Now()
+	defer func() {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.LoginDuration.WithLabelValues(result).Observe(time.Since(loginStart).Seconds())
+	}()
+	ws = &WSConnector{
+		stop: make(chan struct{}),
+	}
+	defer func() {
+		if err != nil && ws.Conn != nil {
+			ws.Conn.Close()
+		}
+	}()
 	Start:
 	tries++
 	if tries > maxTries {
@@ -131,18 +287,17 @@ func CreateConnection(modelName string, allFlag bool) (ws WSConnector, err error
 		return
 	}
 	ws.modelName = modelName
-	challengeResult, err := GetApiChallengeResult()
+	challengeResult, err := cfg.getApiChallengeResult(ctx)
 	if err != nil {
 		return
 	}
 	cid, key, timeR := challengeResult.Result.Cid, challengeResult.Result.Key, challengeResult.Result.Time
-	xchat, err := getWSServer()
+	xchat, err := getWSServer(ctx, cfg)
 	if err != nil {
 		return
 	}
-	wsUrl := fmt.Sprintf(wsHostPattern, xchat)
-	origin := "http://localhost/"
-	ws.Conn, err = websocket.Dial(wsUrl, "", origin)
+	wsUrl := fmt.Sprintf(cfg.WSHostPattern, xchat)
+	ws.Conn, err = cfg.dial(wsUrl)
 	if err != nil {
 		return
 	}
@@ -182,7 +337,6 @@ func CreateConnection(modelName string, allFlag bool) (ws WSConnector, err error
 		return
 	}
 	ws.result = make(chan string)
-	ws.stop = make(chan struct{})
 	var splited []string
 	respMsg := ""
 	err = websocket.Message.Receive(ws.Conn, &respMsg)
@@ -191,6 +345,8 @@ func CreateConnection(modelName string, allFlag bool) (ws WSConnector, err error
 	}
 	splited = strings.Fields(respMsg)
 	if len(splited) != 5 {
+		ws.Conn.Close()
+		ws.Conn = nil
 		goto Start
 	}
 	ws.tokenId = splited[tokenPosition]
@@ -200,27 +356,47 @@ func CreateConnection(modelName string, allFlag bool) (ws WSConnector, err error
 	}
 	splited = strings.Fields(respMsg)
 	if len(splited) != 6 {
+		ws.Conn.Close()
+		ws.Conn = nil
 		goto Start
 	}
 	ws.sessionId = splited[sessionPosition]
 	if err = ws.SendString(fmt.Sprintf("1 0 0 20071025 0 %s@1/guest:guest\n", ws.sessionId)); err != nil {
 		return
 	}
-	ws.modelRequestId = time.Now().UnixNano() / 1000000000
-	modelRequest := fmt.Sprintf("10 %s 0 %d 0 %s\n", ws.tokenId, ws.modelRequestId, ws.modelName)
-	err = ws.SendString(modelRequest)
-	if err != nil {
-		return
+	if ws.modelName != "" {
+		ws.modelRequestId = nextRequestId()
+		modelRequest := fmt.Sprintf("10 %s 0 %d 0 %s\n", ws.tokenId, ws.modelRequestId, ws.modelName)
+		err = ws.SendString(modelRequest)
+		if err != nil {
+			return
+		}
 	}
-	err = ws.SendString(fmt.Sprintf("44 %s 0 1 0\n", ws.tokenId))
-	if err != nil {
-		return
+	if roomData {
+		err = ws.SendString(fmt.Sprintf("44 %s 0 1 0\n", ws.tokenId))
+		if err != nil {
+			return
 
+		}
 	}
 	go ws.Serve(allFlag)
+	if ctx.Done() != nil {
+		go ws.closeOnDone(ctx, ws.Conn)
+	}
 	return
 }
 
+func (c *WSConnector) closeOnDone(ctx context.Context, conn *websocket.Conn) {
+	select {
+	case <-ctx.Done():
+		c.stopOnce.Do(func() {
+			close(c.stop)
+		})
+		conn.Close()
+	case <-c.stop:
+	}
+}
+
 func (c *WSConnector) Serve(allFlag bool) {
 	var respMsg string
 	var err error
@@ -234,7 +410,6 @@ func (c *WSConnector) Serve(allFlag bool) {
 	}()
 	senderQuitChan := make(chan struct{})
 	defer func() {
-		senderQuitChan <- struct{}{}
 		close(senderQuitChan)
 	}()
 	go func(){
@@ -261,7 +436,12 @@ func (c *WSConnector) Serve(allFlag bool) {
 				if err != nil {
 					return
 				}
-				c.result <- respMsg
+				countMessage(respMsg)
+				select {
+				case c.result <- respMsg:
+				case <-c.stop:
+					return
+				}
 			}
 		}
 	} else {
@@ -272,16 +452,25 @@ func (c *WSConnector) Serve(allFlag bool) {
 			case <-c.stop:
 				return
 			case <-waitTimer.C:
-				c.result <- found
+				select {
+				case c.result <- found:
+				case <-c.stop:
+					return
+				}
 			default:
 				err = websocket.Message.Receive(c.Conn, &respMsg)
 				if err != nil {
 					return
 				}
+				countMessage(respMsg)
 				if strings.Contains(respMsg, c.modelName) {
 					found = respMsg
 					if !strings.Contains(respMsg, "%22vs%22:90") {
-						c.result <- found
+						select {
+						case c.result <- found:
+						case <-c.stop:
+							return
+						}
 					}
 				}
 			}
@@ -289,7 +478,25 @@ func (c *WSConnector) Serve(allFlag bool) {
 	}
 }
 
+// countMessage counts the frames of the message by type.
+func countMessage(msg string) {
+	frames, _ := fcs.ParseMessage(msg)
+	for _, frame := range frames {
+		name := "unknown"
+		if frame.Type.Known() {
+			name = frame.Type.String()
+		}
+		metrics.WebsocketMessages.WithLabelValues(name).Inc()
+	}
+}
+
 func (c *WSConnector) ReadSingle(timeout time.Duration) (result string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.ReadSingleContext(ctx)
+}
+
+func (c *WSConnector) ReadSingleContext(ctx context.Context) (result string, err error) {
 ServeLoop:
 	for {
 		select {
@@ -302,8 +509,12 @@ ServeLoop:
 				result = msg
 			}
 			break ServeLoop
-		case <-time.After(timeout):
-			err = errors.New("response timeout")
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				err = errors.New("response timeout")
+			} else {
+				err = ctx.Err()
+			}
 			break ServeLoop
 		}
 	}
@@ -311,14 +522,25 @@ ServeLoop:
 }
 
 func (c *WSConnector) ReadForever() (err error) {
+	return c.ReadForeverContext(context.Background())
+}
+
+func (c *WSConnector) ReadForeverContext(ctx context.Context) (err error) {
 ServerLoop:
 	for {
 		select {
+		case <-ctx.Done():
+			c.Close()
+			err = ctx.Err()
+			break ServerLoop
 		case msg, ok := <-c.result:
 			if !ok {
 				if c.err != nil {
 					err = c.err
+				} else {
+					err = ConnectionClosedError
 				}
+				break ServerLoop
 			} else {
 				err = c.msgHandler(msg)
 				if err != nil {
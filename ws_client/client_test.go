@@ -0,0 +1,91 @@
+package ws_client_test
+
+import (
+	"testing"
+	"time"
+
+	"gomfc/models"
+	"gomfc/ws_client"
+	"gomfc/ws_client/fcstest"
+)
+
+const testTimeout = 5 * time.Second
+
+func newTestServer() *fcstest.Server {
+	server := fcstest.NewServer()
+	m := fcstest.Model{Lv: fcstest.ModelLv, Nm: "TestModel", Uid: 100500, Sid: 42, Vs: models.IsOnline}
+	m.U.Camserv = 1544
+	server.SetModel(m)
+	return server
+}
+
+func TestNewConnection(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	ws, err := ws_client.NewConnection(server.ClientConfig(), "TestModel", false)
+	if err != nil {
+		t.Fatalf("NewConnection error: %s", err)
+	}
+	defer ws.Conn.Close()
+	if ws.GetTokenId() == "" {
+		t.Error("empty token id")
+	}
+	raw, err := ws.ReadSingle(testTimeout)
+	if err != nil {
+		t.Fatalf("ReadSingle error: %s", err)
+	}
+	model, err := models.GetModelData(raw)
+	if err != nil {
+		t.Fatalf("GetModelData(%q) error: %s", raw, err)
+	}
+	if model.Uid != 100500 || model.U.Camserv != 1544 || !model.RecordEnable() {
+		t.Errorf("unexpected model: %+v", model)
+	}
+}
+
+func TestNewConnectionNotFound(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	ws, err := ws_client.NewConnection(server.ClientConfig(), "Nobody", false)
+	if err != nil {
+		t.Fatalf("NewConnection error: %s", err)
+	}
+	defer ws.Conn.Close()
+	raw, err := ws.ReadSingle(testTimeout)
+	if err != nil {
+		t.Fatalf("ReadSingle error: %s", err)
+	}
+	if _, err = models.GetModelData(raw); err != models.NotFoundError {
+		t.Errorf("GetModelData(%q) got %v, expect %v", raw, err, models.NotFoundError)
+	}
+}
+
+func TestRoomData(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	ws, err := ws_client.NewConnection(server.ClientConfig(), "TestModel", true)
+	if err != nil {
+		t.Fatalf("NewConnection error: %s", err)
+	}
+	defer ws.Conn.Close()
+	found := make(chan models.MFCModel, 1)
+	ws.SetMsgHdlr(func(msg string) error {
+		model, err := models.GetModelData(msg)
+		if err == nil && model.Lv == models.ModelLv {
+			select {
+			case found <- model:
+			default:
+			}
+		}
+		return nil
+	})
+	go ws.ReadForever()
+	select {
+	case model := <-found:
+		if model.Nm != "TestModel" {
+			t.Errorf("unexpected model: %+v", model)
+		}
+	case <-time.After(testTimeout):
+		t.Error("no model state received")
+	}
+}
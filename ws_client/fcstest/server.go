@@ -0,0 +1,363 @@
+// Package fcstest provides a fake FCS server that serves serverconfig.js,
+// the dc challenge and the fcsl websocket, so the client can be
+// exercised without the real site.
+package fcstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"gomfc/ws_client"
+)
+
+const ChatServer = "xchat1"
+const ModelLv = 4
+
+const (
+	challengeCid  = "fcstest-cid"
+	challengeKey  = "fcstest-key"
+	challengeTime = 1500000000
+	guestPrefix   = "Guest"
+)
+
+// Model is a model as it is sent over the websocket.
+type Model struct {
+	Lv  int    `json:"lv"`
+	Nm  string `json:"nm"`
+	Pid int64  `json:"pid"`
+	Sid uint64 `json:"sid"`
+	Uid uint64 `json:"uid"`
+	Vs  uint64 `json:"vs"`
+	U   struct {
+		Camserv int32 `json:"camserv"`
+	} `json:"u"`
+	M struct {
+		Flags int32 `json:"flags"`
+	} `json:"m"`
+}
+
+// Server is a fake FCS server listening on a local port.
+type Server struct {
+	*httptest.Server
+
+	sync.Mutex
+	models      map[string]Model
+	conns       map[*websocket.Conn]*session
+	lastSession uint64
+	lookupDelay func(query string) time.Duration
+}
+
+type session struct {
+	sync.Mutex
+	conn     *websocket.Conn
+	id       uint64
+	greeted  bool
+	loggedIn bool
+	rooms    map[uint64]bool
+}
+
+func (s *session) send(msg string) error {
+	s.Lock()
+	defer s.Unlock()
+	return websocket.Message.Send(s.conn, msg)
+}
+
+func NewServer() *Server {
+	s := &Server{
+		models: make(map[string]Model),
+		conns:  make(map[*websocket.Conn]*session),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_js/serverconfig.js", s.serveConfig)
+	mux.HandleFunc("/dc", s.serveChallenge)
+	mux.Handle("/"+ChatServer+"/fcsl", websocket.Handler(s.serveWS))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// ClientConfig returns a client config pointing at the fake server.
+func (s *Server) ClientConfig() ws_client.ClientConfig {
+	wsBase := "ws" + strings.TrimPrefix(s.URL, "http")
+	return ws_client.ClientConfig{
+		SiteBaseUrl:   s.URL,
+		ApiBaseUrl:    s.URL,
+		WSHostPattern: wsBase + "/%s/fcsl",
+		HttpClient:    s.Client(),
+		Dialer:        websocket.Dial,
+		Origin:        s.URL + "/",
+	}
+}
+
+// SetModel adds or replaces the model, online models are pushed to
+// every logged in connection.
+func (s *Server) SetModel(m Model) {
+	s.Lock()
+	s.models[strings.ToLower(m.Nm)] = m
+	sessions := s.sessions()
+	s.Unlock()
+	for _, sess := range sessions {
+		sess.send(sessionStateFrame(sess.id, m))
+	}
+}
+
+// Broadcast sends a raw frame to every logged in connection.
+func (s *Server) Broadcast(frame string) {
+	s.Lock()
+	sessions := s.sessions()
+	s.Unlock()
+	for _, sess := range sessions {
+		sess.send(frame)
+	}
+}
+
+// CloseConnections drops every websocket connection, the http server
+// keeps listening.
+func (s *Server) CloseConnections() {
+	s.Lock()
+	defer s.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Connections returns the number of the websocket connections accepted
+// so far.
+func (s *Server) Connections() int {
+	return int(atomic.LoadUint64(&s.lastSession))
+}
+
+func (s *Server) sessions() (sessions []*session) {
+	for _, sess := range s.conns {
+		if sess.loggedIn {
+			sessions = append(sessions, sess)
+		}
+	}
+	return
+}
+
+func (s *Server) serveConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := map[string]interface{}{
+		"websocket_servers": map[string]string{ChatServer: "rfc6455"},
+	}
+	json.NewEncoder(w).Encode(cfg)
+}
+
+func (s *Server) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	resp := ws_client.ApiChallengeResult{
+		Id:          "1",
+		ResponseVer: 1,
+		Method:      "dc",
+	}
+	resp.Result.Time = challengeTime
+	resp.Result.Cid = challengeCid
+	resp.Result.Key = challengeKey
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) serveWS(conn *websocket.Conn) {
+	sess := &session{
+		conn:  conn,
+		id:    atomic.AddUint64(&s.lastSession, 1),
+		rooms: make(map[uint64]bool),
+	}
+	s.Lock()
+	s.conns[conn] = sess
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+	}()
+	var authorized bool
+	for {
+		var msg string
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			return
+		}
+		for _, line := range strings.Split(msg, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 5 {
+				continue
+			}
+			switch {
+			case fields[0] == "1" && fields[3] == "81" && len(fields) == 6:
+				authorized = s.checkChallenge(fields[5])
+			case fields[0] == "0" && !sess.greeted:
+				if !authorized {
+					return
+				}
+				sess.greeted = true
+				sess.send(fmt.Sprintf("81 0 %d 0 0", sess.id))
+				sess.send(fmt.Sprintf("1 0 %d 0 0 %s%d", sess.id, guestPrefix, sess.id))
+			case fields[0] == "1" && fields[3] == "20071025":
+				s.Lock()
+				sess.loggedIn = true
+				s.Unlock()
+			case fields[0] == "10" && len(fields) == 6:
+				s.lookup(sess, fields[3], fields[5], false)
+			case fields[0] == "10" && len(fields) == 5:
+				s.lookup(sess, fields[3], fields[4], true)
+			case fields[0] == "44":
+				s.roomData(sess)
+			case fields[0] == "51":
+				if channel, err := strconv.ParseUint(fields[3], 10, 64); err == nil {
+					s.Lock()
+					sess.rooms[channel] = true
+					s.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// Joined returns the number of connections in the room of the model.
+func (s *Server) Joined(uid uint64) (joined int) {
+	s.Lock()
+	defer s.Unlock()
+	for _, sess := range s.conns {
+		if sess.rooms[ws_client.RoomChannel(uid)] {
+			joined++
+		}
+	}
+	return
+}
+
+// Chat sends the message of the user to the connections in the room
+// of the model.
+func (s *Server) Chat(uid uint64, name string, text string) {
+	data, _ := json.Marshal(struct {
+		Lv  int    `json:"lv"`
+		Nm  string `json:"nm"`
+		Msg string `json:"msg"`
+	}{Lv: 1, Nm: name, Msg: text})
+	s.sendRoom(uid, fmt.Sprintf("50 0 %d 0 0 %s", ws_client.RoomChannel(uid), url.QueryEscape(string(data))))
+}
+
+// Tip sends a tip of the user to the connections in the room of the model.
+func (s *Server) Tip(uid uint64, name string, tokens int64, text string) {
+	data, _ := json.Marshal(struct {
+		Ch     uint64        `json:"ch"`
+		M      []interface{} `json:"m"`
+		U      []interface{} `json:"u"`
+		Msg    string        `json:"msg"`
+		Tokens int64         `json:"tokens"`
+	}{
+		Ch:     ws_client.RoomChannel(uid),
+		M:      []interface{}{uid, 0, ""},
+		U:      []interface{}{1, 1, name},
+		Msg:    text,
+		Tokens: tokens,
+	})
+	s.sendRoom(uid, fmt.Sprintf("6 0 0 0 0 %s", url.QueryEscape(string(data))))
+}
+
+func (s *Server) sendRoom(uid uint64, frame string) {
+	channel := ws_client.RoomChannel(uid)
+	s.Lock()
+	var joined []*session
+	for _, sess := range s.conns {
+		if sess.rooms[channel] {
+			joined = append(joined, sess)
+		}
+	}
+	s.Unlock()
+	for _, sess := range joined {
+		sess.send(frame)
+	}
+}
+
+func (s *Server) checkChallenge(escaped string) bool {
+	raw, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return false
+	}
+	req := struct {
+		Key string
+		Cid string
+	}{}
+	if err = json.Unmarshal([]byte(raw), &req); err != nil {
+		return false
+	}
+	return req.Key == challengeKey && req.Cid == challengeCid
+}
+
+// SetLookupDelay delays the answers to the lookups by the duration
+// returned for the name or the uid, nil answers at once.
+func (s *Server) SetLookupDelay(delay func(query string) time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.lookupDelay = delay
+}
+
+func (s *Server) lookup(sess *session, requestId string, query string, byUid bool) {
+	s.Lock()
+	lookupDelay := s.lookupDelay
+	s.Unlock()
+	if lookupDelay != nil {
+		if delay := lookupDelay(query); delay > 0 {
+			go func() {
+				time.Sleep(delay)
+				s.answerLookup(sess, requestId, query, byUid)
+			}()
+			return
+		}
+	}
+	s.answerLookup(sess, requestId, query, byUid)
+}
+
+func (s *Server) answerLookup(sess *session, requestId string, query string, byUid bool) {
+	s.Lock()
+	m, ok := s.models[strings.ToLower(query)]
+	if byUid {
+		ok = false
+		for _, candidate := range s.models {
+			if strconv.FormatUint(candidate.Uid, 10) == query {
+				m, ok = candidate, true
+			}
+		}
+	}
+	s.Unlock()
+	if !ok {
+		if byUid {
+			sess.send(fmt.Sprintf("10 0 %d %s %s", sess.id, requestId, query))
+		} else {
+			sess.send(fmt.Sprintf("10 0 %d %s 0 %s", sess.id, requestId, query))
+		}
+		return
+	}
+	sess.send(fmt.Sprintf("10 0 %d %s 0 %s", sess.id, requestId, escapeModel(m)))
+}
+
+func (s *Server) roomData(sess *session) {
+	s.Lock()
+	var online []Model
+	for _, m := range s.models {
+		if m.Lv == ModelLv {
+			online = append(online, m)
+		}
+	}
+	s.Unlock()
+	for _, m := range online {
+		sess.send(sessionStateFrame(sess.id, m))
+	}
+}
+
+func sessionStateFrame(sessionId uint64, m Model) string {
+	return fmt.Sprintf("20 0 %d 0 0 %s", sessionId, escapeModel(m))
+}
+
+func escapeModel(m Model) string {
+	data, _ := json.Marshal(m)
+	return url.QueryEscape(string(data))
+}
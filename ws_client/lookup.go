@@ -0,0 +1,236 @@
+package ws_client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gomfc/fcs"
+	"gomfc/models"
+)
+
+// lastRequestId starts from the current time, so the ids of a restarted
+// process are not reused by the server for a while.
+var lastRequestId = time.Now().Unix()
+
+// nextRequestId returns an id unique in the process for a request
+// answered with the same id.
+func nextRequestId() int64 {
+	return atomic.AddInt64(&lastRequestId, 1)
+}
+
+// LookupClient resolves models over a single logged in websocket. Every
+// lookup gets its own request id and is answered by the response with
+// the same id, so any number of lookups can run at once. A lost
+// connection is dialed again by the next lookup.
+type LookupClient struct {
+	cfg    ClientConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	conn    *lookupConn
+	dialing *dialCall
+	pending map[int64]*pendingLookup
+}
+
+type lookupConn struct {
+	*WSConnector
+	done chan struct{}
+}
+
+// dialCall is a running dial, the lookups arriving meanwhile wait for it
+// instead of dialing again.
+type dialCall struct {
+	done chan struct{}
+	conn *lookupConn
+	err  error
+}
+
+type pendingLookup struct {
+	conn   *lookupConn
+	result chan fcs.Frame
+}
+
+// NewLookupClient logs in using the endpoints from cfg. The connection
+// is closed by Close or when the context is cancelled.
+func NewLookupClient(ctx context.Context, cfg ClientConfig) (c *LookupClient, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	c = &LookupClient{
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[int64]*pendingLookup),
+	}
+	_, err = c.connect(ctx)
+	if err != nil {
+		cancel()
+		c = nil
+	}
+	return
+}
+
+// LookupByName returns the model with the name, models.NotFoundError is
+// returned for an unknown name.
+func (c *LookupClient) LookupByName(ctx context.Context, name string) (model models.MFCModel, err error) {
+	return c.lookup(ctx, func(token string, requestId int64) string {
+		return fmt.Sprintf("%d %s 0 %d 0 %s\n", fcs.FCTYPE_USERNAMELOOKUP, token, requestId, name)
+	})
+}
+
+// LookupByUID returns the model with the uid, models.NotFoundError is
+// returned for an unknown uid.
+func (c *LookupClient) LookupByUID(ctx context.Context, uid uint64) (model models.MFCModel, err error) {
+	return c.lookup(ctx, func(token string, requestId int64) string {
+		return fmt.Sprintf("%d %s 0 %d %d\n", fcs.FCTYPE_USERNAMELOOKUP, token, requestId, uid)
+	})
+}
+
+// TokenId returns the token of the current connection, it is needed to
+// open the video streams.
+func (c *LookupClient) TokenId() (token string, err error) {
+	conn, err := c.connect(c.ctx)
+	if err != nil {
+		return
+	}
+	return conn.GetTokenId(), nil
+}
+
+// Close closes the connection, the running lookups fail with
+// ConnectionClosedError.
+func (c *LookupClient) Close() (err error) {
+	c.cancel()
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		err = conn.Close()
+	}
+	return
+}
+
+func (c *LookupClient) lookup(ctx context.Context, request func(token string, requestId int64) string) (model models.MFCModel, err error) {
+	requestId := nextRequestId()
+	result := make(chan fcs.Frame, 1)
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	select {
+	case <-conn.done:
+		// the pending lookups of the connection are already closed
+		c.mu.Unlock()
+		err = ConnectionClosedError
+		return
+	default:
+	}
+	c.pending[requestId] = &pendingLookup{conn: conn, result: result}
+	c.mu.Unlock()
+	defer c.forget(requestId)
+
+	if err = conn.SendString(request(conn.GetTokenId(), requestId)); err != nil {
+		return
+	}
+	select {
+	case frame, ok := <-result:
+		if !ok {
+			err = ConnectionClosedError
+			return
+		}
+		return models.FromFrame(frame)
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+}
+
+func (c *LookupClient) forget(requestId int64) {
+	c.mu.Lock()
+	delete(c.pending, requestId)
+	c.mu.Unlock()
+}
+
+// connect returns the current connection or dials a new one. A single
+// dial runs at a time, it is bound to the client and not to ctx, a caller
+// stops waiting for it when ctx is done.
+func (c *LookupClient) connect(ctx context.Context) (conn *lookupConn, err error) {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		err = ConnectionClosedError
+		return
+	}
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+		default:
+			conn = c.conn
+			c.mu.Unlock()
+			return
+		}
+	}
+	call := c.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		c.dialing = call
+		go c.dial(call)
+	}
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.conn, call.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+}
+
+func (c *LookupClient) dial(call *dialCall) {
+	defer close(call.done)
+	ws, err := newConnection(c.ctx, c.cfg, "", true, false)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialing = nil
+	if err != nil {
+		call.err = err
+		return
+	}
+	conn := &lookupConn{WSConnector: ws, done: make(chan struct{})}
+	ws.SetMsgHdlr(c.handle)
+	go func() {
+		ws.ReadForever()
+		ws.Close()
+		c.mu.Lock()
+		for requestId, p := range c.pending {
+			if p.conn == conn {
+				close(p.result)
+				delete(c.pending, requestId)
+			}
+		}
+		close(conn.done)
+		c.mu.Unlock()
+	}()
+	c.conn = conn
+	call.conn = conn
+}
+
+// handle passes the lookup responses to the waiting lookups, the other
+// messages are ignored.
+func (c *LookupClient) handle(msg string) error {
+	frames, _ := fcs.ParseMessage(msg)
+	for _, frame := range frames {
+		if frame.Type != fcs.FCTYPE_USERNAMELOOKUP {
+			continue
+		}
+		c.mu.Lock()
+		if p, ok := c.pending[frame.Arg1]; ok {
+			p.result <- frame
+			delete(c.pending, frame.Arg1)
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package ws_client_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gomfc/models"
+	"gomfc/ws_client"
+	"gomfc/ws_client/fcstest"
+)
+
+func TestLookupClient(t *testing.T) {
+	server := fcstest.NewServer()
+	defer server.Close()
+	const count = 10
+	for i := 0; i < count; i++ {
+		server.SetModel(fcstest.Model{Lv: fcstest.ModelLv, Nm: fmt.Sprintf("Model%d", i), Uid: uint64(1000 + i)})
+	}
+	// the answers come in the reverse order of the requests
+	server.SetLookupDelay(func(query string) time.Duration {
+		var i int
+		if _, err := fmt.Sscanf(query, "Model%d", &i); err != nil {
+			fmt.Sscanf(query, "10%d", &i)
+		}
+		return time.Duration(count-i) * 20 * time.Millisecond
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	client, err := ws_client.NewLookupClient(ctx, server.ClientConfig())
+	if err != nil {
+		t.Fatalf("NewLookupClient error: %s", err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			model, err := client.LookupByName(ctx, fmt.Sprintf("model%d", i))
+			if err != nil || model.Uid != uint64(1000+i) || !model.Exists {
+				t.Errorf("LookupByName(model%d) = %+v, %v", i, model, err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			model, err := client.LookupByUID(ctx, uint64(1000+i))
+			if err != nil || model.Nm != fmt.Sprintf("Model%d", i) {
+				t.Errorf("LookupByUID(%d) = %+v, %v", 1000+i, model, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err = client.LookupByName(ctx, "Nobody"); err != models.NotFoundError {
+		t.Errorf("LookupByName(Nobody) error %v, expect %v", err, models.NotFoundError)
+	}
+	if _, err = client.LookupByUID(ctx, 1); err != models.NotFoundError {
+		t.Errorf("LookupByUID(1) error %v, expect %v", err, models.NotFoundError)
+	}
+	if token, err := client.TokenId(); err != nil || token == "" {
+		t.Errorf("TokenId() = %q, %v", token, err)
+	}
+}
+
+func TestLookupClientReconnect(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	client, err := ws_client.NewLookupClient(ctx, server.ClientConfig())
+	if err != nil {
+		t.Fatalf("NewLookupClient error: %s", err)
+	}
+	defer client.Close()
+
+	// the lookup waits for an answer which never comes
+	server.SetLookupDelay(func(string) time.Duration { return time.Hour })
+	lookupErr := make(chan error, 1)
+	go func() {
+		_, err := client.LookupByName(ctx, "TestModel")
+		lookupErr <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	server.CloseConnections()
+	if err = <-lookupErr; err != ws_client.ConnectionClosedError {
+		t.Fatalf("lookup on a closed connection: %v", err)
+	}
+
+	server.SetLookupDelay(nil)
+	connections := server.Connections()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			model, err := client.LookupByName(ctx, "TestModel")
+			if err != nil || model.Uid != 100500 {
+				t.Errorf("lookup after reconnect = %+v, %v", model, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if dialed := server.Connections() - connections; dialed != 1 {
+		t.Errorf("connections dialed by the concurrent lookups: %d, expect: 1", dialed)
+	}
+
+	client.Close()
+	if _, err = client.LookupByName(ctx, "TestModel"); err != ws_client.ConnectionClosedError {
+		t.Fatalf("lookup after Close: %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package ws_client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gomfc/metrics"
+)
+
+type ConnEventType int
+
+const (
+	EventConnected ConnEventType = iota
+	EventConnectFailed
+	EventDisconnected
+	EventReconnected
+)
+
+var connEventVerbose = map[ConnEventType]string{
+	EventConnected:     "connected",
+	EventConnectFailed: "connect failed",
+	EventDisconnected:  "disconnected",
+	EventReconnected:   "reconnected",
+}
+
+func (t ConnEventType) String() string {
+	return connEventVerbose[t]
+}
+
+// ConnEvent reports a change of the supervised connection. Attempt is the
+// number of failed attempts since the connection was lost.
+type ConnEvent struct {
+	Type    ConnEventType
+	Err     error
+	Attempt int
+	Time    time.Time
+}
+
+type ConnEventHandler func(ConnEvent)
+
+// Backoff is an exponential delay between reconnect attempts.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+}
+
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     5 * time.Minute,
+	Factor:  2,
+}
+
+// Delay returns the delay before the attempt, attempts start from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		delay *= b.Factor
+		if delay >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	return time.Duration(delay)
+}
+
+// DefaultMinUptime is the time a connection has to stay up to reset the
+// backoff, a connection lost sooner counts as a failed attempt.
+const DefaultMinUptime = 30 * time.Second
+
+var SupervisorClosedError = errors.New("supervised connection closed")
+
+// SupervisedConnector keeps a websocket session alive. When the connection
+// is lost the challenge, the login, the model lookups and the room data
+// subscription are repeated with an exponential backoff. Without models
+// the connection only subscribes to the room data.
+type SupervisedConnector struct {
+	sync.Mutex
+	cfg          ClientConfig
+	modelNames   []string
+	allFlag      bool
+	backoff      Backoff
+	minUptime    time.Duration
+	msgHandler   WSMsgHandler
+	eventHandler ConnEventHandler
+	current      *WSConnector
+	ctx          context.Context
+	cancel       context.CancelFunc
+	// wake interrupts the backoff when a model is added
+	wake chan struct{}
+}
+
+func NewSupervisedConnector(cfg ClientConfig, modelNames []string, allFlag bool) *SupervisedConnector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SupervisedConnector{
+		cfg:        cfg,
+		modelNames: append([]string(nil), modelNames...),
+		allFlag:    allFlag,
+		backoff:    DefaultBackoff,
+		minUptime:  DefaultMinUptime,
+		ctx:        ctx,
+		cancel:     cancel,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (s *SupervisedConnector) SetMsgHdlr(handler WSMsgHandler) {
+	s.msgHandler = handler
+}
+
+func (s *SupervisedConnector) SetEventHdlr(handler ConnEventHandler) {
+	s.eventHandler = handler
+}
+
+func (s *SupervisedConnector) SetBackoff(backoff Backoff) {
+	s.backoff = backoff
+}
+
+func (s *SupervisedConnector) SetMinUptime(minUptime time.Duration) {
+	s.minUptime = minUptime
+}
+
+// AddModel requests the model on the current connection and after
+// every reconnect, while reconnecting the backoff delay is skipped.
+func (s *SupervisedConnector) AddModel(modelName string) (err error) {
+	s.Lock()
+	s.modelNames = append(s.modelNames, modelName)
+	current := s.current
+	s.Unlock()
+	if current != nil {
+		err = current.RequestModel(modelName)
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// RemoveModel stops requesting the model after reconnects, the states
+// of the model already subscribed to are still received.
+func (s *SupervisedConnector) RemoveModel(modelName string) {
+	s.Lock()
+	defer s.Unlock()
+	for i, name := range s.modelNames {
+		if strings.EqualFold(name, modelName) {
+			s.modelNames = append(s.modelNames[:i], s.modelNames[i+1:]...)
+			return
+		}
+	}
+}
+
+// Current returns the active connection or nil while reconnecting.
+func (s *SupervisedConnector) Current() *WSConnector {
+	s.Lock()
+	defer s.Unlock()
+	return s.current
+}
+
+func (s *SupervisedConnector) Close() (err error) {
+	s.cancel()
+	s.Lock()
+	current := s.current
+	s.Unlock()
+	if current != nil {
+		err = current.Close()
+	}
+	return
+}
+
+// ReadForever passes messages to the handler until Close is called or
+// the handler returns an error. Connection errors only cause a reconnect.
+func (s *SupervisedConnector) ReadForever() (err error) {
+	var attempt int
+	var connected bool
+	for {
+		ws, connErr := s.connect()
+		if connErr != nil {
+			attempt++
+			s.sendEvent(EventConnectFailed, connErr, attempt)
+			if !s.wait(attempt) {
+				return SupervisorClosedError
+			}
+			continue
+		}
+		if connected {
+			s.sendEvent(EventReconnected, nil, attempt)
+		} else {
+			s.sendEvent(EventConnected, nil, attempt)
+		}
+		connected = true
+		connectedAt := time.Now()
+
+		var handlerErr error
+		ws.SetMsgHdlr(func(msg string) error {
+			handlerErr = s.msgHandler(msg)
+			return handlerErr
+		})
+		connErr = ws.ReadForever()
+		ws.Close()
+		s.Lock()
+		s.current = nil
+		s.Unlock()
+		if handlerErr != nil {
+			return handlerErr
+		}
+		if s.ctx.Err() != nil {
+			return SupervisorClosedError
+		}
+		if time.Since(connectedAt) < s.minUptime {
+			attempt++
+		} else {
+			attempt = 0
+		}
+		s.sendEvent(EventDisconnected, connErr, attempt)
+		if attempt > 0 && !s.wait(attempt) {
+			return SupervisorClosedError
+		}
+	}
+}
+
+// wait sleeps for the backoff delay of the attempt, it returns false
+// when the supervisor is closed meanwhile.
+func (s *SupervisedConnector) wait(attempt int) bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-s.wake:
+	case <-time.After(s.backoff.Delay(attempt)):
+	}
+	return true
+}
+
+// ReadForeverContext is ReadForever stopped by cancelling the context.
+func (s *SupervisedConnector) ReadForeverContext(ctx context.Context) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-done:
+		}
+	}()
+	err = s.ReadForever()
+	if err == SupervisorClosedError && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+func (s *SupervisedConnector) connect() (ws *WSConnector, err error) {
+	s.Lock()
+	modelNames := append([]string(nil), s.modelNames...)
+	s.Unlock()
+	var firstModel string
+	if len(modelNames) > 0 {
+		firstModel, modelNames = modelNames[0], modelNames[1:]
+	}
+	ws, err = NewConnectionContext(s.ctx, s.cfg, firstModel, s.allFlag)
+	if err != nil {
+		return
+	}
+	for _, modelName := range modelNames {
+		if err = ws.RequestModel(modelName); err != nil {
+			ws.Close()
+			return
+		}
+	}
+	s.Lock()
+	s.current = ws
+	s.Unlock()
+	select {
+	case <-s.wake:
+	default:
+	}
+	return
+}
+
+var connectResults = map[ConnEventType]string{
+	EventConnected:     "connected",
+	EventReconnected:   "reconnected",
+	EventConnectFailed: "failed",
+}
+
+func (s *SupervisedConnector) sendEvent(eventType ConnEventType, err error, attempt int) {
+	if result, ok := connectResults[eventType]; ok {
+		metrics.WebsocketConnects.WithLabelValues(result).Inc()
+	}
+	if s.eventHandler == nil {
+		return
+	}
+	s.eventHandler(ConnEvent{
+		Type:    eventType,
+		Err:     err,
+		Attempt: attempt,
+		Time:    time.Now(),
+	})
+}
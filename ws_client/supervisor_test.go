@@ -0,0 +1,151 @@
+package ws_client_test
+
+import (
+	"testing"
+	"time"
+
+	"gomfc/models"
+	"gomfc/ws_client"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := ws_client.Backoff{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+	expects := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, expect := range expects {
+		if got := backoff.Delay(i + 1); got != expect {
+			t.Errorf("Delay(%d) got: %s, expect: %s", i+1, got, expect)
+		}
+	}
+}
+
+func TestSupervisedReconnect(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	supervisor := ws_client.NewSupervisedConnector(server.ClientConfig(), []string{"TestModel"}, true)
+	supervisor.SetBackoff(ws_client.Backoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond, Factor: 2})
+	events := make(chan ws_client.ConnEvent, 10)
+	supervisor.SetEventHdlr(func(event ws_client.ConnEvent) {
+		events <- event
+	})
+	states := make(chan models.MFCModel, 10)
+	supervisor.SetMsgHdlr(func(msg string) error {
+		model, err := models.GetModelData(msg)
+		if err == nil && model.Lv == models.ModelLv {
+			states <- model
+		}
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- supervisor.ReadForever()
+	}()
+
+	expectEvent := func(eventType ws_client.ConnEventType) {
+		select {
+		case event := <-events:
+			if event.Type != eventType {
+				t.Fatalf("got event %s, expect %s", event.Type, eventType)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+	expectState := func() {
+		select {
+		case <-states:
+		case <-time.After(testTimeout):
+			t.Fatal("no model state received")
+		}
+	}
+
+	expectEvent(ws_client.EventConnected)
+	expectState()
+	server.CloseConnections()
+	expectEvent(ws_client.EventDisconnected)
+	expectEvent(ws_client.EventReconnected)
+	expectState()
+
+	supervisor.Close()
+	select {
+	case err := <-done:
+		if err != ws_client.SupervisorClosedError {
+			t.Errorf("ReadForever got: %v, expect: %v", err, ws_client.SupervisorClosedError)
+		}
+	case <-time.After(testTimeout):
+		t.Error("ReadForever does not return after Close")
+	}
+}
+
+func TestSupervisedWithoutModels(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	supervisor := ws_client.NewSupervisedConnector(server.ClientConfig(), nil, true)
+	events := make(chan ws_client.ConnEvent, 10)
+	supervisor.SetEventHdlr(func(event ws_client.ConnEvent) {
+		events <- event
+	})
+	states := make(chan models.MFCModel, 10)
+	supervisor.SetMsgHdlr(func(msg string) error {
+		model, err := models.GetModelData(msg)
+		if err == nil && model.Lv == models.ModelLv {
+			states <- model
+		}
+		return nil
+	})
+	go supervisor.ReadForever()
+	defer supervisor.Close()
+
+	select {
+	case event := <-events:
+		if event.Type != ws_client.EventConnected {
+			t.Fatalf("got event %s (%v), expect %s", event.Type, event.Err, ws_client.EventConnected)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no connected event")
+	}
+	select {
+	case model := <-states:
+		if model.Nm != "TestModel" {
+			t.Errorf("room data got model %q", model.Nm)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no room data received")
+	}
+}
+
+func TestSupervisedQuickDisconnect(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	supervisor := ws_client.NewSupervisedConnector(server.ClientConfig(), []string{"TestModel"}, true)
+	delay := 200 * time.Millisecond
+	supervisor.SetBackoff(ws_client.Backoff{Initial: delay, Max: time.Second, Factor: 2})
+	events := make(chan ws_client.ConnEvent, 10)
+	supervisor.SetEventHdlr(func(event ws_client.ConnEvent) {
+		events <- event
+	})
+	supervisor.SetMsgHdlr(func(msg string) error { return nil })
+	go supervisor.ReadForever()
+	defer supervisor.Close()
+
+	expectEvent := func(eventType ws_client.ConnEventType, attempt int) ws_client.ConnEvent {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.Attempt != attempt {
+				t.Fatalf("got event %s (attempt %d), expect %s (attempt %d)", event.Type, event.Attempt, eventType, attempt)
+			}
+			return event
+		case <-time.After(testTimeout):
+			t.Fatalf("no %s event", eventType)
+		}
+		return ws_client.ConnEvent{}
+	}
+	expectEvent(ws_client.EventConnected, 0)
+	server.CloseConnections()
+	disconnected := expectEvent(ws_client.EventDisconnected, 1)
+	reconnected := expectEvent(ws_client.EventReconnected, 1)
+	if elapsed := reconnected.Time.Sub(disconnected.Time); elapsed < delay {
+		t.Errorf("reconnected after %s, expect the backoff of %s", elapsed, delay)
+	}
+	server.CloseConnections()
+	expectEvent(ws_client.EventDisconnected, 2)
+}